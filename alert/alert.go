@@ -0,0 +1,77 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Alert is the JSON body posted to AlertWebhookURL.
+type Alert struct {
+	Kind    string    `json:"kind"` // e.g. "scrub_corrupt"
+	Site    string    `json:"site,omitempty"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+var client = &http.Client{Timeout: 15 * time.Second}
+
+// Send logs the alert and, if webhookURL is set, POSTs it as JSON.
+// Delivery is best-effort: failures are logged, never returned to the caller's run.
+func Send(ctx context.Context, webhookURL string, a Alert) {
+	if a.Time.IsZero() {
+		a.Time = time.Now()
+	}
+
+	slog.Warn(
+		"alert",
+		"kind", a.Kind,
+		"site", a.Site,
+		"message", a.Message,
+	)
+
+	webhookURL = strings.TrimSpace(webhookURL)
+	if webhookURL == "" {
+		return
+	}
+
+	if err := post(ctx, webhookURL, a); err != nil {
+		slog.Error(
+			"alert: webhook failed",
+			"kind", a.Kind,
+			"err", err,
+		)
+	}
+}
+
+func post(ctx context.Context, url string, a Alert) error {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("marshal alert: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "httpBackupGo/1.0")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("http post: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("http status %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
+	}
+	return nil
+}
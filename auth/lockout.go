@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// Lockout blocks a key (a user name or a client address) for duration after
+// max failed attempts within window.
+type Lockout struct {
+	max      int
+	window   time.Duration
+	duration time.Duration
+
+	mu sync.Mutex
+	m  map[string]*failures
+}
+
+type failures struct {
+	count       int
+	first       time.Time
+	lockedUntil time.Time
+}
+
+func NewLockout(max int, window, duration time.Duration) *Lockout {
+	return &Lockout{max: max, window: window, duration: duration, m: map[string]*failures{}}
+}
+
+// Locked returns how long key stays locked.
+func (l *Lockout) Locked(key string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if f, ok := l.m[key]; ok {
+		if d := time.Until(f.lockedUntil); d > 0 {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// Fail records a failed attempt and reports whether key is now locked.
+func (l *Lockout) Fail(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	// Forget stale entries so scanners can't grow the map forever.
+	for k, f := range l.m {
+		if now.Sub(f.first) > l.window && now.After(f.lockedUntil) {
+			delete(l.m, k)
+		}
+	}
+
+	f, ok := l.m[key]
+	if !ok || now.Sub(f.first) > l.window {
+		f = &failures{first: now}
+		l.m[key] = f
+	}
+	f.count++
+	if f.count < l.max {
+		return false
+	}
+	f.lockedUntil = now.Add(l.duration)
+	f.count, f.first = 0, now
+	return true
+}
+
+// Reset forgets the failures of key (after a successful login).
+func (l *Lockout) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.m, key)
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockoutThreshold(t *testing.T) {
+	l := NewLockout(3, time.Minute, time.Hour)
+
+	for i := 1; i < 3; i++ {
+		if l.Fail("alice") {
+			t.Fatalf("locked after %d failures, want 3", i)
+		}
+		if _, locked := l.Locked("alice"); locked {
+			t.Fatalf("Locked after %d failures", i)
+		}
+	}
+	if !l.Fail("alice") {
+		t.Fatal("not locked after 3 failures")
+	}
+	d, locked := l.Locked("alice")
+	if !locked || d <= 0 || d > time.Hour {
+		t.Fatalf("Locked = %v, %v", d, locked)
+	}
+
+	// Keys are independent.
+	if _, locked := l.Locked("bob"); locked {
+		t.Error("another key is locked too")
+	}
+}
+
+func TestLockoutReset(t *testing.T) {
+	l := NewLockout(3, time.Minute, time.Hour)
+	l.Fail("alice")
+	l.Fail("alice")
+	l.Reset("alice") // a successful login
+	if l.Fail("alice") || l.Fail("alice") {
+		t.Fatal("failures before Reset still count")
+	}
+
+	l.Fail("alice")
+	l.Reset("alice")
+	if _, locked := l.Locked("alice"); locked {
+		t.Error("still locked after Reset")
+	}
+}
+
+func TestLockoutExpires(t *testing.T) {
+	l := NewLockout(2, 30*time.Millisecond, 30*time.Millisecond)
+
+	// Failures further apart than the window do not add up.
+	l.Fail("alice")
+	time.Sleep(50 * time.Millisecond)
+	if l.Fail("alice") {
+		t.Fatal("locked by failures outside the window")
+	}
+
+	if !l.Fail("alice") {
+		t.Fatal("not locked")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, locked := l.Locked("alice"); locked {
+		t.Error("still locked after the lock duration")
+	}
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sessions are the signed-in browsers. A session expires after ttl without
+// requests. Each session has its own CSRF token for the forms it posts.
+type Sessions struct {
+	ttl time.Duration
+
+	mu sync.Mutex
+	m  map[string]session
+}
+
+type session struct {
+	user    string
+	csrf    string
+	expires time.Time
+}
+
+func NewSessions(ttl time.Duration) *Sessions {
+	return &Sessions{ttl: ttl, m: map[string]session{}}
+}
+
+// Create starts a session for user and returns its ID (the cookie value).
+func (s *Sessions) Create(user string) string {
+	id := randomToken(32)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireLocked()
+	s.m[id] = session{user: user, csrf: randomToken(32), expires: time.Now().Add(s.ttl)}
+	return id
+}
+
+// Get returns the session's user and CSRF token and extends the session.
+func (s *Sessions) Get(id string) (user, csrf string, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.m[id]
+	if !ok || time.Now().After(sess.expires) {
+		delete(s.m, id)
+		return "", "", false
+	}
+	sess.expires = time.Now().Add(s.ttl)
+	s.m[id] = sess
+	return sess.user, sess.csrf, true
+}
+
+// Delete ends one session (logout).
+func (s *Sessions) Delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.m, id)
+}
+
+// DeleteUser ends every session of user except keep (e.g. after a password
+// change in that session).
+func (s *Sessions) DeleteUser(user, keep string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, sess := range s.m {
+		if id != keep && strings.EqualFold(sess.user, user) {
+			delete(s.m, id)
+		}
+	}
+}
+
+// NewSetupToken returns a random token for the first-run setup link.
+func NewSetupToken() string {
+	return randomToken(16)
+}
+
+func randomToken(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b) // never fails (crypto/rand)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (s *Sessions) expireLocked() {
+	now := time.Now()
+	for id, sess := range s.m {
+		if now.After(sess.expires) {
+			delete(s.m, id)
+		}
+	}
+}
@@ -0,0 +1,455 @@
+// Package auth holds the local users and API tokens of the web UI and API
+// (auth.json next to the config) and the login sessions and lockout state
+// (in memory: a restart signs everyone out and clears lockouts).
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"httpBackupGo/instance"
+	"httpBackupGo/storage"
+)
+
+// FileName is the user file, kept next to the config file.
+const FileName = "auth.json"
+
+// MinPasswordLen is the shortest accepted password.
+const MinPasswordLen = 8
+
+// lockTimeout bounds the wait for another process changing auth.json.
+const lockTimeout = 10 * time.Second
+
+// tokenPrefix marks API tokens so they are easy to spot in scripts and logs.
+const tokenPrefix = "hbg_"
+
+var (
+	ErrInvalid   = errors.New("invalid user name or password")
+	ErrExists    = errors.New("user already exists")
+	ErrNoUser    = errors.New("unknown user")
+	ErrBadToken  = errors.New("invalid API token")
+	ErrNoToken   = errors.New("unknown token")
+	errShortPass = fmt.Errorf("password must be at least %d characters", MinPasswordLen)
+)
+
+// Roles, from least to most privileged. Each role may do everything the
+// ones before it may.
+const (
+	RoleViewer   = "viewer"   // status, run history, logs, backup lists
+	RoleOperator = "operator" // also: start runs, download backups
+	RoleAdmin    = "admin"    // also: config, sites, retention, scheduler reload
+)
+
+var roleRank = map[string]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+// Allows reports whether role includes need.
+func Allows(role, need string) bool {
+	return roleRank[need] > 0 && roleRank[role] >= roleRank[need]
+}
+
+// User is a local account.
+type User struct {
+	Name         string    `json:"Name"`
+	PasswordHash string    `json:"PasswordHash"` // bcrypt
+	Role         string    `json:"Role"`
+	Created      time.Time `json:"Created"`
+
+	// Sites limits a viewer or operator to these sites (IDs or names).
+	// Empty means every site; admins always see every site.
+	Sites []string `json:"Sites,omitempty"`
+}
+
+// Can reports whether u has at least the role need.
+func (u User) Can(need string) bool {
+	return Allows(u.Role, need)
+}
+
+// Scoped reports whether u only sees some sites.
+func (u User) Scoped() bool {
+	return len(u.Sites) > 0 && u.Role != RoleAdmin
+}
+
+// SeesSite reports whether u may see the site with the given ID and name.
+func (u User) SeesSite(id, name string) bool {
+	if !u.Scoped() {
+		return true
+	}
+	for _, s := range u.Sites {
+		if s == id || strings.EqualFold(s, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Token is an API token. Only a hash of its secret is stored; the token
+// itself is shown once, when it is created.
+type Token struct {
+	ID      string    `json:"ID"`   // public part of the token
+	Name    string    `json:"Name"` // label, e.g. "nightly report"
+	User    string    `json:"User"`
+	Hash    string    `json:"Hash"` // SHA-256 of the secret part, hex
+	Created time.Time `json:"Created"`
+}
+
+// File is the content of auth.json.
+type File struct {
+	Users  []User  `json:"Users"`
+	Tokens []Token `json:"Tokens"`
+}
+
+// PathFor returns the user file belonging to a config file.
+func PathFor(cfgPath string) string {
+	return filepath.Join(filepath.Dir(cfgPath), FileName)
+}
+
+// Store reads and writes a user file. Every call reads the file, so changes
+// made by the `user` command are picked up by a running service.
+type Store struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// Load returns the users and tokens; a missing file means none.
+func (s *Store) Load() (File, error) {
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return File{}, nil
+		}
+		return File{}, err
+	}
+	var f File
+	if err := json.Unmarshal(b, &f); err != nil {
+		return File{}, fmt.Errorf("parse %s: %w", s.path, err)
+	}
+	// Users created before roles existed had full access.
+	for i := range f.Users {
+		if f.Users[i].Role == "" {
+			f.Users[i].Role = RoleAdmin
+		}
+	}
+	return f, nil
+}
+
+// HasUsers reports whether setup was done.
+func (s *Store) HasUsers() (bool, error) {
+	f, err := s.Load()
+	return len(f.Users) > 0, err
+}
+
+// Lookup returns the user with the given name.
+func (s *Store) Lookup(name string) (User, bool, error) {
+	f, err := s.Load()
+	if err != nil {
+		return User{}, false, err
+	}
+	i := f.user(name)
+	if i < 0 {
+		return User{}, false, nil
+	}
+	return f.Users[i], true, nil
+}
+
+// AddUser creates a user with a role and, for viewers and operators, an
+// optional list of sites.
+func (s *Store) AddUser(name, password, role string, sites []string) error {
+	name = strings.TrimSpace(name)
+	if err := checkUserName(name); err != nil {
+		return err
+	}
+	if err := checkRole(role, sites); err != nil {
+		return err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	return s.update(func(f *File) error {
+		if f.user(name) >= 0 {
+			return ErrExists
+		}
+		f.Users = append(f.Users, User{Name: name, PasswordHash: hash, Role: role, Sites: sites, Created: time.Now()})
+		return nil
+	})
+}
+
+// SetRole changes a user's role and sites.
+func (s *Store) SetRole(name, role string, sites []string) error {
+	if err := checkRole(role, sites); err != nil {
+		return err
+	}
+	return s.update(func(f *File) error {
+		i := f.user(name)
+		if i < 0 {
+			return ErrNoUser
+		}
+		f.Users[i].Role = role
+		f.Users[i].Sites = sites
+		return nil
+	})
+}
+
+// SetPassword replaces a user's password.
+func (s *Store) SetPassword(name, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	return s.update(func(f *File) error {
+		i := f.user(name)
+		if i < 0 {
+			return ErrNoUser
+		}
+		f.Users[i].PasswordHash = hash
+		return nil
+	})
+}
+
+// DeleteUser removes a user and their API tokens.
+func (s *Store) DeleteUser(name string) error {
+	return s.update(func(f *File) error {
+		i := f.user(name)
+		if i < 0 {
+			return ErrNoUser
+		}
+		gone := f.Users[i].Name
+		f.Users = append(f.Users[:i], f.Users[i+1:]...)
+		tokens := f.Tokens[:0]
+		for _, t := range f.Tokens {
+			if !strings.EqualFold(t.User, gone) {
+				tokens = append(tokens, t)
+			}
+		}
+		f.Tokens = tokens
+		return nil
+	})
+}
+
+// dummyHash is compared against for unknown users, so a login takes as
+// long whether or not the user exists.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("no such user"), bcrypt.DefaultCost)
+
+// Authenticate checks a password. Unknown users and wrong passwords both
+// return ErrInvalid.
+func (s *Store) Authenticate(name, password string) (User, error) {
+	u, ok, err := s.Lookup(strings.TrimSpace(name))
+	if err != nil {
+		return User{}, err
+	}
+	hash := dummyHash
+	if ok {
+		hash = []byte(u.PasswordHash)
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || !ok {
+		return User{}, ErrInvalid
+	}
+	return u, nil
+}
+
+// CreateToken creates an API token for user and returns it; it cannot be
+// shown again.
+func (s *Store) CreateToken(user, label string) (string, Token, error) {
+	id, err := randomHex(6)
+	if err != nil {
+		return "", Token{}, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", Token{}, err
+	}
+	t := Token{
+		ID:      id,
+		Name:    strings.TrimSpace(label),
+		Hash:    hashSecret(secret),
+		Created: time.Now(),
+	}
+	err = s.update(func(f *File) error {
+		i := f.user(user)
+		if i < 0 {
+			return ErrNoUser
+		}
+		t.User = f.Users[i].Name // as stored, whatever case user was given in
+		f.Tokens = append(f.Tokens, t)
+		return nil
+	})
+	if err != nil {
+		return "", Token{}, err
+	}
+	return tokenPrefix + id + "_" + secret, t, nil
+}
+
+// Tokens returns the tokens of user, or all tokens when user is empty.
+func (s *Store) Tokens(user string) ([]Token, error) {
+	f, err := s.Load()
+	if err != nil {
+		return nil, err
+	}
+	out := []Token{}
+	for _, t := range f.Tokens {
+		if user == "" || strings.EqualFold(t.User, user) {
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+// RevokeToken deletes a token. A non-empty user may only revoke their own.
+func (s *Store) RevokeToken(user, id string) error {
+	return s.update(func(f *File) error {
+		for i, t := range f.Tokens {
+			if t.ID == id && (user == "" || strings.EqualFold(t.User, user)) {
+				f.Tokens = append(f.Tokens[:i], f.Tokens[i+1:]...)
+				return nil
+			}
+		}
+		return ErrNoToken
+	})
+}
+
+// VerifyToken returns the user an API token belongs to.
+func (s *Store) VerifyToken(token string) (User, error) {
+	rest, ok := strings.CutPrefix(token, tokenPrefix)
+	if !ok {
+		return User{}, ErrBadToken
+	}
+	id, secret, ok := strings.Cut(rest, "_")
+	if !ok {
+		return User{}, ErrBadToken
+	}
+
+	f, err := s.Load()
+	if err != nil {
+		return User{}, err
+	}
+	for _, t := range f.Tokens {
+		if t.ID != id {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hashSecret(secret))) != 1 {
+			return User{}, ErrBadToken
+		}
+		if i := f.user(t.User); i >= 0 {
+			return f.Users[i], nil
+		}
+	}
+	return User{}, ErrBadToken
+}
+
+// update applies fn to the file and saves it (0600: it holds password hashes).
+// The file is re-read and written under "auth.json.lock", since the `user`
+// command changes it while the service runs.
+func (s *Store) update(fn func(f *File) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lock, err := instance.AcquireWait(s.path+".lock", "auth", lockTimeout)
+	if err != nil {
+		return fmt.Errorf("lock users: %w", err)
+	}
+	defer lock.Release()
+
+	f, err := s.Load()
+	if err != nil {
+		return err
+	}
+	if err := fn(&f); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal users: %w", err)
+	}
+	b = append(b, '\n')
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+	tmp := s.path + storage.TempSuffix
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return fmt.Errorf("write users: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("replace users: %w", err)
+	}
+	return nil
+}
+
+// user returns the index of the named user (case-insensitive), or -1.
+func (f *File) user(name string) int {
+	for i, u := range f.Users {
+		if strings.EqualFold(u.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func checkUserName(name string) error {
+	if name == "" || len(name) > 64 {
+		return errors.New("user name must be 1 to 64 characters")
+	}
+	for _, r := range name {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return errors.New("user name must not contain spaces or control characters")
+		}
+	}
+	return nil
+}
+
+func checkRole(role string, sites []string) error {
+	if roleRank[role] == 0 {
+		return fmt.Errorf("unknown role %q (use %s, %s or %s)", role, RoleViewer, RoleOperator, RoleAdmin)
+	}
+	if role == RoleAdmin && len(sites) > 0 {
+		return errors.New("admins always see every site; sites can only be set for viewers and operators")
+	}
+	return nil
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLen {
+		return "", errShortPass
+	}
+	// bcrypt ignores everything after 72 bytes; refuse rather than truncate.
+	if len(password) > 72 {
+		return "", errors.New("password must be at most 72 bytes")
+	}
+	b, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
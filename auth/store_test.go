@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	s := NewStore(filepath.Join(t.TempDir(), FileName))
+	if err := s.AddUser("Alice", "correct horse", RoleOperator, []string{"shop"}); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := newTestStore(t)
+
+	u, err := s.Authenticate("alice", "correct horse")
+	if err != nil {
+		t.Fatalf("right password: %v", err)
+	}
+	if u.Name != "Alice" || u.Role != RoleOperator {
+		t.Errorf("user = %+v", u)
+	}
+
+	for _, tc := range []struct{ name, user, password string }{
+		{"wrong password", "Alice", "wrong horse"},
+		{"empty password", "Alice", ""},
+		{"unknown user", "bob", "correct horse"},
+		{"unknown user, empty password", "bob", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// Both cases must look the same to the caller.
+			if _, err := s.Authenticate(tc.user, tc.password); !errors.Is(err, ErrInvalid) {
+				t.Errorf("err = %v, want ErrInvalid", err)
+			}
+		})
+	}
+}
+
+func TestAddUserRejects(t *testing.T) {
+	s := newTestStore(t)
+	for _, tc := range []struct{ name, user, password, role string }{
+		{"duplicate, other case", "ALICE", "long enough", RoleViewer},
+		{"short password", "bob", "short", RoleViewer},
+		{"unknown role", "bob", "long enough", "root"},
+		{"space in name", "bo b", "long enough", RoleViewer},
+		{"password over 72 bytes", "bob", strings.Repeat("x", 73), RoleViewer},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := s.AddUser(tc.user, tc.password, tc.role, nil); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+	if err := s.AddUser("root", "long enough", RoleAdmin, []string{"shop"}); err == nil {
+		t.Error("admin with sites: no error")
+	}
+}
+
+func TestTokens(t *testing.T) {
+	s := newTestStore(t)
+
+	token, tok, err := s.CreateToken("alice", "ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.User != "Alice" {
+		t.Errorf("token owner = %q, want the stored name", tok.User)
+	}
+	if f, _ := s.Load(); strings.Contains(f.Tokens[0].Hash, strings.TrimPrefix(token, tokenPrefix+tok.ID+"_")) {
+		t.Error("the token secret is stored in clear")
+	}
+
+	u, err := s.VerifyToken(token)
+	if err != nil {
+		t.Fatalf("VerifyToken: %v", err)
+	}
+	if u.Name != "Alice" {
+		t.Errorf("user = %q", u.Name)
+	}
+
+	// Flip the last character of the secret.
+	last := token[len(token)-1]
+	flipped := byte('0')
+	if last == '0' {
+		flipped = '1'
+	}
+	for _, bad := range []string{
+		token[:len(token)-1] + string(flipped),
+		strings.TrimPrefix(token, tokenPrefix),
+		tokenPrefix + tok.ID,
+		tokenPrefix + "000000000000_" + strings.Repeat("0", 64),
+		"",
+	} {
+		if _, err := s.VerifyToken(bad); !errors.Is(err, ErrBadToken) {
+			t.Errorf("VerifyToken(%q) = %v, want ErrBadToken", bad, err)
+		}
+	}
+
+	// Only the owner (or an admin, with an empty user) revokes.
+	if err := s.AddUser("bob", "long enough", RoleViewer, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RevokeToken("bob", tok.ID); !errors.Is(err, ErrNoToken) {
+		t.Errorf("revoke by another user = %v, want ErrNoToken", err)
+	}
+	if err := s.RevokeToken("ALICE", tok.ID); err != nil {
+		t.Fatalf("revoke by owner: %v", err)
+	}
+	if _, err := s.VerifyToken(token); !errors.Is(err, ErrBadToken) {
+		t.Errorf("revoked token: err = %v, want ErrBadToken", err)
+	}
+}
+
+func TestTokenOfDeletedUser(t *testing.T) {
+	s := newTestStore(t)
+	token, _, err := s.CreateToken("Alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.VerifyToken(token); !errors.Is(err, ErrBadToken) {
+		t.Errorf("err = %v, want ErrBadToken", err)
+	}
+
+	// A new user of the same name does not inherit it.
+	if err := s.AddUser("alice", "long enough", RoleAdmin, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.VerifyToken(token); !errors.Is(err, ErrBadToken) {
+		t.Errorf("after re-adding the user: err = %v, want ErrBadToken", err)
+	}
+}
+
+func TestSeesSite(t *testing.T) {
+	scoped := User{Role: RoleOperator, Sites: []string{"Shop", "id-2"}}
+	for _, tc := range []struct {
+		user     User
+		id, name string
+		want     bool
+	}{
+		{scoped, "id-1", "shop", true},
+		{scoped, "id-2", "blog", true},
+		{scoped, "id-3", "blog", false},
+		{User{Role: RoleViewer}, "id-3", "blog", true},
+		{User{Role: RoleAdmin}, "id-3", "blog", true},
+	} {
+		if got := tc.user.SeesSite(tc.id, tc.name); got != tc.want {
+			t.Errorf("%v SeesSite(%q, %q) = %v, want %v", tc.user.Sites, tc.id, tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// compressedFormats are formats that are already compressed, by their leading
+// bytes; gzipping them again only costs CPU. ext is what the file is stored as.
+var compressedFormats = []struct {
+	magic []byte
+	ext   string
+}{
+	{[]byte("PK\x03\x04"), ".zip"},
+	{[]byte("PK\x05\x06"), ".zip"}, // empty zip
+	{[]byte{0x1f, 0x8b}, ".gz"},
+	{[]byte("BZh"), ".bz2"},
+	{[]byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, ".xz"},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, ".zst"},
+	{[]byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}, ".7z"},
+	{[]byte("Rar!\x1a\x07"), ".rar"},
+	{[]byte{0x04, 0x22, 0x4d, 0x18}, ".lz4"},
+}
+
+// compressedTypes are Content-Types that are compressed by definition.
+var compressedTypes = map[string]string{
+	"application/zip":              ".zip",
+	"application/x-zip-compressed": ".zip",
+	"application/gzip":             ".gz",
+	"application/x-gzip":           ".gz",
+	"application/x-bzip2":          ".bz2",
+	"application/x-xz":             ".xz",
+	"application/zstd":             ".zst",
+	"application/x-7z-compressed":  ".7z",
+	"application/vnd.rar":          ".rar",
+	"application/x-rar-compressed": ".rar",
+}
+
+// sniff returns the extension of a compressed format recognised by its
+// leading bytes, or "".
+func sniff(head []byte) string {
+	for _, f := range compressedFormats {
+		if bytes.HasPrefix(head, f.magic) {
+			return f.ext
+		}
+	}
+	return ""
+}
+
+// maybeGzip gzips br unless the response is already compressed, judged by
+// Content-Encoding, Content-Type or the payload's magic bytes. It returns the
+// reader to store and whether it compresses; the caller must Close it.
+func maybeGzip(resp *http.Response, br *bufio.Reader) (io.ReadCloser, bool) {
+	if alreadyCompressed(resp, br) {
+		return io.NopCloser(br), false
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		zw := gzip.NewWriter(pw)
+		_, err := io.Copy(zw, br)
+		if err == nil {
+			err = zw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, true
+}
+
+func alreadyCompressed(resp *http.Response, br *bufio.Reader) bool {
+	// net/http strips Content-Encoding when it decoded the body itself
+	// (resp.Uncompressed); anything left means the bytes are still encoded.
+	if enc := strings.TrimSpace(resp.Header.Get("Content-Encoding")); enc != "" && !strings.EqualFold(enc, "identity") {
+		return true
+	}
+
+	if ct, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil && compressedTypes[ct] != "" {
+		return true
+	}
+
+	// Peek returns what it has on a short body (with an error we don't need).
+	head, _ := br.Peek(8)
+	return sniff(head) != ""
+}
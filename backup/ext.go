@@ -0,0 +1,87 @@
+package backup
+
+import (
+	"bufio"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"httpBackupGo/catalog"
+	"httpBackupGo/config"
+	"httpBackupGo/encrypt"
+	"httpBackupGo/storage"
+)
+
+// plainTypes maps uncompressed Content-Types to an extension.
+// Generic types (application/octet-stream, text/html) are deliberately absent.
+var plainTypes = map[string]string{
+	"application/json":  ".json",
+	"application/sql":   ".sql",
+	"application/x-sql": ".sql",
+	"application/xml":   ".xml",
+	"text/xml":          ".xml",
+	"text/csv":          ".csv",
+	"text/plain":        ".txt",
+	"application/x-tar": ".tar",
+}
+
+// urlExts are the extensions trusted from a URL path. Anything else there is
+// more likely a script (".php", ".aspx") than a description of the payload.
+var urlExts = map[string]bool{
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true,
+	".7z": true, ".rar": true, ".tar": true, ".sql": true, ".json": true,
+	".xml": true, ".csv": true, ".txt": true, ".dump": true, ".bak": true,
+}
+
+// detectExt picks the stored extension of a download, first match wins:
+//
+//  1. the site's Extension override
+//  2. the filename in Content-Disposition
+//  3. the Content-Type
+//  4. the URL path (known data extensions only)
+//  5. the payload's magic bytes
+//
+// and ".bin" when nothing is known.
+func detectExt(site config.Site, resp *http.Response, br *bufio.Reader) string {
+	if site.Extension != "" && !reservedExt(site.Extension) {
+		return site.Extension
+	}
+
+	if cd := resp.Header.Get("Content-Disposition"); cd != "" {
+		if _, params, err := mime.ParseMediaType(cd); err == nil {
+			ext := catalog.FileExt(path.Base(strings.ReplaceAll(params["filename"], `\`, "/")))
+			if ext != "" && !reservedExt(ext) {
+				return ext
+			}
+		}
+	}
+
+	if ct, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		if ext := compressedTypes[ct]; ext != "" {
+			return ext
+		}
+		if ext := plainTypes[ct]; ext != "" {
+			return ext
+		}
+	}
+
+	if resp.Request != nil {
+		ext := catalog.FileExt(path.Base(resp.Request.URL.Path))
+		if last := path.Ext(ext); urlExts[last] {
+			return ext
+		}
+	}
+
+	head, _ := br.Peek(8)
+	if ext := sniff(head); ext != "" {
+		return ext
+	}
+	return ".bin"
+}
+
+// reservedExt reports extensions the payload must not end in: ".enc" marks
+// our own encryption and ".tmp" an unfinished upload.
+func reservedExt(ext string) bool {
+	return strings.HasSuffix(ext, encrypt.Suffix) || strings.HasSuffix(ext, storage.TempSuffix)
+}
@@ -1,24 +1,32 @@
 package backup
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
-	"os"
-	"path/filepath"
+	"path"
 	"strings"
 	"sync"
 	"time"
 
+	"httpBackupGo/alert"
+	"httpBackupGo/catalog"
 	"httpBackupGo/config"
+	"httpBackupGo/encrypt"
+	"httpBackupGo/mirror"
 	"httpBackupGo/retention"
 )
 
 type Runner struct {
 	HTTPClient  *http.Client
 	MaxParallel int
+
+	// Mirror, if set, receives every saved backup for async replication.
+	Mirror *mirror.Replicator
 }
 
 // NewRunner creates a runner with sane defaults.
@@ -36,9 +44,19 @@ func NewRunner(maxParallel int) *Runner {
 	}
 }
 
+// Result is the outcome of one site in a run. Err is nil when the backup
+// was saved; Entry is then the new catalog entry.
+type Result struct {
+	Site     string
+	Entry    catalog.Entry
+	Started  time.Time
+	Duration time.Duration
+	Err      error
+}
+
 // RunAllEnabled runs backups for all enabled sites.
 // Each enabled site downloads concurrently, limited by MaxParallel.
-func (r *Runner) RunAllEnabled(ctx context.Context, cfg config.Config) {
+func (r *Runner) RunAllEnabled(ctx context.Context, cfg config.Config) []Result {
 	sites := make([]config.Site, 0, len(cfg.Sites))
 	for _, s := range cfg.Sites {
 		if s.Enabled {
@@ -48,20 +66,26 @@ func (r *Runner) RunAllEnabled(ctx context.Context, cfg config.Config) {
 
 	if len(sites) == 0 {
 		slog.Info("backup: no enabled sites")
-		return
+		return nil
 	}
+	return r.Run(ctx, cfg, sites)
+}
 
+// Run backs up the given sites, enabled or not, concurrently (limited by
+// MaxParallel). The results are in the order of sites.
+func (r *Runner) Run(ctx context.Context, cfg config.Config, sites []config.Site) []Result {
+	results := make([]Result, len(sites))
 	sem := make(chan struct{}, r.MaxParallel)
 	var wg sync.WaitGroup
 
 	slog.Info(
 		"backup: starting run",
-		"enabled_sites", len(sites),
+		"sites", len(sites),
 		"max_parallel", r.MaxParallel,
 	)
 
-	for _, site := range sites {
-		site := site // capture
+	for i, site := range sites {
+		results[i].Site = site.Name
 		wg.Add(1)
 
 		go func() {
@@ -73,10 +97,15 @@ func (r *Runner) RunAllEnabled(ctx context.Context, cfg config.Config) {
 				defer func() { <-sem }()
 			case <-ctx.Done():
 				slog.Warn("backup: run aborted by context", "site", site.Name)
+				results[i].Err = ctx.Err()
 				return
 			}
 
-			if err := r.RunOneSite(ctx, cfg, site); err != nil {
+			results[i].Started = time.Now()
+			entry, err := r.RunOneSite(ctx, cfg, site)
+			results[i].Entry, results[i].Err = entry, err
+			results[i].Duration = time.Since(results[i].Started)
+			if err != nil {
 				slog.Error(
 					"backup: site failed",
 					"site", site.Name,
@@ -95,113 +124,174 @@ func (r *Runner) RunAllEnabled(ctx context.Context, cfg config.Config) {
 
 	wg.Wait()
 	slog.Info("backup: run finished")
+	return results
 }
 
-// RunOneSite performs the actual download and saves it to:
+// RunOneSite performs the actual download and stores it in the site's
+// storage backend under the key from the site's layout, by default:
 //
-//	<BackupFolder>/<Name>/backup_<Name>_DD-MM-YYYY_HH-mm-ss.zip
-func (r *Runner) RunOneSite(ctx context.Context, cfg config.Config, site config.Site) error {
+//	<Slug>/backup_<Slug>_DD-MM-YYYY_HH-mm-ss<ext>
+//
+// With the default local backend that is a file under BackupFolder.
+// The extension comes from the download (see detectExt), e.g. ".zip" or
+// ".sql"; ".gz" is appended when the site compresses it. When encryption is
+// enabled for the site, ".enc" is appended and only the ciphertext ever
+// reaches the backend. The new catalog entry is returned.
+func (r *Runner) RunOneSite(ctx context.Context, cfg config.Config, site config.Site) (catalog.Entry, error) {
 	start := time.Now()
 
 	name := strings.TrimSpace(site.Name)
 	if name == "" {
-		return fmt.Errorf("site name is empty")
+		return catalog.Entry{}, fmt.Errorf("site name is empty")
 	}
 	url := strings.TrimSpace(site.Url)
 	if url == "" {
-		return fmt.Errorf("site url is empty")
+		return catalog.Entry{}, fmt.Errorf("site url is empty")
+	}
+
+	ix, err := catalog.ForSite(cfg, site)
+	if err != nil {
+		return catalog.Entry{}, fmt.Errorf("storage: %w", err)
 	}
 
-	base := filepath.Clean(cfg.BackupFolder)
-	siteDir := filepath.Join(base, name)
+	// A changed slug moves the existing backups first so retention keeps
+	// counting them. A failed move is retried on the next run.
+	if _, err := ix.FollowRename(ctx, cfg); err != nil {
+		slog.Warn("backup: could not move backups after rename", "site", name, "err", err)
+	}
 
-	// Ensure folder exists
-	if err := os.MkdirAll(siteDir, 0o755); err != nil {
-		return fmt.Errorf("mkdir %q: %w", siteDir, err)
+	// Load the key before downloading: never fall back to storing plaintext.
+	var key []byte
+	if enc := encrypt.ForSite(cfg, site); enc.Enabled {
+		key, err = encrypt.LoadKey(enc)
+		if err != nil {
+			return catalog.Entry{}, fmt.Errorf("encryption key: %w", err)
+		}
 	}
 
-	ts := time.Now().Format("02-01-2006_15-04-05")
-	filename := fmt.Sprintf("backup_%s_%s.zip", name, ts)
-	outPath := filepath.Join(siteDir, filename)
+	// Fail fast before asking the site for a (possibly expensive) export.
+	if err := ensureSpace(ctx, cfg, ix, 0); err != nil {
+		return catalog.Entry{}, err
+	}
 
-	// Create temp file first, then rename (atomic-ish)
-	tmpPath := outPath + ".tmp"
+	now := time.Now()
 
 	slog.Info(
 		"backup: download started",
 		"site", name,
 		"url", url,
-		"out_path", outPath,
+		"storage", ix.Backend.String(),
+		"encrypted", key != nil,
 	)
 
 	// Build request with context
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return fmt.Errorf("new request: %w", err)
+		return catalog.Entry{}, fmt.Errorf("new request: %w", err)
 	}
 	req.Header.Set("User-Agent", "httpBackupGo/1.0")
 
 	resp, err := r.HTTPClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("http get: %w", err)
+		return catalog.Entry{}, fmt.Errorf("http get: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		// Read a tiny snippet for debugging (don’t blow memory)
 		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
-		return fmt.Errorf("http status %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
+		return catalog.Entry{}, fmt.Errorf("http status %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
 	}
 
-	f, err := os.Create(tmpPath)
-	if err != nil {
-		return fmt.Errorf("create %q: %w", tmpPath, err)
+	// Now the size may be known; check again including it.
+	var src io.Reader = resp.Body
+	if resp.ContentLength > 0 {
+		if err := ensureSpace(ctx, cfg, ix, resp.ContentLength); err != nil {
+			return catalog.Entry{}, err
+		}
 	}
-	defer func() { _ = f.Close() }()
-
-	// Stream copy
-	written, err := io.Copy(f, resp.Body)
-	if err != nil {
-		_ = os.Remove(tmpPath)
-		return fmt.Errorf("write file: %w", err)
+	if cfg.MinFreeMB > 0 {
+		src = &spaceGuard{r: resp.Body, paths: localPaths(cfg, ix), reserve: uint64(cfg.MinFreeMB) << 20}
 	}
 
-	// Ensure data flushed
-	if err := f.Sync(); err != nil {
-		_ = os.Remove(tmpPath)
-		return fmt.Errorf("sync file: %w", err)
+	br := bufio.NewReader(src)
+	ext := detectExt(site, resp, br)
+
+	// Compress first: encrypted bytes don't compress.
+	var body io.Reader = br
+	compressed := false
+	if site.Compress {
+		var zr io.ReadCloser
+		zr, compressed = maybeGzip(resp, br)
+		defer zr.Close()
+		body = zr
+		if compressed {
+			ext += ".gz"
+		}
 	}
-	if err := f.Close(); err != nil {
-		_ = os.Remove(tmpPath)
-		return fmt.Errorf("close file: %w", err)
+	if key != nil {
+		enc := encrypt.Encrypt(body, key)
+		defer enc.Close()
+		body = enc
+		ext += encrypt.Suffix
 	}
 
-	// Replace tmp with final
-	if err := os.Rename(tmpPath, outPath); err != nil {
-		_ = os.Remove(tmpPath)
-		return fmt.Errorf("rename to final: %w", err)
+	// Stream to storage (hashing on the fly for the catalog).
+	// The hash covers the stored bytes, so scrub can verify without the key.
+	// The backend commits atomically, so a failed copy leaves nothing behind.
+	objKey, written, sum, err := store(ctx, cfg, ix, now, ext, body)
+	if err != nil {
+		if errors.Is(err, ErrLowSpace) {
+			alert.Send(ctx, cfg.AlertWebhookURL, alert.Alert{
+				Kind:    "low_space",
+				Site:    name,
+				Message: "download aborted: " + err.Error(),
+			})
+		}
+		return catalog.Entry{}, err
 	}
 
 	slog.Info(
 		"backup: saved",
 		"site", name,
 		"url", url,
-		"path", outPath,
+		"key", objKey,
 		"bytes", written,
+		"compressed", compressed,
 		"status_code", resp.StatusCode,
 		"duration_ms", time.Since(start).Milliseconds(),
 	)
 
+	entry := catalog.Entry{
+		Key:     objKey,
+		Name:    path.Base(objKey),
+		Size:    written,
+		SHA256:  sum,
+		Created: time.Now(),
+	}
+
+	// Record in catalog (best-effort; a reindex can always rebuild it)
+	if err := ix.Add(ctx, entry); err != nil {
+		slog.Warn(
+			"catalog: update error",
+			"site", name,
+			"err", err,
+		)
+	}
+
+	// Hand off to the mirrors (async; never blocks or fails the backup)
+	r.Mirror.Enqueue(cfg, site, entry)
+
 	// Apply retention (best-effort; never fail the backup)
-	if err := retention.CleanupSite(siteDir, name, cfg.Retention); err != nil {
+	if err := retention.CleanupSite(ctx, ix, cfg.Retention); err != nil {
 		slog.Warn(
 			"retention: cleanup error",
 			"site", name,
-			"site_dir", siteDir,
+			"storage", ix.Backend.String(),
 			"retention", cfg.Retention,
 			"err", err,
 		)
 	}
 
-	return nil
+	return entry, nil
 }
@@ -0,0 +1,128 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+
+	"httpBackupGo/alert"
+	"httpBackupGo/catalog"
+	"httpBackupGo/config"
+	"httpBackupGo/diskspace"
+	"httpBackupGo/retention"
+	"httpBackupGo/storage"
+)
+
+// ErrLowSpace is returned (wrapped) when a download would leave less than
+// Config.MinFreeMB free.
+var ErrLowSpace = errors.New("not enough free disk space")
+
+// spaceCheckEvery is how many downloaded bytes pass between two free-space
+// checks while a download runs.
+const spaceCheckEvery = 32 << 20
+
+// localPaths are the local folders a site's download is written to:
+// BackupFolder (catalogs, spool) and the root of a local backend.
+func localPaths(cfg config.Config, ix catalog.Index) []string {
+	paths := []string{cfg.BackupFolder}
+	if l, ok := ix.Backend.(*storage.Local); ok && filepath.Clean(l.Root) != filepath.Clean(cfg.BackupFolder) {
+		paths = append(paths, l.Root)
+	}
+	return paths
+}
+
+// lowSpace returns an ErrLowSpace error for the first path with less than
+// reserve+need bytes free. Paths that cannot be checked are skipped.
+func lowSpace(paths []string, reserve, need uint64) error {
+	for _, p := range paths {
+		free, err := diskspace.Free(p)
+		if errors.Is(err, diskspace.ErrUnsupported) {
+			return nil
+		}
+		if err != nil {
+			slog.Warn("disk space: check failed", "path", p, "err", err)
+			continue
+		}
+		if free < reserve+need {
+			if need == 0 {
+				return fmt.Errorf("%w: %s has %d MiB free, below the %d MiB reserve",
+					ErrLowSpace, p, free>>20, reserve>>20)
+			}
+			return fmt.Errorf("%w: %s has %d MiB free, need %d MiB plus %d MiB reserve",
+				ErrLowSpace, p, free>>20, need>>20, reserve>>20)
+		}
+	}
+	return nil
+}
+
+// ensureSpace checks that a download of `expected` bytes (<= 0 when unknown)
+// keeps MinFreeMB free. When it does not and EmergencyRetention is set, the
+// site's oldest local backups are deleted first. Low space always raises an
+// alert; the error is returned when there still is not enough.
+func ensureSpace(ctx context.Context, cfg config.Config, ix catalog.Index, expected int64) error {
+	if cfg.MinFreeMB <= 0 {
+		return nil
+	}
+	paths := localPaths(cfg, ix)
+	reserve := uint64(cfg.MinFreeMB) << 20
+	need := uint64(max(expected, 0))
+
+	err := lowSpace(paths, reserve, need)
+	if err == nil {
+		return nil
+	}
+
+	// Deleting remote backups frees nothing locally.
+	if _, local := ix.Backend.(*storage.Local); local && cfg.EmergencyRetention > 0 {
+		slog.Warn(
+			"disk space: running emergency retention",
+			"site", ix.Site,
+			"keep", cfg.EmergencyRetention,
+			"err", err,
+		)
+		if cerr := retention.CleanupSite(ctx, ix, cfg.EmergencyRetention); cerr != nil {
+			slog.Warn("disk space: emergency retention failed", "site", ix.Site, "err", cerr)
+		}
+
+		before := err
+		if err = lowSpace(paths, reserve, need); err == nil {
+			alert.Send(ctx, cfg.AlertWebhookURL, alert.Alert{
+				Kind:    "low_space",
+				Site:    ix.Site,
+				Message: fmt.Sprintf("%v; emergency retention reduced the site to %d backups", before, cfg.EmergencyRetention),
+			})
+			return nil
+		}
+	}
+
+	alert.Send(ctx, cfg.AlertWebhookURL, alert.Alert{
+		Kind:    "low_space",
+		Site:    ix.Site,
+		Message: err.Error(),
+	})
+	return err
+}
+
+// spaceGuard aborts a running download with ErrLowSpace once free space
+// drops below the reserve, before the disk fills up completely.
+type spaceGuard struct {
+	r       io.Reader
+	paths   []string
+	reserve uint64
+	n       int64
+}
+
+func (g *spaceGuard) Read(p []byte) (int, error) {
+	n, err := g.r.Read(p)
+	g.n += int64(n)
+	if g.n >= spaceCheckEvery {
+		g.n = 0
+		if lerr := lowSpace(g.paths, g.reserve, 0); lerr != nil {
+			return n, lerr
+		}
+	}
+	return n, err
+}
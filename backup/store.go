@@ -0,0 +1,71 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"httpBackupGo/catalog"
+	"httpBackupGo/config"
+	"httpBackupGo/storage"
+)
+
+// store writes body to the site's backend under the key from its layout and
+// returns the key, size and hex SHA-256 of what was stored.
+//
+// The key is checked before anything is written so a site cannot escape its
+// backend's root (the local backend checks the resolved path again).
+//
+// Layouts with {hash} need the checksum before the key is known, so the
+// body is spooled to a temp file under the state folder first.
+func store(ctx context.Context, cfg config.Config, ix catalog.Index, when time.Time, ext string, body io.Reader) (string, int64, string, error) {
+	h := sha256.New()
+
+	if !ix.Layout.NeedsHash() {
+		key := ix.Layout.Key(when, ext, "")
+		if err := storage.CheckKey(key); err != nil {
+			return "", 0, "", err
+		}
+		written, err := ix.Backend.Put(ctx, key, io.TeeReader(body, h))
+		if err != nil {
+			return "", written, "", fmt.Errorf("store %q: %w", key, err)
+		}
+		return key, written, hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	dir := filepath.Join(cfg.BackupFolder, catalog.StateDirName, "spool")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", 0, "", fmt.Errorf("spool: %w", err)
+	}
+	f, err := os.CreateTemp(dir, "download-*"+storage.TempSuffix)
+	if err != nil {
+		return "", 0, "", fmt.Errorf("spool: %w", err)
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	if _, err := io.Copy(io.MultiWriter(f, h), body); err != nil {
+		return "", 0, "", fmt.Errorf("spool: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", 0, "", fmt.Errorf("spool: %w", err)
+	}
+
+	sum := hex.EncodeToString(h.Sum(nil))
+	key := ix.Layout.Key(when, ext, sum)
+	if err := storage.CheckKey(key); err != nil {
+		return "", 0, "", err
+	}
+	written, err := ix.Backend.Put(ctx, key, f)
+	if err != nil {
+		return "", written, "", fmt.Errorf("store %q: %w", key, err)
+	}
+	return key, written, sum, nil
+}
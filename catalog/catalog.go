@@ -0,0 +1,511 @@
+package catalog
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"httpBackupGo/config"
+	"httpBackupGo/storage"
+)
+
+// StateDirName is the hidden folder under BackupFolder that holds
+// httpBackupGo's own bookkeeping (catalogs etc.), never backups.
+const StateDirName = ".httpbackupgo"
+
+// Entry describes one stored backup.
+type Entry struct {
+	Key     string    `json:"Key"`  // storage key, see Layout
+	Name    string    `json:"Name"` // file name (last element of Key)
+	Size    int64     `json:"Size"`
+	SHA256  string    `json:"SHA256"`
+	Created time.Time `json:"Created"`
+
+	// Set by the scrub job.
+	Verified time.Time `json:"Verified,omitempty"`
+	Corrupt  bool      `json:"Corrupt,omitempty"`
+	Problem  string    `json:"Problem,omitempty"`
+}
+
+// Verification status values for Entry.Status.
+const (
+	StatusUnverified = "unverified"
+	StatusOK         = "ok"
+	StatusCorrupt    = "corrupt"
+)
+
+// Status summarizes the scrub result of an entry.
+func (e Entry) Status() string {
+	switch {
+	case e.Corrupt:
+		return StatusCorrupt
+	case e.Verified.IsZero():
+		return StatusUnverified
+	default:
+		return StatusOK
+	}
+}
+
+// Catalog is the per-site index of backups, oldest first.
+type Catalog struct {
+	Site    string    `json:"Site"`
+	Slug    string    `json:"Slug,omitempty"` // slug the keys were written under, see FollowRename
+	Updated time.Time `json:"Updated"`
+	Entries []Entry   `json:"Entries"`
+}
+
+// locks serialize read-modify-write cycles on each catalog file. A rebuild
+// lists and hashes a whole site, so it only holds up that site's catalog.
+var (
+	locksMu sync.Mutex
+	locks   = map[string]*sync.Mutex{}
+)
+
+// lock returns the mutex of the index's catalog file.
+func (ix Index) lock() *sync.Mutex {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	m, ok := locks[ix.Path]
+	if !ok {
+		m = &sync.Mutex{}
+		locks[ix.Path] = m
+	}
+	return m
+}
+
+// Index is one site's catalog together with the backend its backups live in
+// and the layout of their keys.
+// The catalog file itself always stays on local disk under BackupFolder.
+type Index struct {
+	Site    string // display name
+	Slug    string // folder name, see config.Site.Slug
+	Path    string // catalog file
+	Backend storage.Backend
+	Layout  Layout
+
+	legacyPath string // catalog file before sites had IDs, moved to Path on load
+}
+
+// ForSite returns the index for a configured site.
+func ForSite(cfg config.Config, site config.Site) (Index, error) {
+	b, err := storage.ForSite(cfg, site)
+	if err != nil {
+		return Index{}, err
+	}
+	l, err := LayoutFor(cfg, site)
+	if err != nil {
+		return Index{}, err
+	}
+	ix := Index{
+		Site:    site.Name,
+		Slug:    siteSlug(site),
+		Path:    Path(cfg.BackupFolder, siteSlug(site)),
+		Backend: b,
+		Layout:  l,
+	}
+	if site.ID != "" {
+		ix.legacyPath = ix.Path
+		ix.Path = Path(cfg.BackupFolder, site.ID)
+	}
+	return ix, nil
+}
+
+// Path returns the catalog file for a site:
+//
+//	<BackupFolder>/.httpbackupgo/catalog/<ID>.json
+//
+// Sites without an ID (configs that were never normalized) use their slug.
+func Path(backupFolder, id string) string {
+	return filepath.Join(filepath.Clean(backupFolder), StateDirName, "catalog", id+".json")
+}
+
+// siteSlug is the site's slug, derived from its name when the config was not
+// normalized (ValidateAndNormalize assigns it).
+func siteSlug(site config.Site) string {
+	if site.Slug != "" {
+		return site.Slug
+	}
+	return config.Slugify(site.Name)
+}
+
+// TimeLayout is the timestamp format of the {ts} placeholder.
+const TimeLayout = "02-01-2006_15-04-05"
+
+// compressionExts combine with one of innerExts ("dump.sql.gz").
+var (
+	compressionExts = map[string]bool{
+		".gz": true, ".bz2": true, ".xz": true, ".zst": true, ".lz4": true,
+	}
+	innerExts = map[string]bool{
+		".tar": true, ".sql": true, ".json": true, ".csv": true, ".xml": true, ".txt": true, ".dump": true,
+	}
+)
+
+// FileExt returns the lower-cased extension of a file name, keeping the
+// inner extension of compressed files (".tar.gz", ".sql.gz").
+// It returns "" when there is no usable extension.
+func FileExt(name string) string {
+	name = strings.ToLower(name)
+	ext := path.Ext(name)
+	if !validExtChain(ext) {
+		return ""
+	}
+	if compressionExts[ext] {
+		if inner := path.Ext(strings.TrimSuffix(name, ext)); innerExts[inner] {
+			ext = inner + ext
+		}
+	}
+	return ext
+}
+
+// validExtChain accepts one to config.MaxExtParts short lower-case
+// alphanumeric extensions, e.g. ".zip" or ".tar.gz.enc".
+func validExtChain(ext string) bool {
+	if !strings.HasPrefix(ext, ".") {
+		return false
+	}
+	parts := strings.Split(ext[1:], ".")
+	if len(parts) > config.MaxExtParts {
+		return false
+	}
+	for _, p := range parts {
+		if p == "" || len(p) > 10 {
+			return false
+		}
+		for _, r := range p {
+			if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Load reads the catalog. If no catalog exists yet (first run after
+// upgrading, or the file was deleted) or it cannot be parsed, it is rebuilt
+// from storage; a corrupt file is kept as "<file>.corrupt".
+func (ix Index) Load(ctx context.Context) (Catalog, error) {
+	mu := ix.lock()
+	mu.Lock()
+	defer mu.Unlock()
+
+	return ix.loadLocked(ctx)
+}
+
+func (ix Index) loadLocked(ctx context.Context) (Catalog, error) {
+	c, found, err := ix.readLocked()
+	var perr *parseError
+	if errors.As(err, &perr) {
+		slog.Warn("catalog: unreadable, rebuilding from storage", "site", ix.Site, "path", ix.Path, "err", perr.err)
+		if err := os.Rename(ix.Path, ix.Path+".corrupt"); err != nil {
+			return Catalog{}, fmt.Errorf("set corrupt catalog aside: %w", err)
+		}
+		return ix.reindexLocked(ctx)
+	}
+	if err != nil {
+		return Catalog{}, err
+	}
+	if !found {
+		return ix.reindexLocked(ctx)
+	}
+	return c, nil
+}
+
+// parseError is a catalog file that exists but is not valid JSON.
+type parseError struct {
+	path string
+	err  error
+}
+
+func (e *parseError) Error() string { return fmt.Sprintf("parse catalog %q: %v", e.path, e.err) }
+func (e *parseError) Unwrap() error { return e.err }
+
+// readLocked reads the catalog file as it is, without ever rebuilding it.
+// found is false when there is no catalog file.
+func (ix Index) readLocked() (c Catalog, found bool, err error) {
+	if ix.legacyPath != "" {
+		if _, err := os.Stat(ix.Path); os.IsNotExist(err) {
+			_ = os.Rename(ix.legacyPath, ix.Path)
+		}
+	}
+	return ix.readFile(ix.Path)
+}
+
+// readFile parses the catalog at path; found is false when it does not exist.
+func (ix Index) readFile(path string) (c Catalog, found bool, err error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Catalog{}, false, nil
+		}
+		return Catalog{}, false, fmt.Errorf("read catalog: %w", err)
+	}
+
+	if err := json.Unmarshal(b, &c); err != nil {
+		return Catalog{}, true, &parseError{path: path, err: err}
+	}
+	c.Site = ix.Site
+
+	// Catalogs written before layouts only had names in the default layout.
+	for i, e := range c.Entries {
+		if e.Key == "" {
+			c.Entries[i].Key = ix.Slug + "/" + e.Name
+		}
+	}
+	return c, true, nil
+}
+
+// ErrNoCatalog is returned by Read for a site without a catalog file.
+var ErrNoCatalog = errors.New("no catalog yet; run the site or reindex")
+
+// Read is Load without writing: a missing catalog is ErrNoCatalog and an
+// unreadable one an error instead of a rebuild, and a catalog in the legacy
+// location is read where it is. It is for commands that run next to the
+// service without taking its locks.
+func (ix Index) Read() (Catalog, error) {
+	mu := ix.lock()
+	mu.Lock()
+	defer mu.Unlock()
+
+	path := ix.Path
+	if _, err := os.Stat(path); os.IsNotExist(err) && ix.legacyPath != "" {
+		path = ix.legacyPath
+	}
+	c, found, err := ix.readFile(path)
+	if err == nil && !found {
+		return Catalog{}, ErrNoCatalog
+	}
+	return c, err
+}
+
+// Add records a new backup in the catalog.
+func (ix Index) Add(ctx context.Context, e Entry) error {
+	return ix.update(ctx, func(c *Catalog) {
+		out := c.Entries[:0]
+		for _, old := range c.Entries {
+			if old.Key != e.Key {
+				out = append(out, old)
+			}
+		}
+		c.Entries = append(out, e)
+	})
+}
+
+// Remove drops backups from the catalog by storage key.
+func (ix Index) Remove(ctx context.Context, keys ...string) error {
+	drop := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		drop[k] = struct{}{}
+	}
+
+	return ix.update(ctx, func(c *Catalog) {
+		out := c.Entries[:0]
+		for _, e := range c.Entries {
+			if _, ok := drop[e.Key]; !ok {
+				out = append(out, e)
+			}
+		}
+		c.Entries = out
+	})
+}
+
+// Verdict is the scrub outcome of one backup.
+type Verdict struct {
+	Key     string
+	Problem string // empty when the backup verified fine
+	Time    time.Time
+}
+
+// MarkVerified stores scrub outcomes, rewriting the catalog once for all of
+// them. Verdicts for keys no longer in the catalog are ignored.
+func (ix Index) MarkVerified(ctx context.Context, verdicts []Verdict) error {
+	if len(verdicts) == 0 {
+		return nil
+	}
+	byKey := make(map[string]Verdict, len(verdicts))
+	for _, v := range verdicts {
+		byKey[v.Key] = v
+	}
+
+	return ix.update(ctx, func(c *Catalog) {
+		for i := range c.Entries {
+			v, ok := byKey[c.Entries[i].Key]
+			if !ok {
+				continue
+			}
+			c.Entries[i].Verified = v.Time
+			c.Entries[i].Corrupt = v.Problem != ""
+			c.Entries[i].Problem = v.Problem
+		}
+	})
+}
+
+// Reindex rebuilds the catalog from what is in storage and saves it.
+// Only keys matching the site's layout are picked up. Checksums of files that
+// were already catalogued (same key and size) are kept, everything else is
+// hashed again.
+func (ix Index) Reindex(ctx context.Context) (Catalog, error) {
+	mu := ix.lock()
+	mu.Lock()
+	defer mu.Unlock()
+
+	return ix.reindexLocked(ctx)
+}
+
+func (ix Index) reindexLocked(ctx context.Context) (Catalog, error) {
+	known := map[string]Entry{}
+	if b, err := os.ReadFile(ix.Path); err == nil {
+		var old Catalog
+		if json.Unmarshal(b, &old) == nil {
+			for _, e := range old.Entries {
+				if e.Key == "" {
+					e.Key = ix.Slug + "/" + e.Name
+				}
+				known[e.Key] = e
+			}
+		}
+	}
+
+	objs, err := ix.Backend.List(ctx, ix.Layout.Prefix())
+	if err != nil {
+		return Catalog{}, err
+	}
+
+	c := Catalog{Site: ix.Site, Slug: ix.Slug}
+	for _, o := range objs {
+		t, _, ok := ix.Layout.Parse(o.Key)
+		if !ok {
+			continue
+		}
+
+		if old, ok := known[o.Key]; ok && old.Size == o.Size && old.SHA256 != "" {
+			c.Entries = append(c.Entries, old)
+			continue
+		}
+
+		sum, err := ix.hash(ctx, o.Key)
+		if err != nil {
+			return Catalog{}, err
+		}
+
+		if t.IsZero() {
+			t = o.ModTime
+		}
+		c.Entries = append(c.Entries, Entry{
+			Key:     o.Key,
+			Name:    path.Base(o.Key),
+			Size:    o.Size,
+			SHA256:  sum,
+			Created: t,
+		})
+	}
+
+	if err := ix.saveLocked(&c); err != nil {
+		return Catalog{}, err
+	}
+	return c, nil
+}
+
+// hash returns the hex SHA-256 of a stored object.
+func (ix Index) hash(ctx context.Context, key string) (string, error) {
+	rc, err := ix.Backend.Open(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("open %q: %w", key, err)
+	}
+	defer rc.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return "", fmt.Errorf("hash %q: %w", key, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (ix Index) update(ctx context.Context, fn func(c *Catalog)) error {
+	mu := ix.lock()
+	mu.Lock()
+	defer mu.Unlock()
+
+	c, err := ix.loadLocked(ctx)
+	if err != nil {
+		return err
+	}
+
+	fn(&c)
+	return ix.saveLocked(&c)
+}
+
+// saveLocked writes the catalog via temp file + rename so readers never see
+// a half-written index.
+func (ix Index) saveLocked(c *Catalog) error {
+	sort.SliceStable(c.Entries, func(i, j int) bool {
+		return c.Entries[i].Created.Before(c.Entries[j].Created)
+	})
+	if c.Entries == nil {
+		c.Entries = []Entry{}
+	}
+	if c.Slug == "" {
+		c.Slug = ix.Slug
+	}
+	c.Updated = time.Now()
+
+	if err := os.MkdirAll(filepath.Dir(ix.Path), 0o755); err != nil {
+		return fmt.Errorf("create catalog directory: %w", err)
+	}
+
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal catalog: %w", err)
+	}
+	b = append(b, '\n')
+
+	tmp := ix.Path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("write temp catalog: %w", err)
+	}
+	if err := os.Rename(tmp, ix.Path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("replace catalog: %w", err)
+	}
+	return nil
+}
+
+// Latest returns the newest entry, if any.
+func (c Catalog) Latest() (Entry, bool) {
+	if len(c.Entries) == 0 {
+		return Entry{}, false
+	}
+	return c.Entries[len(c.Entries)-1], true
+}
+
+// CorruptCount returns how many backups failed their last scrub.
+func (c Catalog) CorruptCount() int {
+	n := 0
+	for _, e := range c.Entries {
+		if e.Corrupt {
+			n++
+		}
+	}
+	return n
+}
+
+// TotalSize sums the size of all catalogued backups.
+func (c Catalog) TotalSize() int64 {
+	var n int64
+	for _, e := range c.Entries {
+		n += e.Size
+	}
+	return n
+}
@@ -0,0 +1,342 @@
+package catalog
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"httpBackupGo/config"
+)
+
+// testIndex returns the index of a site stored locally under a temp
+// BackupFolder, with the default layout.
+func testIndex(t *testing.T, site config.Site) (Index, config.Config) {
+	t.Helper()
+	cfg := config.Config{BackupFolder: t.TempDir()}
+	ix, err := ForSite(cfg, site)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ix, cfg
+}
+
+// store puts a backup taken at t and returns the entry the catalog should
+// have for it.
+func store(t *testing.T, ix Index, at time.Time, content string) Entry {
+	t.Helper()
+	key := ix.Layout.Key(at, ".zip", "")
+	n, err := ix.Backend.Put(context.Background(), key, strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(content))
+	return Entry{Key: key, Name: filepath.Base(key), Size: n, SHA256: hex.EncodeToString(sum[:]), Created: at}
+}
+
+func keys(c Catalog) []string {
+	var out []string
+	for _, e := range c.Entries {
+		out = append(out, e.Key)
+	}
+	return out
+}
+
+func sameEntries(t *testing.T, c Catalog, want ...Entry) {
+	t.Helper()
+	if len(c.Entries) != len(want) {
+		t.Fatalf("entries = %q, want %d", keys(c), len(want))
+	}
+	for i, e := range c.Entries {
+		w := want[i]
+		if e.Key != w.Key || e.Name != w.Name || e.Size != w.Size || e.SHA256 != w.SHA256 || !e.Created.Equal(w.Created) {
+			t.Errorf("entry %d = %+v, want %+v", i, e, w)
+		}
+	}
+}
+
+func TestLoadRebuildsMissingCatalog(t *testing.T) {
+	ctx := context.Background()
+	ix, _ := testIndex(t, config.Site{ID: "s1", Name: "Shop", Slug: "shop"})
+	day := time.Date(2026, 1, 2, 3, 0, 0, 0, time.Local)
+	newer := store(t, ix, day.Add(24*time.Hour), "second")
+	older := store(t, ix, day, "first")
+
+	// Not in the layout: picked up by nobody.
+	for _, key := range []string{"shop/notes.txt", "blog/backup_blog_02-01-2026_03-00-00.zip"} {
+		if _, err := ix.Backend.Put(ctx, key, strings.NewReader("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := ix.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameEntries(t, c, older, newer)
+	if c.Site != "Shop" || c.Slug != "shop" {
+		t.Errorf("Site, Slug = %q, %q", c.Site, c.Slug)
+	}
+	if _, err := os.Stat(ix.Path); err != nil {
+		t.Fatalf("rebuilt catalog not saved: %v", err)
+	}
+
+	// Read sees the saved file as it is.
+	r, err := ix.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameEntries(t, r, older, newer)
+}
+
+func TestLoadSetsCorruptCatalogAside(t *testing.T) {
+	ctx := context.Background()
+	ix, _ := testIndex(t, config.Site{ID: "s1", Name: "Shop", Slug: "shop"})
+	e := store(t, ix, time.Date(2026, 1, 2, 3, 0, 0, 0, time.Local), "backup")
+
+	garbage := []byte(`{"Entries": [{"Key": "shop/`)
+	if err := os.MkdirAll(filepath.Dir(ix.Path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ix.Path, garbage, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read never repairs anything.
+	var perr *parseError
+	if _, err := ix.Read(); !errors.As(err, &perr) {
+		t.Fatalf("Read of a corrupt catalog: err = %v, want a parse error", err)
+	}
+	if _, err := os.Stat(ix.Path + ".corrupt"); !os.IsNotExist(err) {
+		t.Fatalf("Read set the catalog aside: %v", err)
+	}
+
+	c, err := ix.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameEntries(t, c, e)
+	kept, err := os.ReadFile(ix.Path + ".corrupt")
+	if err != nil || string(kept) != string(garbage) {
+		t.Fatalf("corrupt catalog not kept: %q, %v", kept, err)
+	}
+	if _, err := ix.Read(); err != nil {
+		t.Fatalf("Read after rebuild: %v", err)
+	}
+}
+
+func TestReadMissingCatalog(t *testing.T) {
+	ix, _ := testIndex(t, config.Site{ID: "s1", Name: "Shop", Slug: "shop"})
+	store(t, ix, time.Now(), "backup")
+
+	if _, err := ix.Read(); !errors.Is(err, ErrNoCatalog) {
+		t.Fatalf("err = %v, want ErrNoCatalog", err)
+	}
+	if _, err := os.Stat(ix.Path); !os.IsNotExist(err) {
+		t.Fatalf("Read created a catalog: %v", err)
+	}
+}
+
+func TestLegacyCatalogMoves(t *testing.T) {
+	ctx := context.Background()
+	ix, cfg := testIndex(t, config.Site{ID: "s1", Name: "Shop", Slug: "shop"})
+	e := store(t, ix, time.Date(2026, 1, 2, 3, 0, 0, 0, time.Local), "backup")
+
+	// A catalog from before sites had IDs, with bare names.
+	legacy := Path(cfg.BackupFolder, "shop")
+	if err := os.MkdirAll(filepath.Dir(legacy), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	old := fmt.Sprintf(`{"Site":"Shop","Entries":[{"Name":%q,"Size":%d,"SHA256":%q,"Created":%q}]}`,
+		e.Name, e.Size, e.SHA256, e.Created.Format(time.RFC3339))
+	if err := os.WriteFile(legacy, []byte(old), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := ix.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameEntries(t, r, e)
+	if _, err := os.Stat(legacy); err != nil {
+		t.Fatalf("Read moved the legacy catalog: %v", err)
+	}
+
+	c, err := ix.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameEntries(t, c, e)
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("legacy catalog still there: %v", err)
+	}
+	if _, err := os.Stat(ix.Path); err != nil {
+		t.Errorf("catalog not at its ID path: %v", err)
+	}
+}
+
+func TestAddRemoveMarkVerified(t *testing.T) {
+	ctx := context.Background()
+	ix, _ := testIndex(t, config.Site{ID: "s1", Name: "Shop", Slug: "shop"})
+	day := time.Date(2026, 1, 2, 3, 0, 0, 0, time.Local)
+	a := store(t, ix, day, "a")
+	b := store(t, ix, day.Add(time.Hour), "b")
+
+	// Out of order; Add keeps the catalog sorted and replaces same keys.
+	for _, e := range []Entry{b, a, a} {
+		if err := ix.Add(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := ix.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameEntries(t, c, a, b)
+
+	now := time.Now()
+	err = ix.MarkVerified(ctx, []Verdict{
+		{Key: a.Key, Time: now},
+		{Key: b.Key, Problem: "sha256 mismatch", Time: now},
+		{Key: "shop/gone.zip", Time: now},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _ = ix.Load(ctx)
+	if got := c.Entries[0].Status(); got != StatusOK {
+		t.Errorf("a: status %q", got)
+	}
+	if got := c.Entries[1]; got.Status() != StatusCorrupt || got.Problem != "sha256 mismatch" {
+		t.Errorf("b: status %q, problem %q", got.Status(), got.Problem)
+	}
+	if c.CorruptCount() != 1 || c.TotalSize() != a.Size+b.Size {
+		t.Errorf("CorruptCount, TotalSize = %d, %d", c.CorruptCount(), c.TotalSize())
+	}
+
+	if err := ix.Remove(ctx, a.Key); err != nil {
+		t.Fatal(err)
+	}
+	c, _ = ix.Load(ctx)
+	if latest, ok := c.Latest(); !ok || len(c.Entries) != 1 || latest.Key != b.Key {
+		t.Errorf("after Remove: %q", keys(c))
+	}
+}
+
+func TestReindexKeepsKnownChecksums(t *testing.T) {
+	ctx := context.Background()
+	ix, _ := testIndex(t, config.Site{ID: "s1", Name: "Shop", Slug: "shop"})
+	e := store(t, ix, time.Date(2026, 1, 2, 3, 0, 0, 0, time.Local), "backup")
+
+	// A checksum the catalog has for an unchanged file is not recomputed...
+	known := e
+	known.SHA256 = strings.Repeat("ab", 32)
+	if err := ix.Add(ctx, known); err != nil {
+		t.Fatal(err)
+	}
+	c, err := ix.Reindex(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameEntries(t, c, known)
+
+	// ...but one whose size changed is.
+	known.Size++
+	if err := ix.Add(ctx, known); err != nil {
+		t.Fatal(err)
+	}
+	c, err = ix.Reindex(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameEntries(t, c, e)
+}
+
+// Updates to one catalog are serialized: none is lost.
+func TestConcurrentAdds(t *testing.T) {
+	ctx := context.Background()
+	ix, _ := testIndex(t, config.Site{ID: "s1", Name: "Shop", Slug: "shop"})
+	if _, err := ix.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 20
+	day := time.Date(2026, 1, 2, 3, 0, 0, 0, time.Local)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Go(func() {
+			at := day.Add(time.Duration(i) * time.Minute)
+			if err := ix.Add(ctx, Entry{Key: ix.Layout.Key(at, ".zip", ""), Created: at}); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	wg.Wait()
+
+	c, err := ix.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Entries) != n {
+		t.Fatalf("entries = %d, want %d", len(c.Entries), n)
+	}
+}
+
+// A site's lock only holds up that site.
+func TestLocksArePerSite(t *testing.T) {
+	ctx := context.Background()
+	shop, cfg := testIndex(t, config.Site{ID: "s1", Name: "Shop", Slug: "shop"})
+	blog, err := ForSite(cfg, config.Site{ID: "s2", Name: "Blog", Slug: "blog"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	same, err := ForSite(cfg, config.Site{ID: "s1", Name: "Shop", Slug: "shop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shop.lock() != same.lock() {
+		t.Fatal("two indexes of one site have different locks")
+	}
+
+	// As if a long rebuild of shop were running.
+	mu := shop.lock()
+	mu.Lock()
+
+	blogDone := make(chan error, 1)
+	go func() {
+		_, err := blog.Load(ctx)
+		blogDone <- err
+	}()
+	select {
+	case err := <-blogDone:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		mu.Unlock()
+		t.Fatal("blog waited for shop's lock")
+	}
+
+	shopDone := make(chan error, 1)
+	go func() {
+		_, err := same.Load(ctx)
+		shopDone <- err
+	}()
+	select {
+	case <-shopDone:
+		mu.Unlock()
+		t.Fatal("shop loaded while its lock was held")
+	case <-time.After(50 * time.Millisecond):
+	}
+	mu.Unlock()
+	if err := <-shopDone; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,308 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"httpBackupGo/config"
+	"httpBackupGo/storage"
+)
+
+// Layout turns a config.Layout template into storage keys for one site, and
+// parses such keys back (for reindex, retention on mirrors and migration).
+type Layout struct {
+	template string
+	loc      *time.Location
+	site     string
+	tags     string
+
+	re     *regexp.Regexp
+	prefix string
+	hash   bool
+}
+
+// placeholder patterns used when parsing keys. Date parts are named groups so
+// Parse can rebuild the timestamp; "ts", "date" and "time" are split later.
+var placeholders = map[string]string{
+	"YYYY": `(?P<Y>\d{4})`,
+	"MM":   `(?P<M>\d{2})`,
+	"DD":   `(?P<D>\d{2})`,
+	"hh":   `(?P<h>\d{2})`,
+	"mm":   `(?P<m>\d{2})`,
+	"ss":   `(?P<s>\d{2})`,
+	"date": `(?P<date>\d{4}-\d{2}-\d{2})`,
+	"time": `(?P<time>\d{2}-\d{2}-\d{2})`,
+	"ts":   `(?P<ts>\d{2}-\d{2}-\d{4}_\d{2}-\d{2}-\d{2})`,
+	"hash": `[0-9a-f]{12}`,
+	"ext":  `(?P<ext>(?:\.[a-z0-9]{1,10}){1,` + strconv.Itoa(config.MaxExtParts) + `})`,
+}
+
+// LayoutFor returns the layout of a site: its own if set, otherwise the global one.
+func LayoutFor(cfg config.Config, site config.Site) (Layout, error) {
+	l := cfg.Layout
+	if site.Layout != nil {
+		l = *site.Layout
+	}
+	return NewLayout(l, site)
+}
+
+// NewLayout validates a template for a site. Every backup must get its own
+// key, so the template needs {site} and either {hash}, {ts} or a full date
+// and time down to the second.
+func NewLayout(l config.Layout, site config.Site) (Layout, error) {
+	tmpl := strings.TrimSpace(l.Template)
+	if tmpl == "" {
+		tmpl = config.DefaultPathTemplate
+	}
+	if !strings.Contains(tmpl, "{ext}") {
+		tmpl += "{ext}"
+	}
+
+	out := Layout{
+		template: tmpl,
+		loc:      time.Local,
+		site:     siteSlug(site),
+		tags:     strings.Join(site.Tags, "-"),
+	}
+	if l.UTC {
+		out.loc = time.UTC
+	}
+	if out.tags == "" {
+		out.tags = "untagged"
+	}
+
+	if strings.HasPrefix(tmpl, "/") || strings.Contains(tmpl, `\`) {
+		return Layout{}, fmt.Errorf("layout %q: must be a relative path with forward slashes", tmpl)
+	}
+	for _, seg := range strings.Split(tmpl, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return Layout{}, fmt.Errorf("layout %q: empty, . or .. path segment", tmpl)
+		}
+	}
+	if !strings.HasSuffix(tmpl, "{ext}") {
+		return Layout{}, fmt.Errorf("layout %q: {ext} must come last", tmpl)
+	}
+
+	var re strings.Builder
+	re.WriteString("^")
+	fields := map[string]bool{}
+	dynamic := false
+
+	err := walkTemplate(tmpl, func(lit, name string) error {
+		if lit != "" {
+			re.WriteString(regexp.QuoteMeta(lit))
+			if !dynamic {
+				out.prefix += lit
+			}
+			return nil
+		}
+
+		switch name {
+		case "site":
+			re.WriteString(regexp.QuoteMeta(out.site))
+			if !dynamic {
+				out.prefix += out.site
+			}
+			fields["site"] = true
+			return nil
+		case "tags":
+			re.WriteString(regexp.QuoteMeta(out.tags))
+			if !dynamic {
+				out.prefix += out.tags
+			}
+			return nil
+		}
+
+		pat, ok := placeholders[name]
+		if !ok {
+			return fmt.Errorf("unknown placeholder {%s}", name)
+		}
+		re.WriteString(pat)
+		dynamic = true
+
+		switch name {
+		case "ts", "hash":
+			fields[name] = true
+		case "date":
+			fields["YYYY"], fields["MM"], fields["DD"] = true, true, true
+		case "time":
+			fields["hh"], fields["mm"], fields["ss"] = true, true, true
+		default:
+			fields[name] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return Layout{}, fmt.Errorf("layout %q: %w", tmpl, err)
+	}
+	re.WriteString("$")
+
+	if !fields["site"] {
+		return Layout{}, fmt.Errorf("layout %q: {site} is required", tmpl)
+	}
+	fullTime := fields["YYYY"] && fields["MM"] && fields["DD"] && fields["hh"] && fields["mm"] && fields["ss"]
+	if !fields["ts"] && !fields["hash"] && !fullTime {
+		return Layout{}, fmt.Errorf("layout %q: needs {ts}, {hash} or a full date and time, or backups overwrite each other", tmpl)
+	}
+
+	out.re, err = regexp.Compile(re.String())
+	if err != nil {
+		return Layout{}, fmt.Errorf("layout %q: %w", tmpl, err)
+	}
+	out.hash = fields["hash"]
+
+	// List from the last folder boundary of the fixed part.
+	if i := strings.LastIndex(out.prefix, "/"); i >= 0 {
+		out.prefix = out.prefix[:i+1]
+	} else {
+		out.prefix = ""
+	}
+	return out, nil
+}
+
+// walkTemplate calls fn with each literal run (name "") and each placeholder.
+func walkTemplate(tmpl string, fn func(lit, name string) error) error {
+	for tmpl != "" {
+		i := strings.IndexAny(tmpl, "{}")
+		if i < 0 {
+			return fn(tmpl, "")
+		}
+		if i > 0 {
+			if err := fn(tmpl[:i], ""); err != nil {
+				return err
+			}
+		}
+		if tmpl[i] == '}' {
+			return errors.New("unbalanced }")
+		}
+		j := strings.IndexByte(tmpl[i:], '}')
+		if j < 0 {
+			return errors.New("unbalanced {")
+		}
+		if err := fn("", tmpl[i+1:i+j]); err != nil {
+			return err
+		}
+		tmpl = tmpl[i+j+1:]
+	}
+	return nil
+}
+
+func (l Layout) String() string { return l.template }
+
+// withSlug is the same layout for another site slug (to follow renames).
+func (l Layout) withSlug(slug string) (Layout, error) {
+	return NewLayout(
+		config.Layout{Template: l.template, UTC: l.loc == time.UTC},
+		config.Site{Slug: slug, Tags: []string{l.tags}},
+	)
+}
+
+// NeedsHash reports whether the key depends on the stored file's content.
+func (l Layout) NeedsHash() bool { return l.hash }
+
+// Prefix is the storage prefix all of the site's keys share (for List).
+func (l Layout) Prefix() string { return l.prefix }
+
+// Key expands the template. hash is the hex SHA-256 of the stored file and
+// only needed when NeedsHash.
+func (l Layout) Key(t time.Time, ext, hash string) string {
+	t = t.In(l.loc)
+	if len(hash) > 12 {
+		hash = hash[:12]
+	}
+
+	var b strings.Builder
+	_ = walkTemplate(l.template, func(lit, name string) error {
+		switch name {
+		case "":
+			b.WriteString(lit)
+		case "site":
+			b.WriteString(l.site)
+		case "tags":
+			b.WriteString(l.tags)
+		case "YYYY":
+			b.WriteString(t.Format("2006"))
+		case "MM":
+			b.WriteString(t.Format("01"))
+		case "DD":
+			b.WriteString(t.Format("02"))
+		case "hh":
+			b.WriteString(t.Format("15"))
+		case "mm":
+			b.WriteString(t.Format("04"))
+		case "ss":
+			b.WriteString(t.Format("05"))
+		case "date":
+			b.WriteString(t.Format("2006-01-02"))
+		case "time":
+			b.WriteString(t.Format("15-04-05"))
+		case "ts":
+			b.WriteString(t.Format(TimeLayout))
+		case "hash":
+			b.WriteString(hash)
+		case "ext":
+			b.WriteString(ext)
+		}
+		return nil
+	})
+	return b.String()
+}
+
+// Parse reports whether key was produced by this layout and returns its
+// timestamp (zero when the template has no complete date) and extension.
+func (l Layout) Parse(key string) (t time.Time, ext string, ok bool) {
+	m := l.re.FindStringSubmatch(key)
+	if m == nil {
+		return time.Time{}, "", false
+	}
+
+	parts := map[string]string{}
+	set := func(k, v string) bool {
+		if old, dup := parts[k]; dup && old != v {
+			return false // e.g. {YYYY} used twice with different values
+		}
+		parts[k] = v
+		return true
+	}
+
+	for i, name := range l.re.SubexpNames() {
+		if name == "" || m[i] == "" {
+			continue
+		}
+		v := m[i]
+		var good bool
+		switch name {
+		case "ext":
+			ext, good = v, !strings.HasSuffix(v, storage.TempSuffix)
+		case "date": // YYYY-MM-DD
+			good = set("Y", v[0:4]) && set("M", v[5:7]) && set("D", v[8:10])
+		case "time": // hh-mm-ss
+			good = set("h", v[0:2]) && set("m", v[3:5]) && set("s", v[6:8])
+		case "ts": // DD-MM-YYYY_hh-mm-ss
+			good = set("D", v[0:2]) && set("M", v[3:5]) && set("Y", v[6:10]) &&
+				set("h", v[11:13]) && set("m", v[14:16]) && set("s", v[17:19])
+		default:
+			good = set(name, v)
+		}
+		if !good {
+			return time.Time{}, "", false
+		}
+	}
+
+	n := func(k string) int {
+		v, _ := strconv.Atoi(parts[k]) // missing parts are 0
+		return v
+	}
+	if n("M") > 12 || n("D") > 31 || n("h") > 23 || n("m") > 59 || n("s") > 59 {
+		return time.Time{}, "", false
+	}
+	if parts["Y"] == "" || parts["M"] == "" || parts["D"] == "" {
+		return time.Time{}, ext, true
+	}
+	t = time.Date(n("Y"), time.Month(n("M")), n("D"), n("h"), n("m"), n("s"), 0, l.loc)
+	return t, ext, true
+}
@@ -0,0 +1,159 @@
+package catalog
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"httpBackupGo/config"
+)
+
+var testSite = config.Site{Name: "My Shop", Slug: "shop", Tags: []string{"eu", "prod"}}
+
+func mustLayout(t *testing.T, tmpl string, utc bool) Layout {
+	t.Helper()
+	l, err := NewLayout(config.Layout{Template: tmpl, UTC: utc}, testSite)
+	if err != nil {
+		t.Fatalf("NewLayout(%q): %v", tmpl, err)
+	}
+	return l
+}
+
+func TestLayoutRoundTrip(t *testing.T) {
+	at := time.Date(2026, 2, 13, 3, 4, 5, 0, time.UTC)
+	const sum = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	for _, tc := range []struct {
+		tmpl   string
+		ext    string
+		key    string
+		prefix string
+		dated  bool // Parse gives the time back
+	}{
+		{"", ".zip", "shop/backup_shop_13-02-2026_03-04-05.zip", "shop/", true},
+		{config.DefaultPathTemplate, ".tar.gz.enc", "shop/backup_shop_13-02-2026_03-04-05.tar.gz.enc", "shop/", true},
+		{"{tags}/{site}/{YYYY}/{MM}/{DD}/{hh}{mm}{ss}{ext}", ".sql.gz", "eu-prod/shop/2026/02/13/030405.sql.gz", "eu-prod/shop/", true},
+		{"{site}/{date}/{time}{ext}", ".zip", "shop/2026-02-13/03-04-05.zip", "shop/", true},
+		{"{site}/{YYYY}/{date}_{time}{ext}", ".zip", "shop/2026/2026-02-13_03-04-05.zip", "shop/", true},
+		{"backups/{site}/{hash}{ext}", ".zip", "backups/shop/0123456789ab.zip", "backups/shop/", false},
+		{"{site}-{ts}", ".zip", "shop-13-02-2026_03-04-05.zip", "", true}, // {ext} is appended
+	} {
+		t.Run(tc.key, func(t *testing.T) {
+			l := mustLayout(t, tc.tmpl, true)
+			if got := l.Key(at, tc.ext, sum); got != tc.key {
+				t.Fatalf("Key = %q, want %q", got, tc.key)
+			}
+			if got := l.Prefix(); got != tc.prefix {
+				t.Errorf("Prefix = %q, want %q", got, tc.prefix)
+			}
+			if !strings.HasPrefix(tc.key, l.Prefix()) {
+				t.Errorf("key %q is outside prefix %q", tc.key, l.Prefix())
+			}
+
+			got, ext, ok := l.Parse(tc.key)
+			if !ok {
+				t.Fatalf("Parse(%q) failed", tc.key)
+			}
+			if ext != tc.ext {
+				t.Errorf("Parse ext = %q, want %q", ext, tc.ext)
+			}
+			if tc.dated && !got.Equal(at) {
+				t.Errorf("Parse time = %v, want %v", got, at)
+			}
+			if !tc.dated && !got.IsZero() {
+				t.Errorf("Parse time = %v, want zero", got)
+			}
+		})
+	}
+}
+
+func TestLayoutLocalTime(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	at := time.Date(2026, 2, 13, 23, 30, 0, 0, time.UTC)
+
+	utc := mustLayout(t, "", true)
+	if got := utc.Key(at.In(loc), ".zip", ""); got != "shop/backup_shop_13-02-2026_23-30-00.zip" {
+		t.Errorf("UTC Key = %q", got)
+	}
+
+	l := mustLayout(t, "", false)
+	got, _, ok := l.Parse(l.Key(at, ".zip", ""))
+	if !ok || !got.Equal(at) {
+		t.Errorf("local round trip = %v, %v, want %v", got, ok, at)
+	}
+}
+
+func TestLayoutParseRejects(t *testing.T) {
+	l := mustLayout(t, "{site}/{date}/{time}{ext}", true)
+	for _, key := range []string{
+		"blog/2026-02-13/03-04-05.zip",         // another site
+		"shop/2026-02-13/03-04-05",             // no extension
+		"shop/2026-02-13/03-04-05.zip.tmp",     // upload in progress
+		"shop/2026-13-13/03-04-05.zip",         // month 13
+		"shop/2026-02-13/24-04-05.zip",         // hour 24
+		"shop/2026-02-13/03-04-05.ZIP",         // extensions are lower case
+		"shop/2026-02-13/03-04-05.a.b.c.d.e.f", // more than five extensions
+		"x/shop/2026-02-13/03-04-05.zip",
+	} {
+		if _, _, ok := l.Parse(key); ok {
+			t.Errorf("Parse(%q) accepted", key)
+		}
+	}
+
+	// A part used twice must have the same value both times.
+	twice := mustLayout(t, "{site}/{YYYY}/{date}_{time}{ext}", true)
+	if _, _, ok := twice.Parse("shop/2025/2026-02-13_03-04-05.zip"); ok {
+		t.Error("conflicting years accepted")
+	}
+}
+
+func TestNewLayoutRejects(t *testing.T) {
+	for _, tmpl := range []string{
+		"/srv/{site}/{ts}{ext}",
+		`{site}\{ts}{ext}`,
+		"{site}/../{ts}{ext}",
+		"../{site}/{ts}{ext}",
+		"{site}/./{ts}{ext}",
+		"{site}//{ts}{ext}",
+		"{site}/{ts}{ext}.bak",
+		"backups/{ts}{ext}",  // no {site}
+		"{site}/{date}{ext}", // one backup a day
+		"{site}/{YYYY}{MM}{DD}{hh}{ext}",
+		"{site}/{nope}_{ts}{ext}",
+		"{site}/{ts{ext}",
+		"{site}/ts}{ext}",
+	} {
+		if l, err := NewLayout(config.Layout{Template: tmpl}, testSite); err == nil {
+			t.Errorf("NewLayout(%q) accepted, template %q", tmpl, l)
+		}
+	}
+}
+
+func TestLayoutUntagged(t *testing.T) {
+	l, err := NewLayout(config.Layout{Template: "{tags}/{site}/{hash}{ext}"}, config.Site{Name: "Blog: News"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := l.Key(time.Now(), ".zip", "abcdef0123456789"); got != "untagged/Blog- News/abcdef012345.zip" {
+		t.Errorf("Key = %q", got)
+	}
+	if !l.NeedsHash() {
+		t.Error("NeedsHash = false for a {hash} template")
+	}
+}
+
+func TestFileExt(t *testing.T) {
+	for name, want := range map[string]string{
+		"backup.zip":               ".zip",
+		"dump.SQL.GZ":              ".sql.gz",
+		"site.tar.gz":              ".tar.gz",
+		"photo.jpeg.gz":            ".gz",
+		"no-extension":             "",
+		"weird.ex_t":               "",
+		"archive.toolongextension": "",
+	} {
+		if got := FileExt(name); got != want {
+			t.Errorf("FileExt(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+
+	"httpBackupGo/config"
+	"httpBackupGo/storage"
+)
+
+// Move is one backup relocated by Migrate.
+type Move struct {
+	From string
+	To   string
+}
+
+// Migrate moves a site's catalogued backups from the keys of the `from`
+// layout to the keys of ix.Layout, updating the catalog after every move so an
+// interrupted migration can simply be run again. Backups already in the new
+// layout are left alone; keys matching neither layout are skipped with a warning.
+//
+// Mirrors get the same moves (best-effort, only where the old key exists).
+// With dryRun nothing is changed and only the plan is returned.
+func (ix Index) Migrate(ctx context.Context, from Layout, dryRun bool, mirrors []storage.Backend) ([]Move, error) {
+	c, err := ix.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var moves []Move
+	for _, e := range c.Entries {
+		if _, _, ok := ix.Layout.Parse(e.Key); ok {
+			continue
+		}
+
+		t, ext, ok := from.Parse(e.Key)
+		if !ok {
+			slog.Warn("migrate: key matches neither layout, skipped", "site", ix.Site, "key", e.Key)
+			continue
+		}
+		if t.IsZero() {
+			t = e.Created
+		}
+
+		sum := e.SHA256
+		if sum == "" && ix.Layout.NeedsHash() {
+			if sum, err = ix.hash(ctx, e.Key); err != nil {
+				return moves, err
+			}
+		}
+
+		m := Move{From: e.Key, To: ix.Layout.Key(t, ext, sum)}
+		if m.To == m.From {
+			continue
+		}
+		moves = append(moves, m)
+		if dryRun {
+			continue
+		}
+
+		if err := moveObject(ctx, ix.Backend, m, e.Size); err != nil {
+			return moves, err
+		}
+		err := ix.update(ctx, func(c *Catalog) {
+			for i := range c.Entries {
+				if c.Entries[i].Key == m.From {
+					c.Entries[i].Key = m.To
+					c.Entries[i].Name = path.Base(m.To)
+				}
+			}
+		})
+		if err != nil {
+			return moves, fmt.Errorf("update catalog: %w", err)
+		}
+
+		for _, b := range mirrors {
+			o, err := b.Stat(ctx, m.From)
+			if err != nil {
+				continue
+			}
+			if err := moveObject(ctx, b, m, o.Size); err != nil {
+				slog.Warn("migrate: mirror move failed", "storage", b.String(), "from", m.From, "to", m.To, "err", err)
+			}
+		}
+	}
+	return moves, nil
+}
+
+// FollowRename moves the site's backups to its current slug when the catalog
+// was written under another one (the site's Slug was changed), on the primary
+// storage and the enabled mirrors, so retention and history carry over.
+// It is a no-op for sites that were not renamed, and it never rebuilds a
+// missing catalog: without one there is nothing to follow.
+func (ix Index) FollowRename(ctx context.Context, cfg config.Config) ([]Move, error) {
+	mu := ix.lock()
+	mu.Lock()
+	c, found, err := ix.readLocked()
+	mu.Unlock()
+	if err != nil || !found {
+		return nil, err
+	}
+	if c.Slug == "" || c.Slug == ix.Slug {
+		return nil, nil
+	}
+
+	old, err := ix.Layout.withSlug(c.Slug)
+	if err != nil {
+		return nil, fmt.Errorf("layout of old slug %q: %w", c.Slug, err)
+	}
+
+	var mirrors []storage.Backend
+	for _, m := range cfg.Mirrors {
+		if !m.Enabled {
+			continue
+		}
+		b, err := storage.New(cfg, m.Storage)
+		if err != nil {
+			slog.Warn("rename: mirror unavailable, not moved", "mirror", m.Name, "err", err)
+			continue
+		}
+		mirrors = append(mirrors, b)
+	}
+
+	moves, err := ix.Migrate(ctx, old, false, mirrors)
+	if err != nil {
+		return moves, err
+	}
+	err = ix.update(ctx, func(c *Catalog) { c.Slug = ix.Slug })
+	if err != nil {
+		return moves, fmt.Errorf("update catalog: %w", err)
+	}
+
+	slog.Info(
+		"rename: backups moved to new slug",
+		"site", ix.Site,
+		"from", c.Slug,
+		"to", ix.Slug,
+		"moved", len(moves),
+	)
+	return moves, nil
+}
+
+// moveObject copies m.From to m.To within one backend, then deletes m.From.
+// A same-size object already at m.To (from an interrupted run) is kept.
+// Local storage renames instead.
+func moveObject(ctx context.Context, b storage.Backend, m Move, size int64) error {
+	if l, ok := b.(*storage.Local); ok {
+		err := l.Rename(ctx, m.From, m.To)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Moved by an interrupted run before the catalog was updated.
+			if o, serr := l.Stat(ctx, m.To); serr == nil && o.Size == size {
+				return nil
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("rename %q: %w", m.From, err)
+		}
+		return nil
+	}
+
+	if o, err := b.Stat(ctx, m.To); err != nil || o.Size != size {
+		rc, err := b.Open(ctx, m.From)
+		if err != nil {
+			return fmt.Errorf("open %q: %w", m.From, err)
+		}
+		written, err := b.Put(ctx, m.To, rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("put %q: %w", m.To, err)
+		}
+		if written != size {
+			_ = b.Delete(ctx, m.To)
+			return fmt.Errorf("copy %q: wrote %d of %d bytes", m.From, written, size)
+		}
+	}
+
+	if err := b.Delete(ctx, m.From); err != nil {
+		return fmt.Errorf("delete %q: %w", m.From, err)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package catalog
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"httpBackupGo/config"
+	"httpBackupGo/storage"
+)
+
+func TestFollowRename(t *testing.T) {
+	ctx := context.Background()
+	site := config.Site{ID: "s1", Name: "Shop", Slug: "shop"}
+	ix, cfg := testIndex(t, site)
+	day := time.Date(2026, 1, 2, 3, 0, 0, 0, time.Local)
+	a := store(t, ix, day, "first")
+	b := store(t, ix, day.Add(time.Hour), "second")
+	if _, err := ix.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// An enabled mirror holding one of the backups, and a disabled one.
+	mirrorDir, offDir := t.TempDir(), t.TempDir()
+	mirror, off := storage.NewLocal(mirrorDir), storage.NewLocal(offDir)
+	for _, m := range []*storage.Local{mirror, off} {
+		if _, err := m.Put(ctx, a.Key, strings.NewReader("first")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg.Mirrors = []config.Mirror{
+		{Enabled: true, Name: "m", Storage: config.Storage{Type: config.StorageLocal, Path: mirrorDir}},
+		{Enabled: false, Name: "off", Storage: config.Storage{Type: config.StorageLocal, Path: offDir}},
+	}
+
+	// Not renamed: nothing to do.
+	if moves, err := ix.FollowRename(ctx, cfg); err != nil || len(moves) != 0 {
+		t.Fatalf("FollowRename without rename = %v, %v", moves, err)
+	}
+
+	site.Slug = "store"
+	renamed, err := ForSite(cfg, site)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moves, err := renamed.FollowRename(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Move{
+		{From: a.Key, To: renamed.Layout.Key(a.Created, ".zip", "")},
+		{From: b.Key, To: renamed.Layout.Key(b.Created, ".zip", "")},
+	}
+	if len(moves) != len(want) || moves[0] != want[0] || moves[1] != want[1] {
+		t.Fatalf("moves = %v, want %v", moves, want)
+	}
+
+	c, err := renamed.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Slug != "store" {
+		t.Errorf("catalog slug = %q", c.Slug)
+	}
+	for i, m := range want {
+		if got := c.Entries[i]; got.Key != m.To || got.Name != filepath.Base(m.To) {
+			t.Errorf("entry %d = %q (%q), want %q", i, got.Key, got.Name, m.To)
+		}
+		if _, err := ix.Backend.Stat(ctx, m.From); !os.IsNotExist(err) {
+			t.Errorf("%s still on primary storage: %v", m.From, err)
+		}
+		if _, err := ix.Backend.Stat(ctx, m.To); err != nil {
+			t.Errorf("%s not on primary storage: %v", m.To, err)
+		}
+	}
+	if _, err := mirror.Stat(ctx, want[0].To); err != nil {
+		t.Errorf("mirror copy not moved: %v", err)
+	}
+	if _, err := off.Stat(ctx, a.Key); err != nil {
+		t.Errorf("disabled mirror touched: %v", err)
+	}
+
+	// Done once: a second call is a no-op.
+	if moves, err := renamed.FollowRename(ctx, cfg); err != nil || len(moves) != 0 {
+		t.Fatalf("second FollowRename = %v, %v", moves, err)
+	}
+}
+
+func TestFollowRenameWithoutCatalog(t *testing.T) {
+	ix, cfg := testIndex(t, config.Site{ID: "s1", Name: "Shop", Slug: "shop"})
+	store(t, ix, time.Now(), "backup")
+
+	if moves, err := ix.FollowRename(context.Background(), cfg); err != nil || len(moves) != 0 {
+		t.Fatalf("FollowRename = %v, %v", moves, err)
+	}
+	if _, err := os.Stat(ix.Path); !os.IsNotExist(err) {
+		t.Fatalf("FollowRename built a catalog: %v", err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	ix, _ := testIndex(t, config.Site{ID: "s1", Name: "Shop", Slug: "shop"})
+	e := store(t, ix, time.Date(2026, 1, 2, 3, 4, 5, 0, time.Local), "backup")
+	if _, err := ix.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	from := ix.Layout
+	ix.Layout = mustLayout(t, "{site}/{YYYY}/{MM}/{hash}_{date}{ext}", false)
+	to := ix.Layout.Key(e.Created, ".zip", e.SHA256)
+
+	moves, err := ix.Migrate(ctx, from, true, nil)
+	if err != nil || len(moves) != 1 || moves[0].To != to {
+		t.Fatalf("dry run = %v, %v, want a move to %q", moves, err, to)
+	}
+	if _, err := ix.Backend.Stat(ctx, e.Key); err != nil {
+		t.Fatalf("dry run moved the backup: %v", err)
+	}
+
+	if _, err := ix.Migrate(ctx, from, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ix.Backend.Stat(ctx, to); err != nil {
+		t.Fatalf("backup not moved: %v", err)
+	}
+	c, _ := ix.Read()
+	if len(c.Entries) != 1 || c.Entries[0].Key != to {
+		t.Fatalf("catalog = %q", keys(c))
+	}
+
+	// Already migrated: nothing left to move.
+	if moves, err := ix.Migrate(ctx, from, false, nil); err != nil || len(moves) != 0 {
+		t.Fatalf("second Migrate = %v, %v", moves, err)
+	}
+}
@@ -0,0 +1,636 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/term"
+
+	"httpBackupGo/auth"
+	"httpBackupGo/backup"
+	"httpBackupGo/catalog"
+	"httpBackupGo/config"
+	"httpBackupGo/encrypt"
+	"httpBackupGo/mirror"
+	"httpBackupGo/retention"
+	"httpBackupGo/runlog"
+	"httpBackupGo/scrub"
+	"httpBackupGo/storage"
+)
+
+// siteList is a repeatable string flag (--site a --site b).
+type siteList []string
+
+func (l *siteList) String() string { return strings.Join(*l, ",") }
+
+func (l *siteList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// selectSites returns the sites with the given names or IDs, in config order.
+// No names selects every site.
+func selectSites(cfg config.Config, names []string) ([]config.Site, error) {
+	if len(names) == 0 {
+		return cfg.Sites, nil
+	}
+
+	want := map[string]bool{}
+	for _, n := range names {
+		want[n] = true
+	}
+
+	var sites []config.Site
+	for _, site := range cfg.Sites {
+		if want[site.Name] || want[site.ID] {
+			sites = append(sites, site)
+			delete(want, site.Name)
+			delete(want, site.ID)
+		}
+	}
+	for n := range want {
+		return nil, fmt.Errorf("unknown site %q", n)
+	}
+	return sites, nil
+}
+
+// runCmd backs up once and exits, for cron jobs, systemd timers and manual
+// runs. It waits for the mirror jobs of the new backups. The exit code is 0
+// when every site succeeded, 3 when some failed and 1 when all failed.
+func runCmd(fs *flag.FlagSet) func(e *env) error {
+	var names siteList
+	fs.Var(&names, "site", "back up only this site (name or ID, repeatable; also runs disabled sites)")
+	summaryPath := fs.String("summary", "", `write a JSON summary to this file ("-" for stdout)`)
+
+	return func(e *env) error {
+		if e.ctx.Err() != nil {
+			return e.ctx.Err()
+		}
+
+		var sites []config.Site
+		if len(names) > 0 {
+			var err error
+			if sites, err = selectSites(e.cfg, names); err != nil {
+				return err
+			}
+		}
+
+		run := runlog.Begin("", "cli", names)
+		if err := runlog.Record(e.cfg.BackupFolder, run); err != nil {
+			slog.Warn("run history: record failed", "err", err)
+		}
+
+		repl := mirror.NewReplicator(2)
+		repl.Start(e.ctx)
+		r := newRunner(repl)
+
+		var results []backup.Result
+		if sites == nil {
+			results = r.RunAllEnabled(e.ctx, e.cfg)
+		} else {
+			results = r.Run(e.ctx, e.cfg, sites)
+		}
+
+		repl.Close()
+		repl.Wait()
+
+		run.Complete(results)
+		if err := runlog.Record(e.cfg.BackupFolder, run); err != nil {
+			slog.Warn("run history: record failed", "err", err)
+		}
+		if *summaryPath != "" {
+			if err := writeSummary(*summaryPath, run); err != nil {
+				return err
+			}
+		}
+		if run.Failed > 0 {
+			slog.Error("run: sites failed", "failed", run.Failed, "sites", len(run.Sites), "status", run.Status)
+			return exitCode(runExitCode(run))
+		}
+		return nil
+	}
+}
+
+// validateCmd checks the config and everything a run needs that can be
+// checked offline: site URLs, layouts, storage settings and encryption keys.
+func validateCmd(fs *flag.FlagSet) func(e *env) error {
+	return func(e *env) error {
+		cfg := e.cfg
+		var errs []error
+
+		for _, site := range cfg.Sites {
+			prefix := fmt.Sprintf("site %q: ", site.Name)
+
+			u, err := url.Parse(site.Url)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				errs = append(errs, errors.New(prefix+"Url must be an http(s) URL"))
+			}
+			if _, err := catalog.LayoutFor(cfg, site); err != nil {
+				errs = append(errs, fmt.Errorf("%slayout: %w", prefix, err))
+			}
+			if _, err := storage.ForSite(cfg, site); err != nil {
+				errs = append(errs, fmt.Errorf("%sstorage: %w", prefix, err))
+			}
+			if enc := encrypt.ForSite(cfg, site); enc.Enabled {
+				if _, err := encrypt.LoadKey(enc); err != nil {
+					errs = append(errs, fmt.Errorf("%sencryption key: %w", prefix, err))
+				}
+			}
+		}
+		for _, m := range cfg.Mirrors {
+			if !m.Enabled {
+				continue
+			}
+			if _, err := storage.New(cfg, m.Storage); err != nil {
+				errs = append(errs, fmt.Errorf("mirror %q: %w", m.Name, err))
+			}
+		}
+
+		if err := errors.Join(errs...); err != nil {
+			return err
+		}
+		fmt.Printf("%s: config OK (%d sites, %d mirrors)\n", e.cfgPath, len(cfg.Sites), len(cfg.Mirrors))
+		return nil
+	}
+}
+
+// listCmd prints the catalogued backups of the given sites (all when empty),
+// oldest first.
+func listCmd(fs *flag.FlagSet) func(e *env) error {
+	return func(e *env) error {
+		sites, err := selectSites(e.cfg, e.args)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for i, site := range sites {
+			ix, err := catalog.ForSite(e.cfg, site)
+			if err != nil {
+				return fmt.Errorf("site %q: %w", site.Name, err)
+			}
+			// Read, not Load: list takes no lock, so it must not rebuild a
+			// catalog next to a running service.
+			c, err := ix.Read()
+			if err != nil && !errors.Is(err, catalog.ErrNoCatalog) {
+				return fmt.Errorf("site %q: %w", site.Name, err)
+			}
+
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			state := "enabled"
+			if !site.Enabled {
+				state = "disabled"
+			}
+			if err != nil {
+				fmt.Fprintf(w, "%s (%s, %s): no catalog, run \"httpbackupgo reindex\"\n", site.Name, ix.Slug, state)
+				continue
+			}
+			fmt.Fprintf(w, "%s (%s, %s): %d backups, %d bytes, %s\n",
+				site.Name, ix.Slug, state, len(c.Entries), c.TotalSize(), ix.Backend.String())
+			for _, b := range c.Entries {
+				fmt.Fprintf(w, "  %s\t%d\t%s\t%s\n", b.Created.Local().Format("2006-01-02 15:04:05"), b.Size, b.Status(), b.Key)
+			}
+		}
+		return w.Flush()
+	}
+}
+
+// pruneCmd applies retention to the primary storage of the given sites (all
+// when empty). Mirrors keep applying their own retention after replication.
+func pruneCmd(fs *flag.FlagSet) func(e *env) error {
+	keep := fs.Int("keep", 0, "backups to keep per site (default: the configured Retention)")
+	dryRun := fs.Bool("dry-run", false, "only print the backups that would be deleted")
+
+	return func(e *env) error {
+		n := e.cfg.Retention
+		if *keep > 0 {
+			n = *keep
+		}
+
+		sites, err := selectSites(e.cfg, e.args)
+		if err != nil {
+			return err
+		}
+		for _, site := range sites {
+			ix, err := catalog.ForSite(e.cfg, site)
+			if err != nil {
+				return fmt.Errorf("site %q: %w", site.Name, err)
+			}
+
+			if *dryRun {
+				c, err := ix.Load(e.ctx)
+				if err != nil {
+					return fmt.Errorf("site %q: %w", site.Name, err)
+				}
+				if len(c.Entries) > n {
+					for _, b := range c.Entries[:len(c.Entries)-n] {
+						fmt.Println(b.Key)
+					}
+				}
+				continue
+			}
+
+			if err := retention.CleanupSite(e.ctx, ix, n); err != nil {
+				return fmt.Errorf("site %q: %w", site.Name, err)
+			}
+			slog.Info("prune: site done", "site", site.Name, "keep", n)
+		}
+		return nil
+	}
+}
+
+// reindexCmd rebuilds the catalog of the given sites (all sites when empty).
+func reindexCmd(fs *flag.FlagSet) func(e *env) error {
+	return func(e *env) error {
+		sites, err := selectSites(e.cfg, e.args)
+		if err != nil {
+			return err
+		}
+
+		for _, site := range sites {
+			ix, err := catalog.ForSite(e.cfg, site)
+			if err != nil {
+				return fmt.Errorf("site %q: %w", site.Name, err)
+			}
+
+			c, err := ix.Reindex(e.ctx)
+			if err != nil {
+				return fmt.Errorf("site %q: %w", site.Name, err)
+			}
+			slog.Info(
+				"catalog: reindexed",
+				"site", site.Name,
+				"backups", len(c.Entries),
+				"bytes", c.TotalSize(),
+			)
+		}
+		return nil
+	}
+}
+
+// scrubCmd verifies all stored backups once; the exit code is 1 when any
+// is corrupt.
+func scrubCmd(fs *flag.FlagSet) func(e *env) error {
+	return func(e *env) error {
+		res, err := scrub.Run(e.ctx, e.cfg)
+		if err != nil {
+			return err
+		}
+		if res.Corrupt > 0 {
+			return exitCode(1)
+		}
+		return nil
+	}
+}
+
+// mirrorCheckCmd prints every inconsistency between the primary storage and
+// the mirrors. The exit code is 1 when something is missing or differs (after
+// repair, when requested). Extra files on a mirror are listed but not an error.
+func mirrorCheckCmd(fs *flag.FlagSet) func(e *env) error {
+	deep := fs.Bool("deep", false, "download and hash every expected backup on the mirrors")
+	repair := fs.Bool("repair", false, "re-replicate missing or differing backups")
+
+	return func(e *env) error {
+		problems, err := mirror.Check(e.ctx, e.cfg, *deep)
+		if err != nil {
+			return err
+		}
+
+		bad := 0
+		for _, p := range problems {
+			fmt.Printf("%-8s %-16s %-20s %s %s\n", p.Kind, p.Mirror, p.Site, p.Key, p.Detail)
+			if p.Kind != mirror.ProblemExtra {
+				bad++
+			}
+		}
+
+		if *repair && bad > 0 {
+			bad = mirror.Repair(e.ctx, e.cfg, problems)
+			fmt.Printf("repair: %d backup(s) could not be repaired\n", bad)
+		}
+
+		if len(problems) == 0 {
+			fmt.Println("mirrors are consistent")
+		}
+		if bad > 0 {
+			return exitCode(1)
+		}
+		return nil
+	}
+}
+
+// migrateCmd re-lays out the backups of the given sites (all when empty) from
+// the --from template to each site's configured layout, on the primary storage
+// and on every enabled mirror.
+func migrateCmd(fs *flag.FlagSet) func(e *env) error {
+	from := fs.String("from", config.DefaultPathTemplate, "template the existing backups were stored with")
+	fromUTC := fs.Bool("from-utc", false, "the --from template used UTC")
+	dryRun := fs.Bool("dry-run", false, "only print the planned moves")
+
+	return func(e *env) error {
+		sites, err := selectSites(e.cfg, e.args)
+		if err != nil {
+			return err
+		}
+
+		var mirrors []storage.Backend
+		for _, m := range e.cfg.Mirrors {
+			if !m.Enabled {
+				continue
+			}
+			b, err := storage.New(e.cfg, m.Storage)
+			if err != nil {
+				return fmt.Errorf("mirror %q: %w", m.Name, err)
+			}
+			mirrors = append(mirrors, b)
+		}
+
+		for _, site := range sites {
+			ix, err := catalog.ForSite(e.cfg, site)
+			if err != nil {
+				return fmt.Errorf("site %q: %w", site.Name, err)
+			}
+			old, err := catalog.NewLayout(config.Layout{Template: *from, UTC: *fromUTC}, site)
+			if err != nil {
+				return fmt.Errorf("--from: %w", err)
+			}
+
+			moves, err := ix.Migrate(e.ctx, old, *dryRun, mirrors)
+			for _, m := range moves {
+				fmt.Printf("%s -> %s\n", m.From, m.To)
+			}
+			if err != nil {
+				return fmt.Errorf("site %q: %w", site.Name, err)
+			}
+			slog.Info(
+				"migrate: site done",
+				"site", site.Name,
+				"layout", ix.Layout.String(),
+				"moved", len(moves),
+				"dry_run", *dryRun,
+			)
+		}
+		return nil
+	}
+}
+
+// decryptCmd decrypts one encrypted backup to a local file. The output is
+// written to a temp file first so a failed authentication leaves no partial
+// plaintext behind.
+func decryptCmd(fs *flag.FlagSet) func(e *env) error {
+	siteName := fs.String("site", "", "use this site's encryption settings instead of the global ones")
+	keyFile := fs.String("key-file", "", "read the key from this file")
+
+	return func(e *env) error {
+		if len(e.args) < 1 || len(e.args) > 2 {
+			return errors.New("usage: decrypt [--site NAME] [--key-file FILE] <backup.enc> [output]")
+		}
+
+		enc := e.cfg.Encryption
+		if *siteName != "" {
+			sites, err := selectSites(e.cfg, []string{*siteName})
+			if err != nil {
+				return err
+			}
+			enc = encrypt.ForSite(e.cfg, sites[0])
+		}
+		if *keyFile != "" {
+			enc.KeyFile = *keyFile
+		}
+
+		key, err := encrypt.LoadKey(enc)
+		if err != nil {
+			return err
+		}
+
+		in := e.args[0]
+		out := strings.TrimSuffix(in, encrypt.Suffix)
+		if len(e.args) == 2 {
+			out = e.args[1]
+		}
+		if out == in {
+			return errors.New("output would overwrite the input; pass an output path")
+		}
+
+		src, err := os.Open(in)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		dr, err := encrypt.NewReader(src, key)
+		if err != nil {
+			return err
+		}
+
+		tmp := out + ".tmp"
+		dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(dst, dr)
+		if cerr := dst.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			_ = os.Remove(tmp)
+			return err
+		}
+		if err := os.Rename(tmp, out); err != nil {
+			_ = os.Remove(tmp)
+			return err
+		}
+
+		slog.Info("decrypt: done", "in", in, "out", out, "bytes", n)
+		return nil
+	}
+}
+
+// userCmd manages the web UI users and API tokens (auth.json next to the
+// config). Passwords are read from the terminal without echo, or from the
+// first line of piped stdin, so they never show up in the process list or the
+// shell history. Changes are serialized with a running service through
+// auth.json.lock, and the service picks them up on its next request.
+func userCmd(fs *flag.FlagSet) func(e *env) error {
+	return func(e *env) error {
+		const usage = "usage: user add NAME [--role ROLE] [--site SITE]... | user role NAME ROLE [--site SITE]... | " +
+			"user passwd|delete NAME | user list | user token NAME [LABEL] | user revoke TOKEN-ID"
+		if len(e.args) == 0 {
+			return errors.New(usage)
+		}
+		store := auth.NewStore(auth.PathFor(e.cfgPath))
+		action, args := e.args[0], e.args[1:]
+
+		switch {
+		case action == "add" && len(args) >= 1:
+			// The first user administers the others; later ones start as viewers.
+			f, err := store.Load()
+			if err != nil {
+				return err
+			}
+			role := auth.RoleViewer
+			if len(f.Users) == 0 {
+				role = auth.RoleAdmin
+			}
+			sites, err := roleFlags(e.cfg, args[1:], &role)
+			if err != nil {
+				return err
+			}
+			password, err := readPassword()
+			if err != nil {
+				return err
+			}
+			if err := store.AddUser(args[0], password, role, sites); err != nil {
+				return err
+			}
+			slog.Info("user: added", "user", args[0], "role", role, "sites", sites)
+
+		case action == "role" && len(args) >= 2:
+			role := args[1]
+			sites, err := roleFlags(e.cfg, args[2:], &role)
+			if err != nil {
+				return err
+			}
+			if err := store.SetRole(args[0], role, sites); err != nil {
+				return err
+			}
+			slog.Info("user: role changed", "user", args[0], "role", role, "sites", sites)
+
+		case action == "passwd" && len(args) == 1:
+			password, err := readPassword()
+			if err != nil {
+				return err
+			}
+			if err := store.SetPassword(args[0], password); err != nil {
+				return err
+			}
+			slog.Info("user: password changed", "user", args[0])
+
+		case action == "delete" && len(args) == 1:
+			if err := store.DeleteUser(args[0]); err != nil {
+				return err
+			}
+			slog.Info("user: deleted", "user", args[0])
+
+		case action == "list" && len(args) == 0:
+			f, err := store.Load()
+			if err != nil {
+				return err
+			}
+			tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "USER\tROLE\tSITES\tCREATED\tTOKENS")
+			for _, u := range f.Users {
+				var tokens []string
+				for _, t := range f.Tokens {
+					if strings.EqualFold(t.User, u.Name) {
+						tokens = append(tokens, t.ID+" ("+t.Name+")")
+					}
+				}
+				sites := "all"
+				if u.Scoped() {
+					var names []string
+					for _, site := range e.cfg.Sites {
+						if u.SeesSite(site.ID, site.Name) {
+							names = append(names, site.Name)
+						}
+					}
+					sites = strings.Join(names, ", ")
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", u.Name, u.Role, sites,
+					u.Created.Local().Format("2006-01-02 15:04"), strings.Join(tokens, ", "))
+			}
+			return tw.Flush()
+
+		case action == "token" && (len(args) == 1 || len(args) == 2):
+			label := ""
+			if len(args) == 2 {
+				label = args[1]
+			}
+			token, t, err := store.CreateToken(args[0], label)
+			if err != nil {
+				return err
+			}
+			slog.Info("user: token created", "user", t.User, "token_id", t.ID)
+			// The only time the token is shown.
+			fmt.Println(token)
+
+		case action == "revoke" && len(args) == 1:
+			if err := store.RevokeToken("", args[0]); err != nil {
+				return err
+			}
+			slog.Info("user: token revoked", "token_id", args[0])
+
+		default:
+			return errors.New(usage)
+		}
+		return nil
+	}
+}
+
+// roleFlags parses the --role and --site flags after the user name of
+// `user add` and `user role`. Sites must exist in the config and are stored by ID.
+func roleFlags(cfg config.Config, args []string, role *string) ([]string, error) {
+	fs := flag.NewFlagSet("user", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(role, "role", *role, "viewer, operator or admin")
+	var names siteList
+	fs.Var(&names, "site", "limit the user to this site")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	sites, err := selectSites(cfg, names)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(sites))
+	for _, site := range sites {
+		ids = append(ids, site.ID)
+	}
+	return ids, nil
+}
+
+// readPassword reads a new password. On a terminal it is read without echo
+// and asked for twice, so a typo cannot lock the user out; piped input is
+// read from its first line.
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+			return "", fmt.Errorf("read password from stdin: %w", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	var entered [2][]byte
+	for i, prompt := range []string{"Password: ", "Repeat password: "} {
+		fmt.Fprint(os.Stderr, prompt)
+		b, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("read password: %w", err)
+		}
+		entered[i] = b
+	}
+	if !bytes.Equal(entered[0], entered[1]) {
+		return "", errors.New("passwords do not match")
+	}
+	return string(entered[0]), nil
+}
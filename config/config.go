@@ -1,10 +1,14 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 )
@@ -15,12 +19,220 @@ type Config struct {
 	BackupFolder    string `json:"BackupFolder"`
 	Retention       int    `json:"Retention"`
 	Sites           []Site `json:"Sites"`
+
+	// Scrub re-verifies stored backups against their catalog checksums.
+	// ScrubIntervalMinutes==0 disables it; ScrubMaxMBps==0 means unthrottled.
+	ScrubIntervalMinutes int `json:"ScrubIntervalMinutes"`
+	ScrubMaxMBps         int `json:"ScrubMaxMBps"`
+
+	// AlertWebhookURL receives a JSON POST for alerts (e.g. corrupt backups).
+	// Empty means alerts are only logged.
+	AlertWebhookURL string `json:"AlertWebhookURL"`
+
+	// Storage is where backups are written. The zero value is the local
+	// BackupFolder; sites can override it.
+	Storage Storage `json:"Storage"`
+
+	// Mirrors receive an asynchronous copy of every new backup.
+	Mirrors []Mirror `json:"Mirrors"`
+
+	// Encryption encrypts backups before they reach storage. Sites can override it.
+	Encryption Encryption `json:"Encryption"`
+
+	// Layout names the stored backups. Sites can override it.
+	Layout Layout `json:"Layout"`
+
+	// MinFreeMB is the free space (MiB) to keep on BackupFolder and local
+	// storage. A download that would go below it fails before it starts or
+	// is aborted while it runs. 0 disables the check.
+	MinFreeMB int `json:"MinFreeMB"`
+
+	// EmergencyRetention, when > 0, lets a site that is short of space first
+	// delete its oldest backups down to this many and try again.
+	EmergencyRetention int `json:"EmergencyRetention"`
+
+	// StaleTempMinutes is how long a temp file (".tmp") may go unmodified
+	// before the sweep treats it as left behind by a crash and deletes it.
+	// Defaults to 60.
+	StaleTempMinutes int `json:"StaleTempMinutes"`
+
+	// ShutdownDrainSeconds is how long a SIGTERM/Ctrl-C waits for running
+	// backups and mirror jobs before cancelling them. Defaults to 60.
+	ShutdownDrainSeconds int `json:"ShutdownDrainSeconds"`
+
+	// Auth tunes web UI logins. Users and API tokens live in auth.json next
+	// to the config file, not here. Read at startup.
+	Auth Auth `json:"Auth"`
+
+	// TLS serves the web UI over HTTPS. Read at startup.
+	TLS TLS `json:"TLS"`
+}
+
+// TLS configures HTTPS for the web UI.
+type TLS struct {
+	Enabled bool `json:"Enabled"`
+
+	// CertFile and KeyFile are PEM files; the certificate file may hold the
+	// chain. Both empty means a self-signed certificate, generated on the
+	// first start and kept next to the config file.
+	CertFile string `json:"CertFile"`
+	KeyFile  string `json:"KeyFile"`
+
+	// RedirectHTTPAddr, when set, serves plain HTTP on this address (e.g.
+	// ":80") and redirects every request to HTTPS.
+	RedirectHTTPAddr string `json:"RedirectHTTPAddr"`
+}
+
+// Auth configures web sessions and the login lockout.
+type Auth struct {
+	// SessionHours is how long a UI session lasts without requests. Defaults to 12.
+	SessionHours int `json:"SessionHours"`
+
+	// MaxFailures failed logins within LockoutMinutes lock the user name and
+	// the client address for LockoutMinutes. Default 5 and 15.
+	MaxFailures    int `json:"MaxFailures"`
+	LockoutMinutes int `json:"LockoutMinutes"`
+}
+
+// DefaultPathTemplate is the original layout:
+// <site>/backup_<site>_DD-MM-YYYY_HH-mm-ss<ext>.
+const DefaultPathTemplate = "{site}/backup_{site}_{ts}{ext}"
+
+// Layout configures the storage key (path) of each backup.
+//
+// Template placeholders: {site}, {tags} (site tags joined with "-"),
+// {YYYY} {MM} {DD} {hh} {mm} {ss}, {date} (YYYY-MM-DD), {time} (hh-mm-ss),
+// {ts} (DD-MM-YYYY_hh-mm-ss), {hash} (first 12 hex digits of the stored
+// file's SHA-256) and {ext}, which is appended when missing.
+// "/" creates folders, e.g. "{site}/{YYYY}/{MM}/{site}_{date}_{time}{ext}".
+type Layout struct {
+	Template string `json:"Template"`
+
+	// UTC expands the date placeholders in UTC instead of local time.
+	UTC bool `json:"UTC"`
+}
+
+// Encryption configures client-side encryption (AES-256-GCM) of stored backups.
+// Encrypted backups get an extra ".enc" suffix.
+type Encryption struct {
+	Enabled bool `json:"Enabled"`
+
+	// KeyFile holds the 32-byte key: raw, or as 64 hex / 44 base64 characters.
+	KeyFile string `json:"KeyFile"`
+
+	// KeyEnv names the environment variable (hex or base64 key) used when
+	// KeyFile is empty. Defaults to HTTPBACKUP_ENCRYPTION_KEY.
+	KeyEnv string `json:"KeyEnv"`
+}
+
+// Mirror is a secondary destination that every backup is replicated to.
+type Mirror struct {
+	Enabled bool    `json:"Enabled"`
+	Name    string  `json:"Name"` // label for logs and reports
+	Storage Storage `json:"Storage"`
+
+	// Retention is the number of backups per site kept on this mirror.
+	// 0 means the same as Config.Retention.
+	Retention int `json:"Retention"`
 }
 
 type Site struct {
+	// ID identifies the site across renames. It is generated once and saved
+	// with the config; catalogs are keyed by it.
+	ID string `json:"ID"`
+
 	Enabled bool   `json:"Enabled"`
 	Name    string `json:"Name"`
 	Url     string `json:"Url"`
+
+	// Slug is the site's folder name in storage and its {site} in the layout.
+	// It is derived from Name when empty and kept when the site is renamed;
+	// changing it moves the site's backups on the next run.
+	Slug string `json:"Slug,omitempty"`
+
+	// Storage overrides Config.Storage for this site when set.
+	Storage *Storage `json:"Storage,omitempty"`
+
+	// Encryption overrides Config.Encryption for this site when set.
+	Encryption *Encryption `json:"Encryption,omitempty"`
+
+	// Compress gzips the download while storing it (".gz" is appended),
+	// unless the payload is already compressed.
+	Compress bool `json:"Compress,omitempty"`
+
+	// Extension overrides the detected file extension of the download,
+	// e.g. ".sql" or ".tar.gz". Empty means detect it.
+	Extension string `json:"Extension,omitempty"`
+
+	// Tags are free-form labels, usable as {tags} in the layout template.
+	Tags []string `json:"Tags,omitempty"`
+
+	// Layout overrides Config.Layout for this site when set.
+	Layout *Layout `json:"Layout,omitempty"`
+}
+
+// WebDAVConfig configures a WebDAV collection (Nextcloud, NAS boxes, ...).
+// Backups are stored under <URL>/<site>/backup_<site>_<ts>.zip.
+type WebDAVConfig struct {
+	URL      string `json:"URL"` // e.g. "https://cloud.example.com/remote.php/dav/files/backup/httpBackupGo/"
+	Username string `json:"Username"`
+
+	// When empty, HTTPBACKUP_WEBDAV_PASSWORD is used.
+	Password string `json:"Password"`
+}
+
+// SFTPConfig configures an SFTP server. Only key-based auth is supported and
+// the server's host key must be listed in KnownHostsFile.
+// Backups are stored under <Path>/<site>/backup_<site>_<ts>.zip.
+type SFTPConfig struct {
+	Host     string `json:"Host"` // "host" or "host:port" (default port 22)
+	Username string `json:"Username"`
+	Path     string `json:"Path"` // remote base directory
+
+	KeyFile string `json:"KeyFile"` // OpenSSH / PEM private key
+	// When empty, HTTPBACKUP_SFTP_PASSPHRASE is used (if the key is encrypted).
+	KeyPassphrase string `json:"KeyPassphrase"`
+
+	// Defaults to ~/.ssh/known_hosts.
+	KnownHostsFile string `json:"KnownHostsFile"`
+}
+
+// Storage types.
+const (
+	StorageLocal  = "local"
+	StorageS3     = "s3"
+	StorageWebDAV = "webdav"
+	StorageSFTP   = "sftp"
+)
+
+// Storage selects and configures a storage backend.
+type Storage struct {
+	Type string `json:"Type"` // "local" (default), "s3", "webdav" or "sftp"
+
+	// Path is the root folder for Type "local". Empty means BackupFolder.
+	Path string `json:"Path,omitempty"`
+
+	S3     *S3Config     `json:"S3,omitempty"`
+	WebDAV *WebDAVConfig `json:"WebDAV,omitempty"`
+	SFTP   *SFTPConfig   `json:"SFTP,omitempty"`
+}
+
+// S3Config configures an S3-compatible bucket (AWS, MinIO, Ceph RGW, ...).
+// Backups are stored under <Prefix><site>/backup_<site>_<ts>.zip.
+type S3Config struct {
+	Endpoint  string `json:"Endpoint"` // e.g. "https://s3.eu-west-1.amazonaws.com" or "http://127.0.0.1:9000"
+	Region    string `json:"Region"`
+	Bucket    string `json:"Bucket"`
+	Prefix    string `json:"Prefix"`
+	PathStyle bool   `json:"PathStyle"` // MinIO and Ceph usually need path-style URLs
+
+	// Credentials. When empty, AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY are used.
+	AccessKey string `json:"AccessKey"`
+	SecretKey string `json:"SecretKey"`
+
+	// PartSizeMB is the multipart chunk size; uploads smaller than one part
+	// use a single PUT. Minimum 5 (an S3 limit).
+	PartSizeMB int `json:"PartSizeMB"`
 }
 
 // DefaultConfig returns a sensible default config.
@@ -31,6 +243,12 @@ func DefaultConfig() Config {
 		IntervalMinutes: 0,
 		BackupFolder:    defaultBackupFolder(),
 		Retention:       30,
+
+		ScrubIntervalMinutes: 1440,
+		ScrubMaxMBps:         20,
+		StaleTempMinutes:     60,
+		ShutdownDrainSeconds: 60,
+
 		Sites: []Site{
 			{
 				Enabled: true,
@@ -53,7 +271,9 @@ func LoadOrCreate(path string) (Config, error) {
 	if err != nil {
 		if os.IsNotExist(err) {
 			cfg := DefaultConfig()
-			cfg.ValidateAndNormalize()
+			if err := cfg.ValidateAndNormalize(); err != nil {
+				return Config{}, err
+			}
 
 			if err := Save(path, cfg); err != nil {
 				return Config{}, fmt.Errorf("failed to create default config at %q: %w", path, err)
@@ -63,15 +283,56 @@ func LoadOrCreate(path string) (Config, error) {
 		return Config{}, fmt.Errorf("failed to read config %q: %w", path, err)
 	}
 
-	var cfg Config
-	if err := json.Unmarshal(b, &cfg); err != nil {
-		return Config{}, fmt.Errorf("failed to parse config %q: %w", path, err)
+	cfg, generated, err := parse(path, b)
+	if err != nil {
+		return Config{}, err
 	}
 
-	cfg.ValidateAndNormalize()
+	// IDs and slugs must not change between loads, so persist new ones right
+	// away. A read-only config still works; they are then only stable for
+	// this process.
+	if generated {
+		if err := Save(path, cfg); err != nil {
+			slog.Warn("config: could not save generated site IDs", "path", path, "err", err)
+		}
+	}
 	return cfg, nil
 }
 
+// Load reads and validates the config at path without creating or changing
+// the file. Missing site IDs and slugs are generated but not saved.
+func Load(path string) (Config, error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return Config{}, errors.New("config path is empty")
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read config %q: %w", path, err)
+	}
+	cfg, _, err := parse(path, b)
+	return cfg, err
+}
+
+// parse decodes and normalizes a config file. generated reports whether a
+// site got a new ID or slug.
+func parse(path string, b []byte) (cfg Config, generated bool, err error) {
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return Config{}, false, fmt.Errorf("failed to parse config %q: %w", path, err)
+	}
+
+	for _, s := range cfg.Sites {
+		if strings.TrimSpace(s.ID) == "" || strings.TrimSpace(s.Slug) == "" {
+			generated = true
+		}
+	}
+
+	if err := cfg.ValidateAndNormalize(); err != nil {
+		return Config{}, false, fmt.Errorf("invalid config %q: %w", path, err)
+	}
+	return cfg, generated, nil
+}
+
 // Save writes cfg to path as pretty-printed JSON. It creates the parent directory if needed.
 func Save(path string, cfg Config) error {
 	path = strings.TrimSpace(path)
@@ -79,7 +340,9 @@ func Save(path string, cfg Config) error {
 		return errors.New("config path is empty")
 	}
 
-	cfg.ValidateAndNormalize()
+	if err := cfg.ValidateAndNormalize(); err != nil {
+		return err
+	}
 
 	// Ensure parent dir exists
 	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
@@ -92,8 +355,14 @@ func Save(path string, cfg Config) error {
 	}
 	b = append(b, '\n')
 
+	// 0600: the config can hold storage credentials. The rename replaces an
+	// existing, more open file, and Chmod covers a temp file left by a crash.
 	tmp := path + ".tmp"
-	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return fmt.Errorf("failed to write temp config: %w", err)
+	}
+	if err := os.Chmod(tmp, 0o600); err != nil {
+		_ = os.Remove(tmp)
 		return fmt.Errorf("failed to write temp config: %w", err)
 	}
 	if err := os.Rename(tmp, path); err != nil {
@@ -103,11 +372,14 @@ func Save(path string, cfg Config) error {
 	return nil
 }
 
-// ValidateAndNormalize applies minimal defaults/sanity.
-// It does NOT hard-fail for most issues; it normalizes where possible.
-// If you want strict validation later (e.g. error when URL is empty),
-// we can add a separate ValidateStrict() that returns an error.
-func (c *Config) ValidateAndNormalize() {
+// ValidateAndNormalize applies defaults and normalizes c in place.
+// Harmless issues are fixed silently (negative intervals, empty site rows,
+// later sites repeating a name). Everything else is collected and returned
+// as one errors.Join error: site names with path separators or control
+// characters, unsafe or duplicate slugs and IDs, mirrors that would write to
+// the primary storage or share a name, and inconsistent TLS settings.
+// Callers must not use c when an error is returned.
+func (c *Config) ValidateAndNormalize() error {
 	// Defaults
 	if c.IntervalMinutes < 0 {
 		c.IntervalMinutes = 1
@@ -123,18 +395,95 @@ func (c *Config) ValidateAndNormalize() {
 	if c.WebListenAddr == "" {
 		c.WebListenAddr = "127.0.0.1:8123"
 	}
+	if c.ScrubIntervalMinutes < 0 {
+		c.ScrubIntervalMinutes = 0
+	}
+	if c.ScrubMaxMBps < 0 {
+		c.ScrubMaxMBps = 0
+	}
+	if c.MinFreeMB < 0 {
+		c.MinFreeMB = 0
+	}
+	if c.EmergencyRetention < 0 {
+		c.EmergencyRetention = 0
+	}
+	if c.StaleTempMinutes <= 0 {
+		c.StaleTempMinutes = 60
+	}
+	if c.ShutdownDrainSeconds <= 0 {
+		c.ShutdownDrainSeconds = 60
+	}
+	c.AlertWebhookURL = strings.TrimSpace(c.AlertWebhookURL)
+	c.Storage.normalize()
+	c.Encryption.normalize()
+	c.Layout.normalize()
+	c.Auth.normalize()
+	c.TLS.normalize()
+
+	var errs []error
+	if err := c.TLS.validate(c.WebListenAddr); err != nil {
+		errs = append(errs, err)
+	}
+
+	mirrors := make([]Mirror, 0, len(c.Mirrors))
+	for i, m := range c.Mirrors {
+		m.Name = strings.TrimSpace(m.Name)
+		if m.Name == "" {
+			m.Name = fmt.Sprintf("mirror%d", i+1)
+		}
+		if m.Retention < 0 {
+			m.Retention = 0
+		}
+		m.Storage.normalize()
+		mirrors = append(mirrors, m)
+	}
+	c.Mirrors = mirrors
 
 	// Normalize sites: trim whitespace
 	out := make([]Site, 0, len(c.Sites))
 	seen := map[string]struct{}{}
+	ids := map[string]bool{}
 	for _, s := range c.Sites {
+		s.ID = strings.TrimSpace(s.ID)
 		s.Name = strings.TrimSpace(s.Name)
 		s.Url = strings.TrimSpace(s.Url)
+		s.Slug = strings.TrimSpace(s.Slug)
+		s.Extension = normalizeExt(s.Extension)
+		if s.Storage != nil {
+			st := *s.Storage
+			st.normalize()
+			s.Storage = &st
+		}
+		if s.Encryption != nil {
+			enc := *s.Encryption
+			enc.normalize()
+			s.Encryption = &enc
+		}
+		if s.Layout != nil {
+			l := *s.Layout
+			l.normalize()
+			s.Layout = &l
+		}
+		tags := make([]string, 0, len(s.Tags))
+		for _, t := range s.Tags {
+			if t = strings.TrimSpace(t); t != "" {
+				tags = append(tags, t)
+			}
+		}
+		s.Tags = tags
 
 		// Skip totally empty entries (common when UI adds/removes rows)
 		if s.Name == "" && s.Url == "" {
 			continue
 		}
+		if err := checkName(s.Name); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := checkTags(s.Tags); err != nil {
+			errs = append(errs, fmt.Errorf("site %q: %w", s.Name, err))
+			continue
+		}
 
 		// If duplicate names exist, keep the first and drop later duplicates.
 		// (Later we can instead auto-rename or return an error; this is the safest "don’t crash" behavior.)
@@ -145,9 +494,218 @@ func (c *Config) ValidateAndNormalize() {
 			seen[strings.ToLower(s.Name)] = struct{}{}
 		}
 
+		if s.ID == "" {
+			s.ID = newSiteID()
+		}
+		if err := checkID(s.ID); err != nil {
+			errs = append(errs, fmt.Errorf("site %q: %w", s.Name, err))
+			continue
+		}
+		if ids[s.ID] {
+			errs = append(errs, fmt.Errorf("site %q: ID %q is used by another site", s.Name, s.ID))
+			continue
+		}
+		ids[s.ID] = true
+
 		out = append(out, s)
 	}
+	if err := assignSlugs(out); err != nil {
+		errs = append(errs, err)
+	}
 	c.Sites = out
+	errs = append(errs, c.checkMirrors()...)
+	return errors.Join(errs...)
+}
+
+// checkMirrors rejects mirrors that would replicate a backup onto itself
+// (retention on such a "mirror" deletes primary backups behind the catalog's
+// back) and repeated names, which identify a mirror's stored secrets.
+func (c *Config) checkMirrors() []error {
+	primary := map[string]bool{
+		storageRoot(Storage{Type: StorageLocal}, c.BackupFolder): true,
+		storageRoot(c.Storage, c.BackupFolder):                   true,
+	}
+	for _, s := range c.Sites {
+		if s.Storage != nil {
+			primary[storageRoot(*s.Storage, c.BackupFolder)] = true
+		}
+	}
+
+	var errs []error
+	names := map[string]bool{}
+	for _, m := range c.Mirrors {
+		if names[strings.ToLower(m.Name)] {
+			errs = append(errs, fmt.Errorf("mirror %q: name is used by another mirror", m.Name))
+			continue
+		}
+		names[strings.ToLower(m.Name)] = true
+
+		if !m.Enabled {
+			continue
+		}
+		if m.Storage.Type == StorageLocal && m.Storage.Path == "" {
+			errs = append(errs, fmt.Errorf("mirror %q: local storage needs a Path", m.Name))
+			continue
+		}
+		if primary[storageRoot(m.Storage, c.BackupFolder)] {
+			errs = append(errs, fmt.Errorf("mirror %q: storage is the primary storage", m.Name))
+		}
+	}
+	return errs
+}
+
+// storageRoot identifies where a (normalized) storage config keeps its
+// backups, so two configs pointing at the same place compare equal.
+func storageRoot(st Storage, backupFolder string) string {
+	switch {
+	case st.Type == StorageS3 && st.S3 != nil:
+		endpoint := st.S3.Endpoint
+		if endpoint == "" {
+			endpoint = "https://s3." + st.S3.Region + ".amazonaws.com"
+		}
+		return "s3:" + strings.ToLower(endpoint) + "/" + st.S3.Bucket + "/" + st.S3.Prefix
+	case st.Type == StorageWebDAV && st.WebDAV != nil:
+		return "webdav:" + st.WebDAV.URL
+	case st.Type == StorageSFTP && st.SFTP != nil:
+		host := strings.ToLower(st.SFTP.Host)
+		if !strings.Contains(host, ":") {
+			host += ":22"
+		}
+		return "sftp:" + host + path.Clean("/"+st.SFTP.Path)
+	case st.Type == StorageLocal:
+		p := st.Path
+		if p == "" {
+			p = backupFolder
+		}
+		if abs, err := filepath.Abs(p); err == nil {
+			p = abs
+		}
+		return "local:" + filepath.Clean(p)
+	}
+	return st.Type
+}
+
+func (st *Storage) normalize() {
+	st.Type = strings.ToLower(strings.TrimSpace(st.Type))
+	if st.Type == "" {
+		st.Type = StorageLocal
+	}
+	st.Path = strings.TrimSpace(st.Path)
+
+	if st.S3 != nil {
+		s3 := *st.S3
+		s3.Endpoint = strings.TrimRight(strings.TrimSpace(s3.Endpoint), "/")
+		s3.Region = strings.TrimSpace(s3.Region)
+		if s3.Region == "" {
+			s3.Region = "us-east-1"
+		}
+		s3.Bucket = strings.TrimSpace(s3.Bucket)
+		s3.Prefix = strings.TrimLeft(strings.TrimSpace(s3.Prefix), "/")
+		if s3.Prefix != "" && !strings.HasSuffix(s3.Prefix, "/") {
+			s3.Prefix += "/"
+		}
+		if s3.PartSizeMB < 5 {
+			s3.PartSizeMB = 16
+		}
+		st.S3 = &s3
+	}
+
+	if st.WebDAV != nil {
+		dav := *st.WebDAV
+		dav.URL = strings.TrimSpace(dav.URL)
+		if dav.URL != "" && !strings.HasSuffix(dav.URL, "/") {
+			dav.URL += "/"
+		}
+		dav.Username = strings.TrimSpace(dav.Username)
+		st.WebDAV = &dav
+	}
+
+	if st.SFTP != nil {
+		sf := *st.SFTP
+		sf.Host = strings.TrimSpace(sf.Host)
+		sf.Username = strings.TrimSpace(sf.Username)
+		sf.Path = strings.TrimRight(strings.TrimSpace(sf.Path), "/")
+		sf.KeyFile = strings.TrimSpace(sf.KeyFile)
+		sf.KnownHostsFile = strings.TrimSpace(sf.KnownHostsFile)
+		st.SFTP = &sf
+	}
+}
+
+// MaxExtParts is the longest extension chain a stored backup can have
+// (".tar.gz.enc" has three). Keys with longer ones are not recognised.
+const MaxExtParts = 5
+
+// normalizeExt turns "SQL.gz" / ".sql.gz." into ".sql.gz". Anything that is
+// not a short chain of alphanumeric parts is dropped (detection is used instead).
+// It leaves room in MaxExtParts for the ".gz" and ".enc" a run may append.
+func normalizeExt(ext string) string {
+	var parts []string
+	for _, p := range strings.Split(strings.ToLower(strings.TrimSpace(ext)), ".") {
+		if p == "" {
+			continue
+		}
+		if len(p) > 10 || strings.Trim(p, "abcdefghijklmnopqrstuvwxyz0123456789") != "" {
+			return ""
+		}
+		parts = append(parts, p)
+	}
+	if len(parts) == 0 || len(parts) > MaxExtParts-2 {
+		return ""
+	}
+	return "." + strings.Join(parts, ".")
+}
+
+func (l *Layout) normalize() {
+	l.Template = strings.TrimSpace(l.Template)
+	if l.Template == "" {
+		l.Template = DefaultPathTemplate
+	}
+}
+
+func (e *Encryption) normalize() {
+	e.KeyFile = strings.TrimSpace(e.KeyFile)
+	e.KeyEnv = strings.TrimSpace(e.KeyEnv)
+	if e.KeyEnv == "" {
+		e.KeyEnv = "HTTPBACKUP_ENCRYPTION_KEY"
+	}
+}
+
+func (a *Auth) normalize() {
+	if a.SessionHours <= 0 {
+		a.SessionHours = 12
+	}
+	if a.MaxFailures <= 0 {
+		a.MaxFailures = 5
+	}
+	if a.LockoutMinutes <= 0 {
+		a.LockoutMinutes = 15
+	}
+}
+
+func (t *TLS) normalize() {
+	t.CertFile = strings.TrimSpace(t.CertFile)
+	t.KeyFile = strings.TrimSpace(t.KeyFile)
+	t.RedirectHTTPAddr = strings.TrimSpace(t.RedirectHTTPAddr)
+}
+
+func (t *TLS) validate(webAddr string) error {
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return errors.New("TLS: set both CertFile and KeyFile, or neither for a self-signed certificate")
+	}
+	if t.RedirectHTTPAddr != "" && !t.Enabled {
+		return errors.New("TLS: RedirectHTTPAddr needs TLS.Enabled")
+	}
+	if t.RedirectHTTPAddr != "" && t.RedirectHTTPAddr == webAddr {
+		return errors.New("TLS: RedirectHTTPAddr must differ from WebListenAddr")
+	}
+	return nil
+}
+
+// newSiteID returns a random 16-hex-digit site ID.
+func newSiteID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b) // never fails (crypto/rand)
+	return hex.EncodeToString(b)
 }
 
 func defaultBackupFolder() string {
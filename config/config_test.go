@@ -0,0 +1,61 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSiteTags(t *testing.T) {
+	for _, tc := range []struct {
+		tags []string
+		want []string // nil: rejected
+	}{
+		{[]string{" eu ", "", "prod"}, []string{"eu", "prod"}},
+		{[]string{"Kunde A"}, []string{"Kunde A"}},
+		{[]string{"eu/prod"}, nil},
+		{[]string{`a\b`}, nil},
+		{[]string{".."}, nil},
+		{[]string{"."}, nil},
+		{[]string{"old."}, nil},
+		{[]string{"nul"}, nil},
+		{[]string{"tab\there"}, nil},
+		{[]string{"a?"}, nil},
+		{[]string{strings.Repeat("x", 40), strings.Repeat("y", 40)}, nil},
+	} {
+		cfg := Config{BackupFolder: t.TempDir(), Sites: []Site{{Name: "Shop", Url: "http://x", Tags: tc.tags}}}
+		err := cfg.ValidateAndNormalize()
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("tags %q accepted", tc.tags)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("tags %q: %v", tc.tags, err)
+			continue
+		}
+		if got := cfg.Sites[0].Tags; strings.Join(got, "|") != strings.Join(tc.want, "|") {
+			t.Errorf("tags %q normalized to %q, want %q", tc.tags, got, tc.want)
+		}
+	}
+}
+
+func TestNormalizeExt(t *testing.T) {
+	for in, want := range map[string]string{
+		".sql":           ".sql",
+		"SQL.gz":         ".sql.gz",
+		" .tar.gz. ":     ".tar.gz",
+		"a.b.c":          ".a.b.c",
+		"a.b.c.d":        "", // ".gz" and ".enc" must still fit
+		".tar-gz":        "",
+		".waytoolongext": "",
+		"":               "",
+	} {
+		if got := normalizeExt(in); got != want {
+			t.Errorf("normalizeExt(%q) = %q, want %q", in, got, want)
+		}
+		if got := normalizeExt(in); got != "" && len(strings.Split(got, "."))-1+2 > MaxExtParts {
+			t.Errorf("normalizeExt(%q) = %q leaves no room for .gz.enc", in, got)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxSlugLen keeps folder names well below common filesystem limits (255 bytes)
+// so the timestamped file name next to it still fits.
+const maxSlugLen = 64
+
+// Slugify turns a site name into a folder name that is safe on Windows, Linux
+// and object stores: path separators, control and Windows-reserved characters
+// become "-", leading/trailing dots and spaces are dropped and Windows device
+// names (CON, NUL, COM1, ...) get a "_" suffix. Names that already are safe
+// are returned unchanged, so existing backup folders keep their name.
+func Slugify(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if r == utf8.RuneError || unicode.IsControl(r) || strings.ContainsRune(`<>:"/\|?*`, r) {
+			b.WriteByte('-')
+			continue
+		}
+		b.WriteRune(r)
+	}
+	s := strings.Trim(b.String(), " .")
+
+	if len(s) > maxSlugLen {
+		s = s[:maxSlugLen]
+		for !utf8.ValidString(s) {
+			s = s[:len(s)-1]
+		}
+		s = strings.TrimRight(s, " .")
+	}
+	if s == "" {
+		return "site"
+	}
+	if reservedName(s) {
+		s += "_"
+	}
+	return s
+}
+
+// reservedName reports Windows device names, which cannot be used as a file
+// or folder name even with an extension ("nul.txt").
+func reservedName(s string) bool {
+	base, _, _ := strings.Cut(strings.ToUpper(s), ".")
+	switch base {
+	case "CON", "PRN", "AUX", "NUL":
+		return true
+	}
+	if len(base) == 4 && (strings.HasPrefix(base, "COM") || strings.HasPrefix(base, "LPT")) {
+		return base[3] >= '1' && base[3] <= '9'
+	}
+	return false
+}
+
+// checkName rejects display names that look like an attempt to reach outside
+// the backup folder. Other odd characters are fine; the slug takes care of them.
+func checkName(name string) error {
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("site %q: name must not contain path separators or be . or ..", name)
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("site %q: name must not contain control characters", name)
+		}
+	}
+	return nil
+}
+
+// checkTags accepts tags that are safe folder names by the rules of Slugify,
+// alone and joined with "-" as {tags} puts them in a storage key.
+func checkTags(tags []string) error {
+	for _, t := range tags {
+		if Slugify(t) != t {
+			return fmt.Errorf("tag %q is not a safe folder name", t)
+		}
+	}
+	if joined := strings.Join(tags, "-"); len(joined) > maxSlugLen {
+		return fmt.Errorf("tags %q are longer than %d characters together", joined, maxSlugLen)
+	}
+	return nil
+}
+
+// checkID accepts IDs usable as a file name: letters, digits, "-" and "_".
+func checkID(id string) error {
+	if len(id) > maxSlugLen {
+		return fmt.Errorf("ID %q is too long", id)
+	}
+	for _, r := range id {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '-' && r != '_' {
+			return fmt.Errorf("ID %q may only contain letters, digits, - and _", id)
+		}
+	}
+	return nil
+}
+
+// assignSlugs validates explicit slugs and derives missing ones from the site
+// name, appending "-2", "-3", ... until every slug is unique (case-insensitively,
+// for case-insensitive filesystems).
+func assignSlugs(sites []Site) error {
+	taken := map[string]bool{}
+	for _, s := range sites {
+		if s.Slug == "" {
+			continue
+		}
+		if Slugify(s.Slug) != s.Slug {
+			return fmt.Errorf("site %q: slug %q is not a safe folder name", s.Name, s.Slug)
+		}
+		k := strings.ToLower(s.Slug)
+		if taken[k] {
+			return fmt.Errorf("site %q: slug %q is used by another site", s.Name, s.Slug)
+		}
+		taken[k] = true
+	}
+
+	// Names that are already safe go first, so they keep their folder even
+	// when another site's name sanitises to the same slug.
+	for _, safeFirst := range []bool{true, false} {
+		for i := range sites {
+			if sites[i].Slug != "" || sites[i].Name == "" {
+				continue
+			}
+			base := Slugify(sites[i].Name)
+			if (base == sites[i].Name) != safeFirst {
+				continue
+			}
+			slug := base
+			for n := 2; taken[strings.ToLower(slug)]; n++ {
+				slug = fmt.Sprintf("%s-%d", base, n)
+			}
+			taken[strings.ToLower(slug)] = true
+			sites[i].Slug = slug
+		}
+	}
+	return nil
+}
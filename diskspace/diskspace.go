@@ -0,0 +1,32 @@
+// Package diskspace reports the free space of the filesystem holding a path.
+package diskspace
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// ErrUnsupported is returned on platforms without a free-space syscall.
+var ErrUnsupported = errors.New("free space check not supported on this platform")
+
+// Free returns the bytes available to this (unprivileged) process on the
+// filesystem holding path. A path that does not exist yet, like a backup
+// folder before the first run, is measured at its nearest existing parent.
+func Free(path string) (uint64, error) {
+	p, err := filepath.Abs(path)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		if _, err := os.Stat(p); err == nil {
+			break
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			break
+		}
+		p = parent
+	}
+	return free(p)
+}
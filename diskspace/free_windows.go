@@ -0,0 +1,17 @@
+//go:build windows
+
+package diskspace
+
+import "golang.org/x/sys/windows"
+
+func free(path string) (uint64, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var avail, total, totalFree uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &avail, &total, &totalFree); err != nil {
+		return 0, err
+	}
+	return avail, nil
+}
@@ -0,0 +1,272 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Suffix is appended to the names of encrypted backups.
+const Suffix = ".enc"
+
+// KeySize is the AES-256 key length in bytes.
+const KeySize = 32
+
+// File format (all integers big-endian):
+//
+//	header: magic "HBGOENC1" | chunk size u32 | key id [4] | nonce prefix [8]
+//	record: final flag u8 | ciphertext length u32 | ciphertext (incl. 16-byte tag)
+//
+// Each record is sealed with nonce = prefix || counter u32 and
+// AAD = header || counter u32 || final flag. The last record has final=1, so
+// truncating, reordering or splicing records makes decryption fail.
+const (
+	magic            = "HBGOENC1"
+	headerSize       = len(magic) + 4 + 4 + 8
+	defaultChunkSize = 64 * 1024
+	maxChunkSize     = 16 * 1024 * 1024
+)
+
+var (
+	ErrNotEncrypted = errors.New("encrypt: not an encrypted backup")
+	ErrWrongKey     = errors.New("encrypt: wrong key")
+	ErrTruncated    = errors.New("encrypt: file is truncated")
+)
+
+// KeyID returns a short fingerprint of a key, stored in the header so a wrong
+// key is reported as such instead of as corruption.
+func KeyID(key []byte) [4]byte {
+	sum := sha256.Sum256(append([]byte("httpBackupGo key id\x00"), key...))
+	var id [4]byte
+	copy(id[:], sum[:4])
+	return id
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("encrypt: key must be %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+type writer struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+
+	buf     []byte
+	counter uint32
+	closed  bool
+}
+
+// NewWriter returns a WriteCloser that encrypts everything written to it into w.
+// Close must be called to write the final record; it does not close w.
+func NewWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	binary.BigEndian.PutUint32(header[8:], defaultChunkSize)
+	id := KeyID(key)
+	copy(header[12:], id[:])
+	if _, err := rand.Read(header[16:]); err != nil {
+		return nil, fmt.Errorf("encrypt: nonce: %w", err)
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &writer{
+		w:      w,
+		aead:   aead,
+		header: header,
+		prefix: header[16:],
+		buf:    make([]byte, 0, defaultChunkSize),
+	}, nil
+}
+
+func (ew *writer) Write(p []byte) (int, error) {
+	if ew.closed {
+		return 0, errors.New("encrypt: write after close")
+	}
+
+	n := 0
+	for len(p) > 0 {
+		// Only seal a full chunk once more data arrives, so the last chunk
+		// can always be marked final in Close.
+		if len(ew.buf) == cap(ew.buf) {
+			if err := ew.seal(false); err != nil {
+				return n, err
+			}
+		}
+		c := copy(ew.buf[len(ew.buf):cap(ew.buf)], p)
+		ew.buf = ew.buf[:len(ew.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, nil
+}
+
+func (ew *writer) Close() error {
+	if ew.closed {
+		return nil
+	}
+	ew.closed = true
+	return ew.seal(true)
+}
+
+func (ew *writer) seal(final bool) error {
+	nonce, aad := recordParams(ew.header, ew.prefix, ew.counter, final)
+	ct := ew.aead.Seal(nil, nonce, ew.buf, aad)
+
+	var rec [5]byte
+	rec[0] = aad[len(aad)-1]
+	binary.BigEndian.PutUint32(rec[1:], uint32(len(ct)))
+	if _, err := ew.w.Write(rec[:]); err != nil {
+		return err
+	}
+	if _, err := ew.w.Write(ct); err != nil {
+		return err
+	}
+
+	ew.counter++
+	ew.buf = ew.buf[:0]
+	return nil
+}
+
+type reader struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	chunk  int
+
+	plain   []byte
+	counter uint32
+	done    bool
+}
+
+// NewReader returns a Reader that decrypts r. Every chunk is authenticated
+// before it is returned; tampering or truncation surfaces as a read error.
+func NewReader(r io.Reader, key []byte) (io.Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrNotEncrypted
+		}
+		return nil, err
+	}
+	if string(header[:8]) != magic {
+		return nil, ErrNotEncrypted
+	}
+	if id := KeyID(key); !bytes.Equal(header[12:16], id[:]) {
+		return nil, ErrWrongKey
+	}
+
+	chunk := int(binary.BigEndian.Uint32(header[8:]))
+	if chunk <= 0 || chunk > maxChunkSize {
+		return nil, fmt.Errorf("encrypt: invalid chunk size %d", chunk)
+	}
+
+	return &reader{
+		r:      r,
+		aead:   aead,
+		header: header,
+		prefix: header[16:],
+		chunk:  chunk,
+	}, nil
+}
+
+func (er *reader) Read(p []byte) (int, error) {
+	for len(er.plain) == 0 {
+		if er.done {
+			return 0, io.EOF
+		}
+		if err := er.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, er.plain)
+	er.plain = er.plain[n:]
+	return n, nil
+}
+
+func (er *reader) next() error {
+	var rec [5]byte
+	if _, err := io.ReadFull(er.r, rec[:]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrTruncated
+		}
+		return err
+	}
+
+	final := rec[0] == 1
+	size := int(binary.BigEndian.Uint32(rec[1:]))
+	if rec[0] > 1 || size < er.aead.Overhead() || size > er.chunk+er.aead.Overhead() {
+		return errors.New("encrypt: corrupt record header")
+	}
+
+	ct := make([]byte, size)
+	if _, err := io.ReadFull(er.r, ct); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrTruncated
+		}
+		return err
+	}
+
+	nonce, aad := recordParams(er.header, er.prefix, er.counter, final)
+	plain, err := er.aead.Open(ct[:0], nonce, ct, aad)
+	if err != nil {
+		return fmt.Errorf("encrypt: chunk %d failed authentication", er.counter)
+	}
+
+	if final {
+		// Anything after the final record means the file was tampered with.
+		var extra [1]byte
+		if n, _ := er.r.Read(extra[:]); n > 0 {
+			return errors.New("encrypt: trailing data after final chunk")
+		}
+		er.done = true
+	}
+
+	er.counter++
+	er.plain = plain
+	return nil
+}
+
+func recordParams(header, prefix []byte, counter uint32, final bool) (nonce, aad []byte) {
+	nonce = make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[8:], counter)
+
+	aad = make([]byte, 0, len(header)+5)
+	aad = append(aad, header...)
+	aad = binary.BigEndian.AppendUint32(aad, counter)
+	if final {
+		aad = append(aad, 1)
+	} else {
+		aad = append(aad, 0)
+	}
+	return nonce, aad
+}
@@ -0,0 +1,225 @@
+package encrypt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, KeySize)
+}
+
+// seal encrypts plain, writing it in odd-sized pieces so records are never
+// aligned with the writes.
+func seal(t *testing.T, key, plain []byte) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	w, err := NewWriter(&out, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p := plain; len(p) > 0; {
+		n := min(len(p), 7777)
+		if _, err := w.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func open(key, sealed []byte) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(sealed), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// records splits a sealed stream into its header and records.
+func records(t *testing.T, sealed []byte) (header []byte, recs [][]byte) {
+	t.Helper()
+	header, rest := sealed[:headerSize], sealed[headerSize:]
+	for len(rest) > 0 {
+		if len(rest) < 5 {
+			t.Fatalf("short record header")
+		}
+		n := 5 + int(binary.BigEndian.Uint32(rest[1:5]))
+		recs = append(recs, rest[:n])
+		rest = rest[n:]
+	}
+	return header, recs
+}
+
+func join(header []byte, recs ...[]byte) []byte {
+	return bytes.Join(append([][]byte{header}, recs...), nil)
+}
+
+func plaintext(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i*31 + i/251)
+	}
+	return b
+}
+
+func TestRoundTrip(t *testing.T) {
+	key := testKey(1)
+	for _, tc := range []struct {
+		name    string
+		size    int
+		records int
+	}{
+		{"empty", 0, 1},
+		{"one byte", 1, 1},
+		{"chunk minus one", defaultChunkSize - 1, 1},
+		{"exactly one chunk", defaultChunkSize, 1},
+		{"one chunk plus one", defaultChunkSize + 1, 2},
+		{"three chunks", 3 * defaultChunkSize, 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			plain := plaintext(tc.size)
+			sealed := seal(t, key, plain)
+
+			_, recs := records(t, sealed)
+			if len(recs) != tc.records {
+				t.Errorf("records = %d, want %d", len(recs), tc.records)
+			}
+			for i, rec := range recs {
+				if final := rec[0] == 1; final != (i == len(recs)-1) {
+					t.Errorf("record %d: final flag = %v", i, final)
+				}
+			}
+
+			got, err := open(key, sealed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Fatalf("round trip changed the data (%d bytes in, %d out)", len(plain), len(got))
+			}
+		})
+	}
+}
+
+func TestEncryptReader(t *testing.T) {
+	key := testKey(2)
+	plain := plaintext(2*defaultChunkSize + 17)
+	sealed, err := io.ReadAll(Encrypt(bytes.NewReader(plain), key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := open(key, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatal("round trip through Encrypt changed the data")
+	}
+}
+
+func TestTamperedChunk(t *testing.T) {
+	key := testKey(3)
+	sealed := seal(t, key, plaintext(2*defaultChunkSize+100))
+
+	for _, off := range []int{headerSize + 5, headerSize + 5 + defaultChunkSize/2, len(sealed) - 1} {
+		bad := bytes.Clone(sealed)
+		bad[off] ^= 0x01
+		if _, err := open(key, bad); err == nil {
+			t.Errorf("flipped byte at %d: no error", off)
+		}
+	}
+
+	// The header is authenticated too.
+	bad := bytes.Clone(sealed)
+	bad[headerSize-1] ^= 0x01
+	if _, err := open(key, bad); err == nil {
+		t.Error("flipped nonce prefix: no error")
+	}
+}
+
+func TestReorderedChunks(t *testing.T) {
+	key := testKey(4)
+	sealed := seal(t, key, plaintext(3*defaultChunkSize+1))
+	header, recs := records(t, sealed)
+	if len(recs) != 4 {
+		t.Fatalf("records = %d, want 4", len(recs))
+	}
+
+	swapped := join(header, recs[1], recs[0], recs[2], recs[3])
+	if _, err := open(key, swapped); err == nil {
+		t.Error("swapped chunks: no error")
+	}
+
+	// A non-final record relabelled as final still fails authentication.
+	early := bytes.Clone(recs[0])
+	early[0] = 1
+	if _, err := open(key, join(header, early)); err == nil {
+		t.Error("first chunk marked final: no error")
+	}
+
+	// A record spliced in from another stream under the same key.
+	other := seal(t, key, plaintext(3*defaultChunkSize+1))
+	_, otherRecs := records(t, other)
+	if _, err := open(key, join(header, recs[0], otherRecs[1], recs[2], recs[3])); err == nil {
+		t.Error("spliced chunk: no error")
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	key := testKey(5)
+	sealed := seal(t, key, plaintext(2*defaultChunkSize))
+	header, recs := records(t, sealed)
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"final chunk missing", join(header, recs[:len(recs)-1]...)},
+		{"final chunk cut short", sealed[:len(sealed)-10]},
+		{"record header cut short", join(header, recs[0], recs[1][:3])},
+		{"header only", header},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := open(key, tc.data)
+			if !errors.Is(err, ErrTruncated) {
+				t.Fatalf("err = %v (%d bytes returned), want ErrTruncated", err, len(got))
+			}
+		})
+	}
+
+	if _, err := open(key, append(bytes.Clone(sealed), 0)); err == nil {
+		t.Error("trailing data after the final chunk: no error")
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	sealed := seal(t, testKey(6), plaintext(1000))
+	wrong := testKey(7)
+
+	if _, err := open(wrong, sealed); !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("err = %v, want ErrWrongKey", err)
+	}
+
+	// With the key ID forged to match, decryption itself must still fail.
+	forged := bytes.Clone(sealed)
+	id := KeyID(wrong)
+	copy(forged[12:16], id[:])
+	if got, err := open(wrong, forged); err == nil {
+		t.Fatalf("forged key id: no error, %d bytes returned", len(got))
+	}
+}
+
+func TestNotEncrypted(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("PK\x03\x04 a zip file, not ours")} {
+		if _, err := NewReader(bytes.NewReader(data), testKey(8)); !errors.Is(err, ErrNotEncrypted) {
+			t.Errorf("%q: err = %v, want ErrNotEncrypted", data, err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package encrypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"httpBackupGo/config"
+)
+
+// ForSite returns the encryption settings for a site:
+// the site's own if set, otherwise the global ones.
+func ForSite(cfg config.Config, site config.Site) config.Encryption {
+	if site.Encryption != nil {
+		return *site.Encryption
+	}
+	return cfg.Encryption
+}
+
+// LoadKey reads the key from e.KeyFile, or from the e.KeyEnv environment
+// variable when no file is configured. It ignores e.Enabled so existing
+// encrypted backups stay readable after encryption is switched off.
+func LoadKey(e config.Encryption) ([]byte, error) {
+	if e.KeyFile != "" {
+		b, err := os.ReadFile(e.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read key file: %w", err)
+		}
+		if len(b) == KeySize {
+			return b, nil
+		}
+		key, err := parseKey(string(bytes.TrimSpace(b)))
+		if err != nil {
+			return nil, fmt.Errorf("key file %q: %w", e.KeyFile, err)
+		}
+		return key, nil
+	}
+
+	env := e.KeyEnv
+	if env == "" {
+		env = "HTTPBACKUP_ENCRYPTION_KEY"
+	}
+	v := os.Getenv(env)
+	if v == "" {
+		return nil, fmt.Errorf("no KeyFile configured and %s is not set", env)
+	}
+	key, err := parseKey(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", env, err)
+	}
+	return key, nil
+}
+
+func parseKey(s string) ([]byte, error) {
+	if len(s) == hex.EncodedLen(KeySize) {
+		if b, err := hex.DecodeString(s); err == nil {
+			return b, nil
+		}
+	}
+	if b, err := base64.StdEncoding.DecodeString(s); err == nil && len(b) == KeySize {
+		return b, nil
+	}
+	return nil, errors.New("key must be 32 bytes (raw, hex or base64)")
+}
+
+// Encrypt returns a reader producing the encrypted form of r.
+// The encryption runs in a goroutine; Close the reader to stop it early.
+func Encrypt(r io.Reader, key []byte) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		ew, err := NewWriter(pw, key)
+		if err == nil {
+			_, err = io.Copy(ew, r)
+		}
+		if err == nil {
+			err = ew.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
@@ -1,3 +1,12 @@
 module httpBackupGo
 
 go 1.25.1
+
+require (
+	github.com/pkg/sftp v1.13.10
+	golang.org/x/crypto v0.50.0
+	golang.org/x/sys v0.43.0
+	golang.org/x/term v0.42.0
+)
+
+require github.com/kr/fs v0.1.0 // indirect
@@ -0,0 +1,192 @@
+// Package instance keeps two copies of httpBackupGo from working on the same
+// config or backup folder at once. A lock is an OS file lock (flock or
+// LockFileEx), which the kernel drops when its process dies, so a lock left
+// behind by a crash never blocks the next start. The file itself only names
+// the holder; it is refreshed while held, so a holder on another host, whose
+// OS lock a network file system may not share, is still respected.
+package instance
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// refreshEvery is how often a held lock file is touched.
+	refreshEvery = time.Minute
+
+	// staleAfter is when holder info from another host, which our OS lock
+	// may not see, is considered abandoned.
+	staleAfter = 5 * time.Minute
+)
+
+// errLocked is returned by lockFile when another process holds the lock.
+var errLocked = errors.New("locked")
+
+// Info is the content of a lock file.
+type Info struct {
+	PID      int       `json:"PID"`
+	Hostname string    `json:"Hostname"`
+	Started  time.Time `json:"Started"`
+	Command  string    `json:"Command"`
+}
+
+// HeldError is returned by Acquire when a live instance holds the lock.
+type HeldError struct {
+	Path   string
+	Holder Info
+}
+
+func (e *HeldError) Error() string {
+	if e.Holder.PID == 0 {
+		return fmt.Sprintf("another instance holds %s; stop it first", e.Path)
+	}
+	return fmt.Sprintf(
+		"another instance holds %s (pid %d on %s, %q, since %s); stop it first",
+		e.Path, e.Holder.PID, e.Holder.Hostname, e.Holder.Command, e.Holder.Started.Format(time.RFC3339),
+	)
+}
+
+// Lock is a held lock file.
+type Lock struct {
+	f    *os.File
+	path string
+	stop chan struct{}
+	once sync.Once
+}
+
+// Acquire locks the file at path for command (e.g. "serve"). The file is
+// kept after Release; only the OS lock on it counts. Holder info left by a
+// process that is gone is overwritten, unless it names another host and was
+// refreshed recently.
+func Acquire(path, command string) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create lock directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open lock %s: %w", path, err)
+	}
+
+	if err := lockFile(f); err != nil {
+		holder, _, _ := read(f)
+		_ = f.Close()
+		if errors.Is(err, errLocked) {
+			return nil, &HeldError{Path: path, Holder: holder}
+		}
+		return nil, fmt.Errorf("lock %s: %w", path, err)
+	}
+
+	host, _ := os.Hostname()
+	holder, modTime, err := read(f)
+	switch {
+	case err != nil:
+		slog.Warn("instance: overwriting unreadable lock", "path", path, "err", err)
+	case holder.PID == 0:
+		// Released cleanly, or new.
+	case (holder.Hostname != host || !osLocks) && time.Since(modTime) < staleAfter:
+		_ = unlockFile(f)
+		_ = f.Close()
+		return nil, &HeldError{Path: path, Holder: holder}
+	default:
+		slog.Warn("instance: taking over lock left by a process that is gone", "path", path, "pid", holder.PID, "host", holder.Hostname)
+	}
+
+	l := &Lock{f: f, path: path, stop: make(chan struct{})}
+	if err := l.write(Info{PID: os.Getpid(), Hostname: host, Started: time.Now(), Command: command}); err != nil {
+		l.unlock()
+		return nil, fmt.Errorf("write lock %s: %w", path, err)
+	}
+	go l.refresh()
+	return l, nil
+}
+
+// AcquireWait is Acquire for short critical sections: while a live process
+// holds the lock it tries again until timeout has passed.
+func AcquireWait(path, command string, timeout time.Duration) (*Lock, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		l, err := Acquire(path, command)
+		var held *HeldError
+		if err == nil || !errors.As(err, &held) || time.Now().After(deadline) {
+			return l, err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// read returns the holder info in f; an empty file gives a zero Info.
+func read(f *os.File) (Info, time.Time, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return Info{}, time.Time{}, err
+	}
+	b, err := io.ReadAll(io.NewSectionReader(f, 0, st.Size()))
+	if err != nil {
+		return Info{}, st.ModTime(), err
+	}
+	var info Info
+	if len(b) == 0 {
+		return info, st.ModTime(), nil
+	}
+	if err := json.Unmarshal(b, &info); err != nil {
+		return Info{}, st.ModTime(), err
+	}
+	return info, st.ModTime(), nil
+}
+
+func (l *Lock) write(info Info) error {
+	b, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := l.f.Truncate(0); err != nil {
+		return err
+	}
+	_, err = l.f.WriteAt(append(b, '\n'), 0)
+	return err
+}
+
+// refresh keeps the file's modification time current so other hosts do not
+// take the lock over.
+func (l *Lock) refresh() {
+	t := time.NewTicker(refreshEvery)
+	defer t.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case now := <-t.C:
+			if err := os.Chtimes(l.path, now, now); err != nil {
+				slog.Warn("instance: refreshing lock failed", "path", l.path, "err", err)
+			}
+		}
+	}
+}
+
+// unlock clears the holder info and drops the OS lock. The file stays:
+// removing it would let a process that opened it before the removal lock
+// an orphaned file while a third creates a new one.
+func (l *Lock) unlock() {
+	_ = l.f.Truncate(0)
+	_ = unlockFile(l.f)
+	_ = l.f.Close()
+}
+
+// Release gives the lock up.
+func (l *Lock) Release() {
+	if l == nil {
+		return
+	}
+	l.once.Do(func() {
+		close(l.stop)
+		l.unlock()
+	})
+}
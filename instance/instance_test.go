@@ -0,0 +1,79 @@
+package instance
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeInfo leaves holder info in path without holding the OS lock, as a
+// crashed process would.
+func writeInfo(t *testing.T, path string, info Info) {
+	t.Helper()
+	b, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAcquireHeld(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "instance.lock")
+	l, err := Acquire(path, "serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Acquire(path, "run")
+	var held *HeldError
+	if !errors.As(err, &held) {
+		t.Fatalf("second Acquire: got %v, want HeldError", err)
+	}
+	if held.Holder.Command != "serve" || held.Holder.PID != os.Getpid() {
+		t.Errorf("holder = %+v", held.Holder)
+	}
+
+	l.Release()
+	l, err = Acquire(path, "run")
+	if err != nil {
+		t.Fatalf("Acquire after Release: %v", err)
+	}
+	l.Release()
+}
+
+func TestAcquireTakesOverLeftovers(t *testing.T) {
+	if !osLocks {
+		t.Skip("no OS locks on this platform")
+	}
+	host, _ := os.Hostname()
+	path := filepath.Join(t.TempDir(), "instance.lock")
+
+	// Our own PID, as after a reboot that handed it out again.
+	writeInfo(t, path, Info{PID: os.Getpid(), Hostname: host, Started: time.Now(), Command: "serve"})
+	l, err := Acquire(path, "serve")
+	if err != nil {
+		t.Fatalf("Acquire over leftover info: %v", err)
+	}
+	l.Release()
+
+	writeInfo(t, path, Info{PID: 1, Hostname: host + "-other", Started: time.Now(), Command: "serve"})
+	var held *HeldError
+	if _, err := Acquire(path, "serve"); !errors.As(err, &held) {
+		t.Fatalf("fresh info from another host: got %v, want HeldError", err)
+	}
+
+	old := time.Now().Add(-2 * staleAfter)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	l, err = Acquire(path, "serve")
+	if err != nil {
+		t.Fatalf("stale info from another host: %v", err)
+	}
+	l.Release()
+}
@@ -0,0 +1,27 @@
+//go:build unix && !aix
+
+package instance
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// osLocks reports whether lockFile takes a real OS lock.
+const osLocks = true
+
+// lockFile takes a non-blocking flock on f. The kernel drops it when the
+// process exits, however it exits.
+func lockFile(f *os.File) error {
+	err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if errors.Is(err, unix.EWOULDBLOCK) {
+		return errLocked
+	}
+	return err
+}
+
+func unlockFile(f *os.File) error {
+	return unix.Flock(int(f.Fd()), unix.LOCK_UN)
+}
@@ -0,0 +1,34 @@
+//go:build windows
+
+package instance
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// osLocks reports whether lockFile takes a real OS lock.
+const osLocks = true
+
+// lockRange is the byte locked by lockFile. It lies far past the end of the
+// file so the holder info stays readable by other processes.
+func lockRange() *windows.Overlapped {
+	return &windows.Overlapped{Offset: 0xffffffff, OffsetHigh: 0x7fffffff}
+}
+
+// lockFile takes a non-blocking LockFileEx lock on f. Windows drops it when
+// the handle is closed, including when the process dies.
+func lockFile(f *os.File) error {
+	err := windows.LockFileEx(windows.Handle(f.Fd()),
+		windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, lockRange())
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) || errors.Is(err, windows.ERROR_IO_PENDING) {
+		return errLocked
+	}
+	return err
+}
+
+func unlockFile(f *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, lockRange())
+}
@@ -16,15 +16,24 @@ type Options struct {
 	// If true, logs are written to stdout (recommended for journald).
 	ToStdout bool
 
+	// If true, console logs go to stderr instead, keeping stdout free for
+	// command output.
+	ToStderr bool
+
 	// Minimum level: slog.LevelInfo, slog.LevelDebug, etc.
 	Level slog.Level
+
+	// If set, the last lines are also kept here (see Recent).
+	Recent *Recent
 }
 
 func New(opts Options) (*slog.Logger, func(), error) {
 	var writers []io.Writer
 	closeFn := func() {}
 
-	if opts.ToStdout {
+	if opts.ToStderr {
+		writers = append(writers, os.Stderr)
+	} else if opts.ToStdout {
 		writers = append(writers, os.Stdout)
 	}
 
@@ -43,6 +52,7 @@ func New(opts Options) (*slog.Logger, func(), error) {
 		writers = append(writers, f)
 
 		closeFn = func() {
+			_ = f.Sync()
 			_ = f.Close()
 		}
 	}
@@ -51,6 +61,9 @@ func New(opts Options) (*slog.Logger, func(), error) {
 	if len(writers) == 0 {
 		writers = append(writers, os.Stdout)
 	}
+	if opts.Recent != nil {
+		writers = append(writers, opts.Recent)
+	}
 
 	mw := io.MultiWriter(writers...)
 
@@ -0,0 +1,43 @@
+package logging
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Recent keeps the last lines written to it in memory, for the web UI's log
+// page. The JSON handler writes one record per Write call.
+type Recent struct {
+	mu    sync.Mutex
+	lines [][]byte
+	next  int
+	full  bool
+}
+
+// NewRecent keeps the last n lines.
+func NewRecent(n int) *Recent {
+	return &Recent{lines: make([][]byte, n)}
+}
+
+func (r *Recent) Write(p []byte) (int, error) {
+	line := bytes.Clone(bytes.TrimRight(p, "\n"))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % len(r.lines)
+	if r.next == 0 {
+		r.full = true
+	}
+	return len(p), nil
+}
+
+// Lines returns the kept lines, oldest first.
+func (r *Recent) Lines() [][]byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([][]byte(nil), r.lines[:r.next]...)
+	}
+	return append(append([][]byte(nil), r.lines[r.next:]...), r.lines[:r.next]...)
+}
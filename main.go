@@ -2,182 +2,244 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"log/slog"
 	"os"
 	"os/signal"
 	"path/filepath"
-	"strconv"
-	"sync/atomic"
+	"strings"
+	"sync"
 	"syscall"
-	"time"
 
-	"httpBackupGo/backup"
+	"httpBackupGo/catalog"
 	"httpBackupGo/config"
+	"httpBackupGo/instance"
 	"httpBackupGo/logging"
-	"httpBackupGo/web"
 )
 
-func main() {
-	// ---- Logging (JSON) ----
-	logPath := defaultLogPath()
+// command is one subcommand of the binary.
+type command struct {
+	name    string
+	args    string // usage after the command name
+	summary string
 
-	logger, closeLogs, err := logging.New(logging.Options{
-		FilePath: logPath,
-		ToStdout: true, // journald-friendly
-		Level:    slog.LevelInfo,
-	})
-	if err != nil {
-		panic(err)
-	}
-	defer closeLogs()
+	// lock takes the single-instance locks: everything that writes to the
+	// config or the backup folder must not run next to another copy
+	// (e.g. a manual run next to the service).
+	lock bool
 
-	slog.SetDefault(logger)
-	slog.Info("logging initialized", "log_path", logPath)
+	// setup registers the command's own flags and returns the function that
+	// runs it once the flags are parsed and the config is loaded.
+	setup func(fs *flag.FlagSet) func(e *env) error
+}
 
-	// Optional legacy logger flags (can remove once all log.* calls are gone)
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
+var commands = []command{
+	{name: "serve", summary: "run the scheduler and the web UI (default)", lock: true, setup: serveCmd},
+	{name: "run", args: "[--site NAME]...", summary: "back up all enabled sites (or the given ones) once", lock: true, setup: runCmd},
+	{name: "validate", summary: "check the config without changing it", setup: validateCmd},
+	{name: "list", args: "[site...]", summary: "list the backups of every site", setup: listCmd},
+	{name: "prune", args: "[--keep N] [--dry-run] [site...]", summary: "apply retention now", lock: true, setup: pruneCmd},
+	{name: "reindex", args: "[site...]", summary: "rebuild catalogs from storage", lock: true, setup: reindexCmd},
+	{name: "scrub", summary: "verify all stored backups once", lock: true, setup: scrubCmd},
+	{name: "mirror-check", args: "[--deep] [--repair]", summary: "compare the mirrors with the primary storage", lock: true, setup: mirrorCheckCmd},
+	{name: "migrate", args: "[--from TEMPLATE] [--from-utc] [--dry-run] [site...]", summary: "move backups to the configured layout", lock: true, setup: migrateCmd},
+	{name: "user", args: "add|role|passwd|delete|list|token|revoke [args]", summary: "manage web UI users and API tokens", setup: userCmd},
+	{name: "decrypt", args: "[--site NAME] [--key-file FILE] <backup.enc> [output]", summary: "decrypt a downloaded backup", setup: decryptCmd},
+}
 
-	// ---- Config ----
-	cfgPath := defaultConfigPath()
-	events := make(chan web.Event, 8) // buffered so UI never blocks
+// recentLogLines is how many log lines the web UI's log page can show.
+const recentLogLines = 1000
 
-	// Load initial config ONCE (also creates it if missing)
-	cfg, err := config.LoadOrCreate(cfgPath)
-	if err != nil {
-		log.Fatalf("failed to load config: %v", err)
-	}
-	slog.Info("config loaded", "path", cfgPath)
+// env is what a command runs with.
+type env struct {
+	ctx     context.Context // cancelled on SIGINT/SIGTERM
+	cfgPath string
+	cfg     config.Config
+	args    []string // positional arguments after the flags
+
+	// recentLogs keeps the last log lines for the web UI (serve only).
+	recentLogs *logging.Recent
 
-	// ---- Start Web UI (addr from config; changes require restart) ----
-	go func(addr string) {
-		if err := web.StartServer(cfgPath, addr, events); err != nil {
-			log.Fatalf("web server failed: %v", err)
+	closeOnce sync.Once
+	closers   []func()
+}
+
+// close releases the locks and flushes the log file. Paths that call
+// os.Exit must call it first; it is safe to call more than once.
+func (e *env) close() {
+	e.closeOnce.Do(func() {
+		for i := len(e.closers) - 1; i >= 0; i-- {
+			e.closers[i]()
 		}
-	}(cfg.WebListenAddr)
+	})
+}
 
-	// ---- Context + signal handling ----
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+// exitCode ends the program with the given code without logging an error,
+// for commands whose result is reported on stdout (e.g. scrub found corrupt backups).
+type exitCode int
 
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+func (c exitCode) Error() string { return fmt.Sprintf("exit status %d", int(c)) }
 
-	// ---- Scheduler (IntervalMinutes==0 disables auto runs) ----
-	intervalMin := normalizeInterval(cfg.IntervalMinutes) // 0 stays 0
-	var ticker *time.Ticker
-	var tickCh <-chan time.Time
+func main() {
+	os.Exit(cli(os.Args[1:]))
+}
 
-	if intervalMin > 0 {
-		ticker = time.NewTicker(time.Duration(intervalMin) * time.Minute)
-		tickCh = ticker.C
-		slog.Info("scheduler started", "interval_minutes", intervalMin)
-	} else {
-		slog.Info("scheduler disabled (IntervalMinutes=0)")
+// cli runs one command and returns the process exit code: 0 on success,
+// 1 when the command failed and 2 for usage errors.
+func cli(args []string) int {
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		usage(os.Stdout)
+		return 0
 	}
 
-	// Prevent overlapping runs
-	var running atomic.Bool
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == name {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "httpbackupgo: unknown command %q\n\n", name)
+		usage(os.Stderr)
+		return 2
+	}
 
-	triggerRun := func(reason string) {
-		if !running.CompareAndSwap(false, true) {
-			slog.Warn("run skipped: already running", "reason", reason)
-			return
+	// ---- Flags ----
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	cfgPath := fs.String("config", defaultConfigPath(), "config file")
+	logPath := fs.String("log", defaultLogPath(), `log file ("" logs to the console only)`)
+	logLevel := fs.String("log-level", "info", "debug, info, warn or error")
+	runCommand := cmd.setup(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: httpbackupgo %s [flags] %s\n\n%s\n\nflags:\n", cmd.name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
 		}
+		return 2
+	}
 
-		go func() {
-			defer running.Store(false)
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		fmt.Fprintf(os.Stderr, "httpbackupgo: invalid --log-level %q\n", *logLevel)
+		return 2
+	}
 
-			cfgNow, err := config.LoadOrCreate(cfgPath)
-			if err != nil {
-				slog.Error("failed to reload config", "err", err)
-				return
-			}
+	e := &env{cfgPath: *cfgPath, args: fs.Args()}
+	defer e.close()
+	if name == "serve" {
+		e.recentLogs = logging.NewRecent(recentLogLines)
+	}
 
-			runOnce(ctx, cfgNow)
-		}()
+	// ---- Logging (JSON) ----
+	// The service logs to stdout (journald-friendly); commands log to stderr
+	// so their output can be piped.
+	logger, closeLogs, err := logging.New(logging.Options{
+		FilePath: *logPath,
+		ToStdout: true,
+		ToStderr: name != "serve",
+		Level:    level,
+		Recent:   e.recentLogs,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "httpbackupgo: %v\n", err)
+		return 1
 	}
+	e.closers = append(e.closers, closeLogs)
 
-	reloadTickerIfNeeded := func() {
-		cfgNow, err := config.LoadOrCreate(cfgPath)
-		if err != nil {
-			slog.Error("failed to reload config", "err", err)
-			return
-		}
+	slog.SetDefault(logger)
+	slog.Info("logging initialized", "log_path", *logPath)
 
-		newInterval := normalizeInterval(cfgNow.IntervalMinutes)
+	// Optional legacy logger flags (can remove once all log.* calls are gone)
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
-		// disabled -> enabled
-		if intervalMin == 0 && newInterval > 0 {
-			ticker = time.NewTicker(time.Duration(newInterval) * time.Minute)
-			tickCh = ticker.C
-			intervalMin = newInterval
-			slog.Info("scheduler enabled", "interval_minutes", intervalMin)
-			return
-		}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	e.ctx = ctx
 
-		// enabled -> disabled
-		if intervalMin > 0 && newInterval == 0 {
-			if ticker != nil {
-				ticker.Stop()
-			}
-			ticker = nil
-			tickCh = nil
-			intervalMin = 0
-			slog.Info("scheduler disabled (IntervalMinutes=0)")
-			return
-		}
+	if err := start(e, cmd); err != nil {
+		return fail(name, err)
+	}
+	if err := runCommand(e); err != nil {
+		return fail(name, err)
+	}
+	return 0
+}
 
-		// enabled -> enabled (interval changed)
-		if intervalMin > 0 && newInterval > 0 && newInterval != intervalMin {
-			if ticker != nil {
-				ticker.Stop()
-			}
-			ticker = time.NewTicker(time.Duration(newInterval) * time.Minute)
-			tickCh = ticker.C
-			intervalMin = newInterval
-			slog.Info("scheduler interval updated", "interval_minutes", intervalMin)
+// start takes the locks and loads the config for cmd.
+func start(e *env, cmd *command) error {
+	if cmd.lock {
+		cfgLock, err := instance.Acquire(e.cfgPath+".lock", cmd.name)
+		if err != nil {
+			return err
 		}
+		e.closers = append(e.closers, func() { cfgLock.Release() })
 	}
 
-	// ---- Main loop ----
-	for {
-		select {
-		case <-tickCh:
-			triggerRun("ticker")
-
-		case ev := <-events:
-			switch ev.Type {
-			case web.EventConfigChanged:
-				slog.Info("event: config changed -> reloading scheduler")
-				reloadTickerIfNeeded()
-
-			case web.EventRunNow:
-				slog.Info("event: run now")
-				triggerRun("run-now")
-			}
-
-		case <-sig:
-			slog.Info("shutdown signal received")
-			cancel()
-			return
+	var err error
+	switch cmd.name {
+	case "validate", "list":
+		// Must not write (they take no lock): no default config, no
+		// generated IDs saved.
+		e.cfg, err = config.Load(e.cfgPath)
+	case "serve":
+		// Also creates the config if missing.
+		e.cfg, err = config.LoadOrCreate(e.cfgPath)
+	default:
+		// A typo in --config must not silently create a default config.
+		if _, err := os.Stat(e.cfgPath); err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+		e.cfg, err = config.LoadOrCreate(e.cfgPath)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	slog.Info("config loaded", "path", e.cfgPath)
 
-		case <-ctx.Done():
-			return
+	if cmd.lock {
+		dirLock, err := instance.Acquire(filepath.Join(e.cfg.BackupFolder, catalog.StateDirName, "instance.lock"), cmd.name)
+		if err != nil {
+			return err
 		}
+		e.closers = append(e.closers, func() { dirLock.Release() })
 	}
+	return nil
 }
 
-func runOnce(ctx context.Context, cfg config.Config) {
-	maxPar := 5
-	if v := os.Getenv("HTTPBACKUP_MAX_PARALLEL"); v != "" {
-		if n, err := strconv.Atoi(v); err == nil && n > 0 {
-			maxPar = n
-		}
+// fail reports err and returns the exit code for it.
+func fail(name string, err error) int {
+	var code exitCode
+	if errors.As(err, &code) {
+		return int(code)
 	}
+	slog.Error(name+" failed", "err", err)
+	return 1
+}
 
-	r := backup.NewRunner(maxPar)
-	r.RunAllEnabled(ctx, cfg)
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "usage: httpbackupgo [command] [flags] [args]\n\ncommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-13s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, `
+flags (all commands):
+  --config PATH      config file (default %q)
+  --log PATH         log file, "" logs to the console only (default %q)
+  --log-level LEVEL  debug, info, warn or error (default "info")
+
+Run "httpbackupgo <command> -h" for the flags of a command.
+`, defaultConfigPath(), defaultLogPath())
 }
 
 // normalizeInterval keeps 0 as "disabled" and normalizes negative values.
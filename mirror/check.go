@@ -0,0 +1,172 @@
+package mirror
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"httpBackupGo/catalog"
+	"httpBackupGo/config"
+	"httpBackupGo/storage"
+)
+
+// Problem kinds reported by Check.
+const (
+	ProblemMissing = "missing" // expected on the mirror but not there
+	ProblemDiffers = "differs" // present but size (or, with deep, SHA-256) differs
+	ProblemExtra   = "extra"   // on the mirror but not in the primary catalog
+)
+
+// Problem is one inconsistency between a site's primary storage and a mirror.
+type Problem struct {
+	Mirror string
+	Site   string
+	Key    string
+	Kind   string
+	Detail string
+
+	entry catalog.Entry
+}
+
+// Check compares every enabled mirror with the primary catalogs.
+//
+// A mirror is expected to hold the newest N backups of each site, where N is
+// the mirror's retention; older primary backups missing there are fine.
+// With deep set, every expected backup is downloaded and hashed.
+func Check(ctx context.Context, cfg config.Config, deep bool) ([]Problem, error) {
+	var out []Problem
+
+	for _, m := range cfg.Mirrors {
+		if !m.Enabled {
+			continue
+		}
+
+		dst, err := storage.New(cfg, m.Storage)
+		if err != nil {
+			return nil, fmt.Errorf("mirror %q: %w", m.Name, err)
+		}
+
+		for _, site := range cfg.Sites {
+			ix, err := catalog.ForSite(cfg, site)
+			if err != nil {
+				return nil, fmt.Errorf("site %q: %w", site.Name, err)
+			}
+
+			c, err := ix.Load(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("site %q: %w", site.Name, err)
+			}
+
+			objs, err := dst.List(ctx, ix.Layout.Prefix())
+			if err != nil {
+				return nil, fmt.Errorf("mirror %q site %q: %w", m.Name, site.Name, err)
+			}
+			onMirror := map[string]storage.Object{}
+			for _, o := range objs {
+				if _, _, ok := ix.Layout.Parse(o.Key); ok {
+					onMirror[o.Key] = o
+				}
+			}
+
+			expected := c.Entries
+			if keep := keepFor(cfg, m); keep > 0 && len(expected) > keep {
+				expected = expected[len(expected)-keep:]
+			}
+
+			inPrimary := map[string]bool{}
+			for _, e := range c.Entries {
+				inPrimary[e.Key] = true
+			}
+
+			for _, e := range expected {
+				p := Problem{Mirror: m.Name, Site: site.Name, Key: e.Key, entry: e}
+
+				o, ok := onMirror[e.Key]
+				switch {
+				case !ok:
+					p.Kind = ProblemMissing
+				case o.Size != e.Size:
+					p.Kind = ProblemDiffers
+					p.Detail = fmt.Sprintf("size: primary %d, mirror %d", e.Size, o.Size)
+				case deep && e.SHA256 != "":
+					sum, err := hashObject(ctx, dst, o.Key)
+					if err != nil {
+						return nil, err
+					}
+					if sum == e.SHA256 {
+						continue
+					}
+					p.Kind = ProblemDiffers
+					p.Detail = "sha256 mismatch"
+				default:
+					continue
+				}
+				out = append(out, p)
+			}
+
+			for key := range onMirror {
+				if !inPrimary[key] {
+					out = append(out, Problem{Mirror: m.Name, Site: site.Name, Key: key, Kind: ProblemExtra})
+				}
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// Repair re-replicates every missing or differing backup found by Check.
+// It returns the number of backups that could not be repaired.
+func Repair(ctx context.Context, cfg config.Config, problems []Problem) int {
+	sites := map[string]config.Site{}
+	for _, s := range cfg.Sites {
+		sites[s.Name] = s
+	}
+	mirrors := map[string]config.Mirror{}
+	for _, m := range cfg.Mirrors {
+		mirrors[m.Name] = m
+	}
+
+	failed := 0
+	for _, p := range problems {
+		if p.Kind != ProblemMissing && p.Kind != ProblemDiffers {
+			continue
+		}
+
+		m := mirrors[p.Mirror]
+		if p.Kind == ProblemDiffers {
+			// Force a fresh copy; Replicate skips same-size objects.
+			if dst, err := storage.New(cfg, m.Storage); err == nil {
+				_ = dst.Delete(ctx, p.Key)
+			}
+		}
+		if err := Replicate(ctx, cfg, sites[p.Site], m, p.entry); err != nil {
+			slog.Error(
+				"mirror: repair failed",
+				"mirror", p.Mirror,
+				"site", p.Site,
+				"key", p.Key,
+				"err", err,
+			)
+			failed++
+		}
+	}
+	return failed
+}
+
+func hashObject(ctx context.Context, b storage.Backend, key string) (string, error) {
+	rc, err := b.Open(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("open %q: %w", key, err)
+	}
+	defer rc.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return "", fmt.Errorf("hash %q: %w", key, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
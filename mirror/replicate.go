@@ -0,0 +1,221 @@
+package mirror
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"httpBackupGo/alert"
+	"httpBackupGo/catalog"
+	"httpBackupGo/config"
+	"httpBackupGo/retention"
+	"httpBackupGo/storage"
+)
+
+// Retry policy for a single replication job.
+const (
+	maxAttempts  = 5
+	firstBackoff = 30 * time.Second
+)
+
+type job struct {
+	cfg    config.Config
+	site   config.Site
+	mirror config.Mirror
+	entry  catalog.Entry
+}
+
+// Replicator copies new backups to the configured mirrors in the background.
+// Each (backup, mirror) pair is retried independently with exponential backoff;
+// whatever still fails is reported by Check and can be fixed with Repair.
+type Replicator struct {
+	jobs    chan job
+	workers int
+	wg      sync.WaitGroup
+}
+
+func NewReplicator(workers int) *Replicator {
+	if workers <= 0 {
+		workers = 2
+	}
+	return &Replicator{
+		jobs:    make(chan job, 256),
+		workers: workers,
+	}
+}
+
+// Start launches the workers. They stop when ctx is cancelled or Close is called.
+func (r *Replicator) Start(ctx context.Context) {
+	for i := 0; i < r.workers; i++ {
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			for {
+				select {
+				case j, ok := <-r.jobs:
+					if !ok {
+						return
+					}
+					r.run(ctx, j)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+}
+
+// Close stops accepting jobs; Wait then returns once the queue is drained.
+func (r *Replicator) Close() { close(r.jobs) }
+
+func (r *Replicator) Wait() { r.wg.Wait() }
+
+// Pending is the number of queued jobs no worker has picked up yet.
+func (r *Replicator) Pending() int { return len(r.jobs) }
+
+// Enqueue schedules a backup for replication to every enabled mirror.
+// It never blocks the backup run: if the queue is full the job is dropped
+// (and shows up as missing in the next consistency check).
+func (r *Replicator) Enqueue(cfg config.Config, site config.Site, e catalog.Entry) {
+	if r == nil {
+		return
+	}
+
+	for _, m := range cfg.Mirrors {
+		if !m.Enabled {
+			continue
+		}
+
+		select {
+		case r.jobs <- job{cfg: cfg, site: site, mirror: m, entry: e}:
+		default:
+			slog.Warn(
+				"mirror: queue full, replication dropped",
+				"mirror", m.Name,
+				"site", site.Name,
+				"file", e.Name,
+			)
+		}
+	}
+}
+
+func (r *Replicator) run(ctx context.Context, j job) {
+	backoff := firstBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := Replicate(ctx, j.cfg, j.site, j.mirror, j.entry)
+		if err == nil {
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		if attempt >= maxAttempts {
+			slog.Error(
+				"mirror: replication failed, giving up",
+				"mirror", j.mirror.Name,
+				"site", j.site.Name,
+				"file", j.entry.Name,
+				"attempts", attempt,
+				"err", err,
+			)
+			alert.Send(ctx, j.cfg.AlertWebhookURL, alert.Alert{
+				Kind:    "mirror_failed",
+				Site:    j.site.Name,
+				Message: fmt.Sprintf("replicating %s to %s failed after %d attempts: %v", j.entry.Name, j.mirror.Name, attempt, err),
+			})
+			return
+		}
+
+		slog.Warn(
+			"mirror: replication failed, retrying",
+			"mirror", j.mirror.Name,
+			"site", j.site.Name,
+			"file", j.entry.Name,
+			"attempt", attempt,
+			"retry_in", backoff.String(),
+			"err", err,
+		)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff *= 2
+	}
+}
+
+// Replicate copies one backup from the site's primary storage to a mirror,
+// verifying the SHA-256 on the way, then applies the mirror's retention.
+// It is a no-op when the mirror already has an object of the same size.
+func Replicate(ctx context.Context, cfg config.Config, site config.Site, m config.Mirror, e catalog.Entry) error {
+	ix, err := catalog.ForSite(cfg, site)
+	if err != nil {
+		return fmt.Errorf("primary storage: %w", err)
+	}
+	dst, err := storage.New(cfg, m.Storage)
+	if err != nil {
+		return fmt.Errorf("mirror storage: %w", err)
+	}
+
+	key := e.Key
+	start := time.Now()
+
+	if o, err := dst.Stat(ctx, key); err == nil && o.Size == e.Size {
+		return nil
+	}
+
+	rc, err := ix.Backend.Open(ctx, key)
+	if err != nil {
+		return fmt.Errorf("open primary %q: %w", key, err)
+	}
+	defer rc.Close()
+
+	h := sha256.New()
+	written, err := dst.Put(ctx, key, io.TeeReader(rc, h))
+	if err != nil {
+		return fmt.Errorf("put %q: %w", key, err)
+	}
+
+	if sum := hex.EncodeToString(h.Sum(nil)); e.SHA256 != "" && sum != e.SHA256 {
+		_ = dst.Delete(ctx, key)
+		return errors.New("sha256 mismatch while copying (primary changed or corrupt)")
+	}
+
+	slog.Info(
+		"mirror: replicated",
+		"mirror", m.Name,
+		"storage", dst.String(),
+		"site", site.Name,
+		"key", key,
+		"bytes", written,
+		"duration_ms", time.Since(start).Milliseconds(),
+	)
+
+	// Apply the mirror's own retention (best-effort)
+	if err := retention.CleanupMirror(ctx, dst, ix.Layout, keepFor(cfg, m)); err != nil {
+		slog.Warn(
+			"retention: mirror cleanup error",
+			"mirror", m.Name,
+			"site", site.Name,
+			"err", err,
+		)
+	}
+	return nil
+}
+
+// keepFor returns the mirror's retention, falling back to the global one.
+func keepFor(cfg config.Config, m config.Mirror) int {
+	if m.Retention > 0 {
+		return m.Retention
+	}
+	return cfg.Retention
+}
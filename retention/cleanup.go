@@ -1,81 +1,100 @@
 package retention
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
-	"os"
-	"path/filepath"
 	"sort"
-	"strings"
 	"time"
+
+	"httpBackupGo/catalog"
+	"httpBackupGo/storage"
 )
 
-// CleanupSite keeps at most `keep` backup zip files in `siteDir`.
-// It removes the oldest files first.
-// Files are matched by prefix "backup_<siteName>_" and suffix ".zip".
-func CleanupSite(siteDir string, siteName string, keep int) error {
+// CleanupSite keeps at most `keep` backups for a site.
+// It removes the oldest backups first.
+// Backups are taken from the site's catalog, so no directory scan is needed;
+// deletes go through the site's storage backend and the catalog is updated after.
+func CleanupSite(ctx context.Context, ix catalog.Index, keep int) error {
 	if keep <= 0 {
 		return nil // nothing to keep == do nothing (safest)
 	}
 
-	entries, err := os.ReadDir(siteDir)
+	c, err := ix.Load(ctx)
 	if err != nil {
-		return fmt.Errorf("readdir %q: %w", siteDir, err)
+		return fmt.Errorf("load catalog: %w", err)
 	}
 
-	prefix := "backup_" + siteName + "_"
-
-	type fileInfo struct {
-		name string
-		path string
-		mod  time.Time
+	if len(c.Entries) <= keep {
+		return nil // nothing to delete
 	}
 
-	var files []fileInfo
+	// Catalog entries are oldest first
+	toDelete := c.Entries[:len(c.Entries)-keep]
 
-	for _, e := range entries {
-		if e.IsDir() {
+	var removed []string
+	for _, e := range toDelete {
+		if err := ix.Backend.Delete(ctx, e.Key); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("retention: failed to remove %s: %v", e.Key, err)
 			continue
 		}
+		log.Printf("retention: removed old backup %s", e.Key)
+		removed = append(removed, e.Key)
+	}
 
-		name := e.Name()
+	if len(removed) == 0 {
+		return nil
+	}
+	if err := ix.Remove(ctx, removed...); err != nil {
+		return fmt.Errorf("update catalog: %w", err)
+	}
+	return nil
+}
 
-		// Strict match: our backups only
-		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".zip") {
-			continue
-		}
+// CleanupMirror keeps at most `keep` backups of a site on a mirror backend.
+// Mirrors have no catalog, so the keys matching the site's layout are listed
+// and the oldest backups (by the timestamp in their key) are removed first.
+func CleanupMirror(ctx context.Context, b storage.Backend, layout catalog.Layout, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
 
-		info, err := e.Info()
-		if err != nil {
-			log.Printf("retention: stat failed %s: %v", name, err)
-			continue
-		}
+	objs, err := b.List(ctx, layout.Prefix())
+	if err != nil {
+		return fmt.Errorf("list: %w", err)
+	}
 
-		files = append(files, fileInfo{
-			name: name,
-			path: filepath.Join(siteDir, name),
-			mod:  info.ModTime(),
-		})
+	var files []storage.Object
+	for _, o := range objs {
+		if _, _, ok := layout.Parse(o.Key); ok {
+			files = append(files, o)
+		}
 	}
 
 	if len(files) <= keep {
-		return nil // nothing to delete
+		return nil
 	}
 
-	// Oldest first
+	// Oldest first. Upload time is only a fallback: a repaired mirror gets
+	// old backups uploaded late.
+	when := func(o storage.Object) time.Time {
+		if t, _, _ := layout.Parse(o.Key); !t.IsZero() {
+			return t
+		}
+		return o.ModTime
+	}
 	sort.Slice(files, func(i, j int) bool {
-		return files[i].mod.Before(files[j].mod)
+		return when(files[i]).Before(when(files[j]))
 	})
 
-	toDelete := files[:len(files)-keep]
-
-	for _, f := range toDelete {
-		if err := os.Remove(f.path); err != nil {
-			log.Printf("retention: failed to remove %s: %v", f.path, err)
-		} else {
-			log.Printf("retention: removed old backup %s", f.name)
+	for _, o := range files[:len(files)-keep] {
+		if err := b.Delete(ctx, o.Key); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("retention: failed to remove %s from %s: %v", o.Key, b, err)
+			continue
 		}
+		log.Printf("retention: removed old backup %s from %s", o.Key, b)
 	}
-
 	return nil
 }
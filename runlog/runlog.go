@@ -0,0 +1,264 @@
+// Package runlog keeps the history of backup runs in the state folder
+// (<BackupFolder>/.httpbackupgo/runs.json). The scheduler and the `run`
+// command record every run when it starts and when it ends; the web UI and
+// the API only read it. The newest runs are kept, see maxRuns.
+package runlog
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"httpBackupGo/backup"
+	"httpBackupGo/catalog"
+	"httpBackupGo/storage"
+)
+
+// FileName is the history file in the state folder.
+const FileName = "runs.json"
+
+// maxRuns caps the history; older runs are dropped.
+const maxRuns = 100
+
+// Run and site statuses.
+const (
+	StatusQueued  = "queued" // accepted by the API, not started yet
+	StatusRunning = "running"
+	StatusOK      = "ok"
+	StatusPartial = "partial" // some sites failed
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped" // another run was in progress
+)
+
+// SiteResult is the outcome of one site in a run.
+type SiteResult struct {
+	Site       string `json:"Site"`
+	Status     string `json:"Status"` // ok or failed
+	Key        string `json:"Key,omitempty"`
+	Bytes      int64  `json:"Bytes,omitempty"`
+	SHA256     string `json:"SHA256,omitempty"`
+	DurationMs int64  `json:"DurationMs"`
+	Error      string `json:"Error,omitempty"`
+}
+
+// Run is one backup run.
+type Run struct {
+	ID        string   `json:"ID"`
+	Trigger   string   `json:"Trigger"`             // ticker, run-now, api, cli
+	Requested []string `json:"Requested,omitempty"` // site names; empty means all enabled sites
+	Status    string   `json:"Status"`
+
+	Started    time.Time    `json:"Started"`
+	Finished   time.Time    `json:"Finished,omitzero"`
+	DurationMs int64        `json:"DurationMs"`
+	Succeeded  int          `json:"Succeeded"`
+	Failed     int          `json:"Failed"`
+	Sites      []SiteResult `json:"Sites"`
+	Error      string       `json:"Error,omitempty"`
+}
+
+// NewID returns a sortable, unique run ID (start time plus random suffix).
+func NewID() string {
+	var b [3]byte
+	_, _ = rand.Read(b[:])
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b[:])
+}
+
+// Begin returns a running Run. An empty id gets a new one.
+func Begin(id, trigger string, requested []string) Run {
+	if id == "" {
+		id = NewID()
+	}
+	return Run{
+		ID:        id,
+		Trigger:   trigger,
+		Requested: requested,
+		Status:    StatusRunning,
+		Started:   time.Now(),
+		Sites:     []SiteResult{},
+	}
+}
+
+// Complete fills in the results and the overall status: ok when every site
+// succeeded (or there were none), failed when all failed, partial otherwise.
+func (r *Run) Complete(results []backup.Result) {
+	r.Finished = time.Now()
+	r.DurationMs = r.Finished.Sub(r.Started).Milliseconds()
+	r.Sites = make([]SiteResult, 0, len(results))
+	r.Succeeded, r.Failed = 0, 0
+
+	for _, res := range results {
+		s := SiteResult{
+			Site:       res.Site,
+			Status:     StatusOK,
+			DurationMs: res.Duration.Milliseconds(),
+		}
+		if res.Err != nil {
+			s.Status = StatusFailed
+			s.Error = res.Err.Error()
+			r.Failed++
+		} else {
+			s.Key = res.Entry.Key
+			s.Bytes = res.Entry.Size
+			s.SHA256 = res.Entry.SHA256
+			r.Succeeded++
+		}
+		r.Sites = append(r.Sites, s)
+	}
+
+	switch {
+	case r.Failed == 0:
+		r.Status = StatusOK
+	case r.Succeeded == 0:
+		r.Status = StatusFailed
+	default:
+		r.Status = StatusPartial
+	}
+}
+
+// mu serializes writers in this process; other processes are kept out by
+// the single-instance lock.
+var mu sync.Mutex
+
+// Record adds r to the history, replacing an earlier record with the same ID.
+func Record(backupFolder string, r Run) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	runs, err := Load(backupFolder)
+	if err != nil {
+		return err
+	}
+	return save(backupFolder, prepend(runs, r))
+}
+
+// Enqueue records r as queued unless a run is already queued or running, in
+// which case it returns that run and busy. Checking and recording are one
+// step, so of two concurrent callers only one gets its run queued.
+func Enqueue(backupFolder string, r Run) (cur Run, busy bool, err error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	runs, err := Load(backupFolder)
+	if err != nil {
+		return Run{}, false, err
+	}
+	for _, old := range runs {
+		if old.Status == StatusQueued || old.Status == StatusRunning {
+			return old, true, nil
+		}
+	}
+	r.Status = StatusQueued
+	return Run{}, false, save(backupFolder, prepend(runs, r))
+}
+
+// prepend puts r in front of runs, dropping an older record of r and the
+// runs beyond maxRuns.
+func prepend(runs []Run, r Run) []Run {
+	out := []Run{r}
+	for _, old := range runs {
+		if old.ID != r.ID && len(out) < maxRuns {
+			out = append(out, old)
+		}
+	}
+	return out
+}
+
+// Recover marks runs left queued or running by a process that died as failed.
+// Call it at startup, before any run is recorded.
+func Recover(backupFolder string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	runs, err := Load(backupFolder)
+	if err != nil {
+		return err
+	}
+	changed := false
+	for i := range runs {
+		if runs[i].Status == StatusQueued || runs[i].Status == StatusRunning {
+			runs[i].Status = StatusFailed
+			runs[i].Error = "interrupted: the process stopped during the run"
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return save(backupFolder, runs)
+}
+
+// Load returns the recorded runs, newest first.
+func Load(backupFolder string) ([]Run, error) {
+	b, err := os.ReadFile(historyPath(backupFolder))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Run{}, nil
+		}
+		return nil, err
+	}
+	var runs []Run
+	if err := json.Unmarshal(b, &runs); err != nil {
+		return nil, fmt.Errorf("parse run history: %w", err)
+	}
+	return runs, nil
+}
+
+// Get returns the run with the given ID.
+func Get(backupFolder, id string) (Run, bool, error) {
+	runs, err := Load(backupFolder)
+	if err != nil {
+		return Run{}, false, err
+	}
+	for _, r := range runs {
+		if r.ID == id {
+			return r, true, nil
+		}
+	}
+	return Run{}, false, nil
+}
+
+// Current returns the queued or running run, if any.
+func Current(backupFolder string) (Run, bool, error) {
+	runs, err := Load(backupFolder)
+	if err != nil {
+		return Run{}, false, err
+	}
+	for _, r := range runs {
+		if r.Status == StatusQueued || r.Status == StatusRunning {
+			return r, true, nil
+		}
+	}
+	return Run{}, false, nil
+}
+
+func historyPath(backupFolder string) string {
+	return filepath.Join(filepath.Clean(backupFolder), catalog.StateDirName, FileName)
+}
+
+func save(backupFolder string, runs []Run) error {
+	p := historyPath(backupFolder)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return fmt.Errorf("create state directory: %w", err)
+	}
+	b, err := json.MarshalIndent(runs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal run history: %w", err)
+	}
+	b = append(b, '\n')
+
+	tmp := p + storage.TempSuffix
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("write run history: %w", err)
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("replace run history: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package scrub
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"strings"
+	"time"
+
+	"httpBackupGo/alert"
+	"httpBackupGo/catalog"
+	"httpBackupGo/config"
+	"httpBackupGo/encrypt"
+)
+
+// Result summarizes one scrub pass.
+type Result struct {
+	Checked int
+	Corrupt int
+	Bytes   int64
+}
+
+// Run re-reads every catalogued backup of every site and compares it with the
+// recorded SHA-256. In the same pass zip files have each member decompressed
+// and checked against its stored CRC-32, and gzip files are decompressed
+// (gzip checks its own CRC-32). Encrypted backups are decrypted
+// on the fly when the key is available: GCM authenticates every chunk.
+//
+// Reads are throttled to cfg.ScrubMaxMBps so the scrub does not starve the
+// download runner of disk bandwidth. Corrupt backups are flagged in the catalog
+// and reported via alert.Send; they are never deleted.
+func Run(ctx context.Context, cfg config.Config) (Result, error) {
+	var res Result
+	limit := int64(cfg.ScrubMaxMBps) * 1024 * 1024
+
+	slog.Info("scrub: started", "sites", len(cfg.Sites), "max_mbps", cfg.ScrubMaxMBps)
+	start := time.Now()
+
+	for _, site := range cfg.Sites {
+		ix, err := catalog.ForSite(cfg, site)
+		if err != nil {
+			slog.Error("scrub: storage failed", "site", site.Name, "err", err)
+			continue
+		}
+
+		c, err := ix.Load(ctx)
+		if err != nil {
+			slog.Error("scrub: load catalog failed", "site", site.Name, "err", err)
+			continue
+		}
+
+		key := siteKey(cfg, site, c)
+
+		// Verdicts are written once per site: every catalog update rewrites
+		// the whole file. On cancellation the ones so far are still saved.
+		var verdicts []catalog.Verdict
+		save := func() {
+			if err := ix.MarkVerified(context.WithoutCancel(ctx), verdicts); err != nil {
+				slog.Warn("scrub: catalog update failed", "site", site.Name, "backups", len(verdicts), "err", err)
+			}
+		}
+
+		for _, e := range c.Entries {
+			if err := ctx.Err(); err != nil {
+				save()
+				return res, err
+			}
+
+			problem, err := verify(ctx, ix, e, key, limit)
+			if err != nil {
+				// Aborted (context) rather than a verdict on the file.
+				save()
+				return res, err
+			}
+
+			res.Checked++
+			res.Bytes += e.Size
+			verdicts = append(verdicts, catalog.Verdict{Key: e.Key, Problem: problem, Time: time.Now()})
+
+			if problem == "" {
+				continue
+			}
+
+			res.Corrupt++
+			slog.Error("scrub: backup corrupt", "site", site.Name, "file", e.Name, "problem", problem)
+
+			// Alert only on the transition to corrupt, not on every pass.
+			if !e.Corrupt {
+				alert.Send(ctx, cfg.AlertWebhookURL, alert.Alert{
+					Kind:    "scrub_corrupt",
+					Site:    site.Name,
+					Message: fmt.Sprintf("backup %s failed verification: %s", e.Name, problem),
+				})
+			}
+		}
+		save()
+	}
+
+	slog.Info(
+		"scrub: finished",
+		"checked", res.Checked,
+		"corrupt", res.Corrupt,
+		"bytes", res.Bytes,
+		"duration_ms", time.Since(start).Milliseconds(),
+	)
+	return res, nil
+}
+
+// siteKey returns the decryption key for a site with encrypted backups.
+// Without it those backups still get the SHA-256 check.
+func siteKey(cfg config.Config, site config.Site, c catalog.Catalog) []byte {
+	for _, e := range c.Entries {
+		if !strings.HasSuffix(e.Name, encrypt.Suffix) {
+			continue
+		}
+		key, err := encrypt.LoadKey(encrypt.ForSite(cfg, site))
+		if err != nil {
+			slog.Warn("scrub: no decryption key, encrypted backups are checked by SHA-256 only", "site", site.Name, "err", err)
+			return nil
+		}
+		return key
+	}
+	return nil
+}
+
+// verify returns a human-readable problem, or "" when the file is fine.
+// The error return is reserved for cancellation.
+func verify(ctx context.Context, ix catalog.Index, e catalog.Entry, key []byte, limit int64) (string, error) {
+	f, err := ix.Backend.Open(ctx, e.Key)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "file missing", nil
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "open: " + err.Error(), nil
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	var n countWriter
+	in := &errReader{r: newThrottledReader(ctx, f, limit)}
+	src := io.TeeReader(in, io.MultiWriter(h, &n))
+
+	streamProblem := verifyStream(src, e.Name, key, e.Key)
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if in.err != nil {
+		// The backend failed, not the checks on what it returned.
+		return "read: " + in.err.Error(), nil
+	}
+
+	// Hash whatever the stream check did not consume (everything otherwise).
+	if _, err := io.Copy(io.Discard, src); err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "read: " + err.Error(), nil
+	}
+
+	if int64(n) != e.Size {
+		return fmt.Sprintf("size mismatch: catalog %d, disk %d", e.Size, n), nil
+	}
+	if e.SHA256 != "" {
+		if sum := hex.EncodeToString(h.Sum(nil)); sum != e.SHA256 {
+			return "sha256 mismatch", nil
+		}
+	}
+	return streamProblem, nil
+}
+
+// verifyStream checks the layers that carry their own integrity data while
+// the file is hashed: encryption (GCM tags, when the key is available), gzip
+// (CRC-32) and the zip inside (member CRC-32s). It returns a problem, or "".
+// A different key is not corruption: the file is left to the SHA-256 check.
+func verifyStream(r io.Reader, name string, key []byte, objKey string) string {
+	checked := false
+
+	if strings.HasSuffix(name, encrypt.Suffix) {
+		if key == nil {
+			return ""
+		}
+		dr, err := encrypt.NewReader(r, key)
+		if errors.Is(err, encrypt.ErrWrongKey) {
+			slog.Warn("scrub: backup was encrypted with a different key", "key", objKey)
+			return ""
+		}
+		if err != nil {
+			return "content: " + err.Error()
+		}
+		r, name, checked = dr, strings.TrimSuffix(name, encrypt.Suffix), true
+	}
+
+	if strings.HasSuffix(name, ".gz") {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return "content: " + err.Error()
+		}
+		r, name, checked = zr, strings.TrimSuffix(name, ".gz"), true
+	}
+
+	if strings.HasSuffix(strings.ToLower(name), ".zip") {
+		br := bufio.NewReader(r)
+		if problem := verifyZipStream(br); problem != "" {
+			return problem
+		}
+		r, checked = br, true
+	}
+
+	if !checked {
+		return ""
+	}
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return "content: " + err.Error()
+	}
+	return ""
+}
+
+// errReader remembers the first read error other than io.EOF.
+type errReader struct {
+	r   io.Reader
+	err error
+}
+
+func (e *errReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && err != io.EOF && e.err == nil {
+		e.err = err
+	}
+	return n, err
+}
+
+type countWriter int64
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	*c += countWriter(len(p))
+	return len(p), nil
+}
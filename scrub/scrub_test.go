@@ -0,0 +1,241 @@
+package scrub
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"strings"
+	"testing"
+
+	"httpBackupGo/catalog"
+	"httpBackupGo/encrypt"
+	"httpBackupGo/storage"
+)
+
+// member is one file in a test zip.
+type member struct {
+	name   string
+	method uint16
+	data   []byte
+}
+
+func testMembers() []member {
+	rng := rand.New(rand.NewPCG(1, 2))
+	random := make([]byte, 100_000)
+	for i := range random {
+		random[i] = byte(rng.Uint32())
+	}
+	// Stored data that contains a data descriptor signature, which must not
+	// be taken for the end of the member.
+	tricky := append([]byte("before PK\x07\x08"), random[:5000]...)
+
+	return []member{
+		{"a.sql", zip.Deflate, bytes.Repeat([]byte("INSERT INTO t VALUES (1, 'row');\n"), 20_000)},
+		{"b.bin", zip.Store, tricky},
+		{"dir/", zip.Store, nil},
+		{"dir/empty.txt", zip.Deflate, nil},
+		{"dir/random.bin", zip.Deflate, random},
+	}
+}
+
+// streamedZip is written the way archive/zip does by default: sizes and
+// CRC-32 in a data descriptor after each member.
+func streamedZip(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, m := range testMembers() {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: m.name, Method: m.method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(m.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// sizedZip has sizes and CRC-32 in the local headers, like most PHP exports.
+func sizedZip(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, m := range testMembers() {
+		raw := m.data
+		if m.method == zip.Deflate {
+			var c bytes.Buffer
+			fw, _ := flate.NewWriter(&c, flate.DefaultCompression)
+			fw.Write(m.data)
+			fw.Close()
+			raw = c.Bytes()
+		}
+		w, err := zw.CreateRaw(&zip.FileHeader{
+			Name:               m.name,
+			Method:             m.method,
+			CRC32:              crc32.ChecksumIEEE(m.data),
+			CompressedSize64:   uint64(len(raw)),
+			UncompressedSize64: uint64(len(m.data)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// corrupt flips one byte in the middle of the named member's stored data.
+func corrupt(t *testing.T, z []byte, name string) []byte {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(z), int64(len(z)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		off, err := f.DataOffset()
+		if err != nil {
+			t.Fatal(err)
+		}
+		bad := bytes.Clone(z)
+		bad[off+int64(f.CompressedSize64)/2] ^= 0x10
+		return bad
+	}
+	t.Fatalf("no member %q", name)
+	return nil
+}
+
+func TestVerifyZipStream(t *testing.T) {
+	for _, build := range []struct {
+		name string
+		zip  func(t *testing.T) []byte
+	}{
+		{"streamed", streamedZip},
+		{"sized", sizedZip},
+	} {
+		t.Run(build.name, func(t *testing.T) {
+			z := build.zip(t)
+			tests := []struct {
+				name    string
+				data    []byte
+				problem string // substring; "" means fine
+			}{
+				{"intact", z, ""},
+				{"deflated member", corrupt(t, z, "a.sql"), `zip member "a.sql"`},
+				{"stored member", corrupt(t, z, "b.bin"), `zip member "b.bin"`},
+				{"random member", corrupt(t, z, "dir/random.bin"), `zip member "dir/random.bin"`},
+				{"truncated", z[:len(z)/2], "zip member"},
+				{"empty", nil, "zip: empty file"},
+				{"not a zip", []byte("<html>error page</html>"), "zip: not a zip file"},
+			}
+			for _, tc := range tests {
+				t.Run(tc.name, func(t *testing.T) {
+					got := verifyStream(bytes.NewReader(tc.data), "site.zip", nil, "k")
+					if tc.problem == "" && got != "" {
+						t.Fatalf("problem %q on an intact zip", got)
+					}
+					if !strings.Contains(got, tc.problem) {
+						t.Fatalf("problem = %q, want it to mention %q", got, tc.problem)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestVerifyZipStreamEmptyArchive(t *testing.T) {
+	var buf bytes.Buffer
+	if err := zip.NewWriter(&buf).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := verifyStream(bytes.NewReader(buf.Bytes()), "site.zip", nil, "k"); got != "" {
+		t.Fatalf("problem %q on an empty archive", got)
+	}
+}
+
+func TestVerifyWrappedZip(t *testing.T) {
+	key := bytes.Repeat([]byte{9}, encrypt.KeySize)
+	bad := corrupt(t, streamedZip(t), "a.sql")
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(bad)
+	gw.Close()
+	if got := verifyStream(bytes.NewReader(gz.Bytes()), "site.zip.gz", nil, "k"); !strings.Contains(got, `zip member "a.sql"`) {
+		t.Errorf(".zip.gz: problem = %q", got)
+	}
+
+	enc, err := io.ReadAll(encrypt.Encrypt(bytes.NewReader(bad), key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := verifyStream(bytes.NewReader(enc), "site.zip.enc", key, "k"); !strings.Contains(got, `zip member "a.sql"`) {
+		t.Errorf(".zip.enc: problem = %q", got)
+	}
+}
+
+// memBackend serves objects from memory, like a remote backend: no *os.File.
+type memBackend map[string][]byte
+
+func (m memBackend) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	b, err := io.ReadAll(r)
+	m[key] = b
+	return int64(len(b)), err
+}
+func (m memBackend) List(ctx context.Context, prefix string) ([]storage.Object, error) {
+	return nil, nil
+}
+func (m memBackend) Stat(ctx context.Context, key string) (storage.Object, error) {
+	return storage.Object{}, fs.ErrNotExist
+}
+func (m memBackend) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	b, ok := m[key]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+func (m memBackend) Delete(ctx context.Context, key string) error { delete(m, key); return nil }
+func (m memBackend) String() string                               { return "mem" }
+
+func TestVerifyRemoteZip(t *testing.T) {
+	good := sizedZip(t)
+	bad := corrupt(t, good, "a.sql")
+	ix := catalog.Index{Site: "shop", Slug: "shop", Backend: memBackend{"shop/good.zip": good, "shop/bad.zip": bad}}
+
+	// No SHA-256 recorded: only the CRC-32s can tell.
+	for _, tc := range []struct {
+		key, want string
+		size      int
+	}{
+		{"shop/good.zip", "", len(good)},
+		{"shop/bad.zip", `zip member "a.sql"`, len(bad)},
+		{"shop/gone.zip", "file missing", 0},
+	} {
+		e := catalog.Entry{Name: tc.key[len("shop/"):], Key: tc.key, Size: int64(tc.size)}
+		got, err := verify(context.Background(), ix, e, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (tc.want == "") != (got == "") || !strings.Contains(got, tc.want) {
+			t.Errorf("%s: problem = %q, want %q", tc.key, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package scrub
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// throttledReader limits reads to roughly bytesPerSec.
+// A limit <= 0 disables throttling.
+type throttledReader struct {
+	ctx         context.Context
+	r           io.Reader
+	bytesPerSec int64
+
+	start time.Time
+	read  int64
+}
+
+func newThrottledReader(ctx context.Context, r io.Reader, bytesPerSec int64) io.Reader {
+	return &throttledReader{ctx: ctx, r: r, bytesPerSec: bytesPerSec}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if err := t.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if t.bytesPerSec <= 0 {
+		return t.r.Read(p)
+	}
+
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+
+	// Keep single reads small so the pacing stays smooth.
+	if max := t.bytesPerSec / 10; max > 0 && int64(len(p)) > max {
+		p = p[:max]
+	}
+
+	n, err := t.r.Read(p)
+	t.read += int64(n)
+
+	// Sleep until the average rate is back under the limit.
+	want := time.Duration(float64(t.read) / float64(t.bytesPerSec) * float64(time.Second))
+	if wait := want - time.Since(t.start); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-t.ctx.Done():
+			return n, t.ctx.Err()
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,292 @@
+package scrub
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+)
+
+// Zip record signatures (little-endian).
+const (
+	sigLocalHeader    = 0x04034b50
+	sigDataDescriptor = 0x08074b50
+	sigCentralDir     = 0x02014b50
+	sigEndOfCentral   = 0x06054b50
+	sigZip64End       = 0x06064b50
+	sigDigitalSig     = 0x05054b50
+)
+
+const (
+	flagEncrypted      = 0x1
+	flagDataDescriptor = 0x8
+)
+
+// Compression methods that can be checked.
+const (
+	zipStore   = 0
+	zipDeflate = 8
+)
+
+// verifyZipStream walks the local file headers of a zip archive in one
+// forward pass and checks every member's CRC-32 and size, so a zip is checked
+// while it is hashed, whatever the backend and however it is wrapped
+// (.zip.gz, .zip.enc). It stops at the central directory and leaves the rest
+// of r unread. Members it cannot decode (encrypted or compressed with another
+// method than store or deflate) are skipped; when their length is unknown too
+// (a data descriptor), the check ends there without a verdict on the rest.
+// It returns a problem, or "".
+func verifyZipStream(r *bufio.Reader) string {
+	first := true
+	for {
+		var sig [4]byte
+		if _, err := io.ReadFull(r, sig[:]); err != nil {
+			if first && errors.Is(err, io.EOF) {
+				return "zip: empty file"
+			}
+			return "zip: " + unexpected(err).Error()
+		}
+		switch binary.LittleEndian.Uint32(sig[:]) {
+		case sigLocalHeader:
+		case sigCentralDir, sigEndOfCentral, sigZip64End, sigDigitalSig:
+			if first && binary.LittleEndian.Uint32(sig[:]) != sigEndOfCentral {
+				return "zip: no local file header"
+			}
+			return "" // every member checked
+		default:
+			return "zip: not a zip file or corrupt local header"
+		}
+		first = false
+
+		problem, more := verifyZipMember(r)
+		if problem != "" || !more {
+			return problem
+		}
+	}
+}
+
+// verifyZipMember checks the member whose signature has just been read.
+// more is false when the walk has to stop without a verdict.
+func verifyZipMember(r *bufio.Reader) (problem string, more bool) {
+	var h [26]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return "zip: " + unexpected(err).Error(), false
+	}
+	flags := binary.LittleEndian.Uint16(h[2:])
+	method := binary.LittleEndian.Uint16(h[4:])
+	wantCRC := binary.LittleEndian.Uint32(h[10:])
+	csize := uint64(binary.LittleEndian.Uint32(h[14:]))
+	usize := uint64(binary.LittleEndian.Uint32(h[18:]))
+	nameLen := int(binary.LittleEndian.Uint16(h[22:]))
+	extraLen := int(binary.LittleEndian.Uint16(h[24:]))
+
+	nameExtra := make([]byte, nameLen+extraLen)
+	if _, err := io.ReadFull(r, nameExtra); err != nil {
+		return "zip: " + unexpected(err).Error(), false
+	}
+	name := string(nameExtra[:nameLen])
+	zip64 := false
+	if csize == 0xffffffff || usize == 0xffffffff {
+		u, c, ok := zip64Sizes(nameExtra[nameLen:])
+		if !ok {
+			return fmt.Sprintf("zip member %q: missing zip64 sizes", name), false
+		}
+		usize, csize, zip64 = u, c, true
+	}
+	memberErr := func(err error) (string, bool) {
+		return fmt.Sprintf("zip member %q: %v", name, unexpected(err)), false
+	}
+
+	descriptor := flags&flagDataDescriptor != 0
+	decodable := flags&flagEncrypted == 0 && (method == zipStore || method == zipDeflate)
+	if descriptor && decodable && method == zipStore {
+		got, n, err := readStoredUntilDescriptor(r)
+		if err != nil {
+			return memberErr(err)
+		}
+		if wantCRC != 0 && got != wantCRC {
+			// Usually 0: the CRC is in the descriptor that was just matched.
+			return fmt.Sprintf("zip member %q: checksum error (crc32 %08x, want %08x)", name, got, wantCRC), false
+		}
+		if usize != 0 && uint64(n) != usize {
+			return fmt.Sprintf("zip member %q: size %d, want %d", name, n, usize), false
+		}
+		return "", true
+	}
+	if !decodable {
+		if descriptor {
+			// The data has no known length to skip.
+			slog.Debug("scrub: zip member cannot be streamed, CRC check stops", "member", name, "method", method)
+			return "", false
+		}
+		if _, err := io.CopyN(io.Discard, r, int64(csize)); err != nil {
+			return memberErr(err)
+		}
+		return "", true
+	}
+
+	// src counts the compressed bytes; flate reads exactly the deflate
+	// stream from it because it is an io.ByteReader.
+	src := &countingReader{r: r}
+	var data io.Reader = src
+	if !descriptor {
+		data = io.LimitReader(src, int64(csize))
+	}
+	if method == zipDeflate {
+		fr := flate.NewReader(data)
+		defer fr.Close()
+		data = fr
+	}
+
+	crc := crc32.NewIEEE()
+	n, err := io.Copy(crc, data)
+	if err != nil {
+		return memberErr(err)
+	}
+	if !descriptor {
+		// Whatever the deflate stream did not use of the stated size.
+		if rest := int64(csize) - src.n; rest > 0 {
+			if _, err := io.CopyN(io.Discard, src, rest); err != nil {
+				return memberErr(err)
+			}
+		}
+	} else {
+		// Sizes over 4 GiB (or a zip64 header) mean a zip64 descriptor.
+		zip64 = zip64 || src.n > 0xffffffff || n > 0xffffffff
+		wantCRC, usize, err = readDataDescriptor(r, zip64)
+		if err != nil {
+			return memberErr(err)
+		}
+		if !zip64 {
+			n &= 0xffffffff
+		}
+	}
+
+	if got := crc.Sum32(); got != wantCRC {
+		return fmt.Sprintf("zip member %q: checksum error (crc32 %08x, want %08x)", name, got, wantCRC), false
+	}
+	if uint64(n) != usize {
+		return fmt.Sprintf("zip member %q: size %d, want %d", name, n, usize), false
+	}
+	return "", true
+}
+
+// zip64Sizes reads the uncompressed and compressed sizes from the zip64
+// extra field of a local header.
+func zip64Sizes(extra []byte) (usize, csize uint64, ok bool) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		extra = extra[4:]
+		if size > len(extra) {
+			return 0, 0, false
+		}
+		if id == 0x0001 && size >= 16 {
+			return binary.LittleEndian.Uint64(extra), binary.LittleEndian.Uint64(extra[8:]), true
+		}
+		extra = extra[size:]
+	}
+	return 0, 0, false
+}
+
+// readDataDescriptor reads the CRC-32 and uncompressed size that follow a
+// member written with flagDataDescriptor. The signature is optional.
+func readDataDescriptor(r *bufio.Reader, zip64 bool) (crc uint32, usize uint64, err error) {
+	sizeLen := 8
+	if zip64 {
+		sizeLen = 16
+	}
+	b := make([]byte, 4+sizeLen)
+	if _, err := io.ReadFull(r, b[:4]); err != nil {
+		return 0, 0, err
+	}
+	if binary.LittleEndian.Uint32(b) == sigDataDescriptor {
+		if _, err := io.ReadFull(r, b[:4]); err != nil {
+			return 0, 0, err
+		}
+	}
+	if _, err := io.ReadFull(r, b[4:]); err != nil {
+		return 0, 0, err
+	}
+	crc = binary.LittleEndian.Uint32(b)
+	if zip64 {
+		return crc, binary.LittleEndian.Uint64(b[12:]), nil
+	}
+	return crc, uint64(binary.LittleEndian.Uint32(b[8:])), nil
+}
+
+// readStoredUntilDescriptor reads a stored member whose length is only in the
+// data descriptor after it. The data ends at the first descriptor signature
+// followed by the CRC-32 and sizes of the bytes before it, which data cannot
+// fake by accident.
+func readStoredUntilDescriptor(r *bufio.Reader) (crc uint32, n int64, err error) {
+	sig := binary.LittleEndian.AppendUint32(nil, sigDataDescriptor)
+	h := crc32.NewIEEE()
+	consume := func(k int) {
+		b, _ := r.Peek(k)
+		h.Write(b)
+		n += int64(k)
+		_, _ = r.Discard(k)
+	}
+	for {
+		window, err := r.Peek(max(r.Buffered(), 16))
+		if len(window) < 16 {
+			if errors.Is(err, io.EOF) {
+				// Corrupt data never matches its descriptor.
+				return 0, 0, errors.New("checksum error or truncated: no data descriptor matches the data")
+			}
+			return 0, 0, err
+		}
+		i := bytes.Index(window, sig)
+		if i < 0 {
+			consume(len(window) - len(sig) + 1)
+			continue
+		}
+		consume(i)
+
+		sum := h.Sum32()
+		if d, _ := r.Peek(16); len(d) == 16 && binary.LittleEndian.Uint32(d[4:]) == sum &&
+			int64(binary.LittleEndian.Uint32(d[8:])) == n&0xffffffff && int64(binary.LittleEndian.Uint32(d[12:])) == n&0xffffffff && n <= 0xffffffff {
+			_, _ = r.Discard(16)
+			return sum, n, nil
+		}
+		if d, _ := r.Peek(24); len(d) == 24 && binary.LittleEndian.Uint32(d[4:]) == sum &&
+			int64(binary.LittleEndian.Uint64(d[8:])) == n && int64(binary.LittleEndian.Uint64(d[16:])) == n {
+			_, _ = r.Discard(24)
+			return sum, n, nil
+		}
+		consume(1)
+	}
+}
+
+// unexpected turns a clean EOF inside a record into io.ErrUnexpectedEOF.
+func unexpected(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
@@ -0,0 +1,64 @@
+// Package sdnotify implements the client side of the systemd notify protocol
+// (sd_notify(3)) for services with Type=notify: state lines such as
+// "READY=1" are sent as one datagram to the unix socket in $NOTIFY_SOCKET.
+// Without $NOTIFY_SOCKET (not started by systemd) every call is a no-op, so
+// callers never need to check. Any process can play systemd for a test by
+// listening on a unixgram socket and setting $NOTIFY_SOCKET to its path.
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Well-known states.
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// Send sends one or more newline-separated state lines. It returns nil
+// without doing anything when $NOTIFY_SOCKET is not set.
+func Send(state ...string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	// "@name" is a Linux abstract socket.
+	if strings.HasPrefix(addr, "@") {
+		addr = "\x00" + addr[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(strings.Join(state, "\n")))
+	return err
+}
+
+// Status sends a free-form status line, shown by `systemctl status`.
+func Status(msg string) error {
+	// A newline would start a new state line.
+	return Send("STATUS=" + strings.ReplaceAll(msg, "\n", " "))
+}
+
+// WatchdogInterval returns how often WATCHDOG=1 should be sent: half of
+// $WATCHDOG_USEC, as sd_watchdog_enabled(3) recommends. It returns 0 when
+// the watchdog is off or meant for another process ($WATCHDOG_PID).
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
@@ -0,0 +1,92 @@
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// listen plays systemd: it points $NOTIFY_SOCKET at a fresh unixgram socket
+// and returns a function reading the next datagram.
+func listen(t *testing.T) func() string {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unixgram sockets unavailable: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	return func() string {
+		t.Helper()
+		buf := make([]byte, 4096)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("no datagram: %v", err)
+		}
+		return string(buf[:n])
+	}
+}
+
+func TestSendStates(t *testing.T) {
+	next := listen(t)
+
+	tests := []struct {
+		send func() error
+		want string
+	}{
+		{func() error { return Send(Ready) }, "READY=1"},
+		{func() error { return Send(Ready, "STATUS=idle") }, "READY=1\nSTATUS=idle"},
+		{func() error { return Status("backing up\n3 sites") }, "STATUS=backing up 3 sites"},
+		{func() error { return Send(Watchdog) }, "WATCHDOG=1"},
+		{func() error { return Send(Stopping) }, "STOPPING=1"},
+	}
+	for _, tt := range tests {
+		if err := tt.send(); err != nil {
+			t.Fatalf("send %q: %v", tt.want, err)
+		}
+		if got := next(); got != tt.want {
+			t.Errorf("datagram = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestSendWithoutSocketIsNoop(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := Send(Ready); err != nil {
+		t.Errorf("Send without NOTIFY_SOCKET: %v", err)
+	}
+}
+
+func TestSendToMissingSocketFails(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "gone.sock"))
+	if err := Send(Ready); err == nil {
+		t.Error("Send to a missing socket succeeded")
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	self := strconv.Itoa(os.Getpid())
+	tests := []struct {
+		usec, pid string
+		want      time.Duration
+	}{
+		{"", "", 0},
+		{"junk", "", 0},
+		{"0", "", 0},
+		{"20000000", "", 10 * time.Second},
+		{"20000000", self, 10 * time.Second},
+		{"20000000", strconv.Itoa(os.Getpid() + 1), 0}, // meant for another process
+	}
+	for _, tt := range tests {
+		t.Setenv("WATCHDOG_USEC", tt.usec)
+		t.Setenv("WATCHDOG_PID", tt.pid)
+		if got := WatchdogInterval(); got != tt.want {
+			t.Errorf("WATCHDOG_USEC=%q WATCHDOG_PID=%q: interval %v, want %v", tt.usec, tt.pid, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"httpBackupGo/backup"
+	"httpBackupGo/config"
+	"httpBackupGo/mirror"
+	"httpBackupGo/runlog"
+	"httpBackupGo/scrub"
+	"httpBackupGo/sdnotify"
+	"httpBackupGo/sweep"
+	"httpBackupGo/web"
+)
+
+// serveCmd runs the scheduler, scrub, sweep, mirrors and the web UI until
+// SIGINT/SIGTERM, then drains (see shutdown).
+func serveCmd(fs *flag.FlagSet) func(e *env) error {
+	return serve
+}
+
+func serve(e *env) error {
+	cfgPath, cfg := e.cfgPath, e.cfg
+
+	// ---- Start Web UI (addr from config; changes require restart) ----
+	events := make(chan web.Event, 8) // buffered so UI never blocks
+	srv, err := web.NewServer(cfgPath, cfg.WebListenAddr, events, e.recentLogs)
+	if err != nil {
+		return fmt.Errorf("web server: %w", err)
+	}
+	// Bind before reporting ready, so a port in use fails the start.
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return fmt.Errorf("web server: %w", err)
+	}
+	scheme := "http"
+	if srv.TLSConfig != nil {
+		scheme = "https"
+	}
+	slog.Info("web ui listening", "url", scheme+"://"+ln.Addr().String())
+	if cfg.TLS.RedirectHTTPAddr != "" {
+		redirect := web.NewRedirectServer(cfg.TLS.RedirectHTTPAddr, ln.Addr().String())
+		rln, err := net.Listen("tcp", redirect.Addr)
+		if err != nil {
+			ln.Close()
+			return fmt.Errorf("http redirect: %w", err)
+		}
+		slog.Info("redirecting http to https", "addr", rln.Addr().String())
+		go func() {
+			if err := redirect.Serve(rln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("http redirect failed", "err", err)
+			}
+		}()
+		srv.RegisterOnShutdown(func() { redirect.Close() })
+	}
+	go func() {
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ServeTLS(ln, "", "") // certificate comes from TLSConfig
+		} else {
+			err = srv.Serve(ln)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("web server failed", "err", err)
+			e.close()
+			os.Exit(1)
+		}
+	}()
+
+	// ---- Context + signal handling ----
+	// ctx carries backup runs and mirror jobs, which get the drain period on
+	// shutdown; bgCtx carries scrub and sweep, which are cancelled at once
+	// (both simply continue on their next pass).
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bgCtx, cancelBG := context.WithCancel(context.Background())
+	defer cancelBG()
+	var runs, bg sync.WaitGroup
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+
+	// ---- Scheduler (IntervalMinutes==0 disables auto runs) ----
+	intervalMin := normalizeInterval(cfg.IntervalMinutes) // 0 stays 0
+	var ticker *time.Ticker
+	var tickCh <-chan time.Time
+
+	if intervalMin > 0 {
+		ticker = time.NewTicker(time.Duration(intervalMin) * time.Minute)
+		tickCh = ticker.C
+		slog.Info("scheduler started", "interval_minutes", intervalMin)
+	} else {
+		slog.Info("scheduler disabled (IntervalMinutes=0)")
+	}
+
+	// ---- Scrub (ScrubIntervalMinutes==0 disables it) ----
+	scrubMin := 0
+	var scrubTicker *time.Ticker
+	var scrubCh <-chan time.Time
+
+	setScrubInterval := func(newMin int) {
+		if newMin < 0 {
+			newMin = 0
+		}
+		if newMin == scrubMin {
+			return
+		}
+		if scrubTicker != nil {
+			scrubTicker.Stop()
+		}
+		scrubTicker, scrubCh, scrubMin = nil, nil, newMin
+
+		if scrubMin == 0 {
+			slog.Info("scrub scheduler disabled (ScrubIntervalMinutes=0)")
+			return
+		}
+		scrubTicker = time.NewTicker(time.Duration(scrubMin) * time.Minute)
+		scrubCh = scrubTicker.C
+		slog.Info("scrub scheduler started", "interval_minutes", scrubMin)
+	}
+	setScrubInterval(cfg.ScrubIntervalMinutes)
+
+	// ---- Sweep of stale temp files (at startup, then hourly) ----
+	var sweeping atomic.Bool
+	triggerSweep := func() {
+		if !sweeping.CompareAndSwap(false, true) {
+			return
+		}
+
+		bg.Add(1)
+		go func() {
+			defer bg.Done()
+			defer sweeping.Store(false)
+
+			cfgNow, err := config.LoadOrCreate(cfgPath)
+			if err != nil {
+				slog.Error("failed to reload config", "err", err)
+				return
+			}
+
+			if _, err := sweep.Run(bgCtx, cfgNow); err != nil {
+				slog.Warn("sweep failed", "err", err)
+			}
+		}()
+	}
+	triggerSweep()
+	sweepTicker := time.NewTicker(sweepInterval)
+	defer sweepTicker.Stop()
+
+	// ---- Mirrors (async replication of new backups) ----
+	repl := mirror.NewReplicator(2)
+	repl.Start(ctx)
+
+	// Prevent overlapping runs
+	var running atomic.Bool
+	var scrubbing atomic.Bool
+
+	// ---- systemd status (no-op without $NOTIFY_SOCKET) ----
+	var lastRun atomic.Pointer[string]
+	notifyStatus := func() {
+		st := "idle"
+		switch {
+		case running.Load():
+			st = "backup run in progress"
+		case scrubbing.Load():
+			st = "scrub in progress"
+		}
+		if lr := lastRun.Load(); lr != nil {
+			st += "; last run " + *lr
+		}
+		if err := sdnotify.Status(st); err != nil {
+			slog.Debug("sdnotify: status failed", "err", err)
+		}
+	}
+
+	// record adds a run to the history; a failure only costs the history entry.
+	record := func(backupFolder string, run runlog.Run) {
+		if err := runlog.Record(backupFolder, run); err != nil {
+			slog.Warn("run history: record failed", "run", run.ID, "err", err)
+		}
+	}
+	if err := runlog.Recover(cfg.BackupFolder); err != nil {
+		slog.Warn("run history: recover failed", "err", err)
+	}
+
+	// triggerRun starts a run of the given sites (all enabled when empty).
+	// id is set when a caller (the API) already handed out the run ID.
+	triggerRun := func(reason, id string, sites []string) {
+		if !running.CompareAndSwap(false, true) {
+			slog.Warn("run skipped: already running", "reason", reason)
+			if id != "" {
+				run := runlog.Begin(id, reason, sites)
+				run.Status, run.Finished, run.Error = runlog.StatusSkipped, run.Started, "another run is in progress"
+				record(cfg.BackupFolder, run)
+			}
+			return
+		}
+
+		runs.Add(1)
+		notifyStatus()
+		go func() {
+			defer runs.Done()
+			defer notifyStatus()
+			defer running.Store(false)
+
+			run := runlog.Begin(id, reason, sites)
+			cfgNow, err := config.LoadOrCreate(cfgPath)
+			if err != nil {
+				slog.Error("failed to reload config", "err", err)
+				run.Status, run.Finished, run.Error = runlog.StatusFailed, time.Now(), err.Error()
+				record(cfg.BackupFolder, run)
+				return
+			}
+			record(cfgNow.BackupFolder, run)
+
+			results, err := runOnce(ctx, cfgNow, repl, sites)
+			run.Complete(results)
+			if err != nil {
+				run.Status, run.Error = runlog.StatusFailed, err.Error()
+			}
+			record(cfgNow.BackupFolder, run)
+
+			lr := fmt.Sprintf("%s at %s (%d ok, %d failed)", run.Status, run.Started.Format("2006-01-02 15:04"), run.Succeeded, run.Failed)
+			lastRun.Store(&lr)
+		}()
+	}
+
+	triggerScrub := func() {
+		// Backups have priority: skip this tick rather than compete for IO.
+		if running.Load() {
+			slog.Info("scrub skipped: backup run in progress")
+			return
+		}
+		if !scrubbing.CompareAndSwap(false, true) {
+			slog.Warn("scrub skipped: already running")
+			return
+		}
+
+		bg.Add(1)
+		notifyStatus()
+		go func() {
+			defer bg.Done()
+			defer notifyStatus()
+			defer scrubbing.Store(false)
+
+			cfgNow, err := config.LoadOrCreate(cfgPath)
+			if err != nil {
+				slog.Error("failed to reload config", "err", err)
+				return
+			}
+
+			if _, err := scrub.Run(bgCtx, cfgNow); err != nil {
+				slog.Warn("scrub aborted", "err", err)
+			}
+		}()
+	}
+
+	reloadTickerIfNeeded := func() {
+		cfgNow, err := config.LoadOrCreate(cfgPath)
+		if err != nil {
+			slog.Error("failed to reload config", "err", err)
+			return
+		}
+
+		setScrubInterval(cfgNow.ScrubIntervalMinutes)
+
+		newInterval := normalizeInterval(cfgNow.IntervalMinutes)
+
+		// disabled -> enabled
+		if intervalMin == 0 && newInterval > 0 {
+			ticker = time.NewTicker(time.Duration(newInterval) * time.Minute)
+			tickCh = ticker.C
+			intervalMin = newInterval
+			slog.Info("scheduler enabled", "interval_minutes", intervalMin)
+			return
+		}
+
+		// enabled -> disabled
+		if intervalMin > 0 && newInterval == 0 {
+			if ticker != nil {
+				ticker.Stop()
+			}
+			ticker = nil
+			tickCh = nil
+			intervalMin = 0
+			slog.Info("scheduler disabled (IntervalMinutes=0)")
+			return
+		}
+
+		// enabled -> enabled (interval changed)
+		if intervalMin > 0 && newInterval > 0 && newInterval != intervalMin {
+			if ticker != nil {
+				ticker.Stop()
+			}
+			ticker = time.NewTicker(time.Duration(newInterval) * time.Minute)
+			tickCh = ticker.C
+			intervalMin = newInterval
+			slog.Info("scheduler interval updated", "interval_minutes", intervalMin)
+		}
+	}
+
+	// ---- Readiness + watchdog ----
+	// Pinged from the main loop, so systemd restarts a hung scheduler.
+	var watchdogCh <-chan time.Time
+	if iv := sdnotify.WatchdogInterval(); iv > 0 {
+		watchdog := time.NewTicker(iv)
+		defer watchdog.Stop()
+		watchdogCh = watchdog.C
+		slog.Info("systemd watchdog enabled", "interval", iv.String())
+	}
+	if err := sdnotify.Send(sdnotify.Ready, "STATUS=idle"); err != nil {
+		slog.Warn("sdnotify: ready notification failed", "err", err)
+	}
+
+	// ---- Main loop ----
+	for {
+		select {
+		case <-watchdogCh:
+			if err := sdnotify.Send(sdnotify.Watchdog); err != nil {
+				slog.Debug("sdnotify: watchdog ping failed", "err", err)
+			}
+
+		case <-tickCh:
+			triggerRun("ticker", "", nil)
+
+		case <-scrubCh:
+			triggerScrub()
+
+		case <-sweepTicker.C:
+			triggerSweep()
+
+		case ev := <-events:
+			switch ev.Type {
+			case web.EventConfigChanged:
+				slog.Info("event: config changed -> reloading scheduler")
+				reloadTickerIfNeeded()
+
+			case web.EventRunNow:
+				slog.Info("event: run now", "sites", ev.Sites)
+				trigger := "run-now"
+				if ev.RunID != "" {
+					trigger = "api"
+				}
+				triggerRun(trigger, ev.RunID, ev.Sites)
+			}
+
+		case <-sig:
+			drain := time.Duration(cfg.ShutdownDrainSeconds) * time.Second
+			if cfgNow, err := config.LoadOrCreate(cfgPath); err == nil {
+				drain = time.Duration(cfgNow.ShutdownDrainSeconds) * time.Second
+			}
+			slog.Info("shutdown signal received, draining", "drain_seconds", int(drain.Seconds()))
+			_ = sdnotify.Send(sdnotify.Stopping, "STATUS=draining")
+
+			// A second signal skips the drain.
+			go func() {
+				<-sig
+				slog.Warn("second signal received, exiting immediately")
+				e.close()
+				os.Exit(1)
+			}()
+
+			cancelBG()
+			shutdown(drain, srv, &runs, cancel, repl)
+			bg.Wait()
+			slog.Info("shutdown complete")
+			return nil
+
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// shutdown stops the web server and lets running backups and queued mirror
+// jobs finish. Both get the full drain period, side by side, so a slow UI
+// download cannot use up the time of the backup runs. Whatever is still
+// running when the drain period is over is cancelled (downloads leave no
+// partial files; dropped mirror jobs show up in the next mirror-check). No new
+// runs start: the main loop has returned.
+func shutdown(drain time.Duration, srv *http.Server, runs *sync.WaitGroup, cancel context.CancelFunc, repl *mirror.Replicator) {
+	webDone := make(chan struct{})
+	go func() {
+		defer close(webDone)
+		sctx, scancel := context.WithTimeout(context.Background(), drain)
+		defer scancel()
+		if err := srv.Shutdown(sctx); err != nil {
+			slog.Warn("web server shutdown incomplete, closing connections", "err", err)
+			_ = srv.Close()
+		}
+	}()
+
+	dctx, dcancel := context.WithTimeout(context.Background(), drain)
+	defer dcancel()
+
+	if !waitUntil(dctx, runs.Wait) {
+		slog.Warn("drain period over, cancelling running backups")
+		cancel()
+		runs.Wait()
+	}
+
+	// All runs are done, so nothing enqueues anymore.
+	repl.Close()
+	if !waitUntil(dctx, repl.Wait) {
+		if n := repl.Pending(); n > 0 {
+			slog.Warn("drain period over, dropping queued mirror jobs", "queued", n)
+		}
+		cancel()
+		repl.Wait()
+	}
+	cancel()
+	<-webDone
+}
+
+// waitUntil reports whether wait returned before ctx was done.
+func waitUntil(ctx context.Context, wait func()) bool {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// sweepInterval is how often stale temp files are looked for after startup.
+const sweepInterval = time.Hour
+
+// runOnce backs up the named sites, or all enabled sites when names is empty.
+func runOnce(ctx context.Context, cfg config.Config, repl *mirror.Replicator, names []string) ([]backup.Result, error) {
+	if len(names) == 0 {
+		return newRunner(repl).RunAllEnabled(ctx, cfg), nil
+	}
+	sites, err := selectSites(cfg, names)
+	if err != nil {
+		return nil, err
+	}
+	return newRunner(repl).Run(ctx, cfg, sites), nil
+}
+
+// newRunner creates a backup runner handing saved backups to repl.
+// HTTPBACKUP_MAX_PARALLEL overrides the download limit.
+func newRunner(repl *mirror.Replicator) *backup.Runner {
+	maxPar := 5
+	if v := os.Getenv("HTTPBACKUP_MAX_PARALLEL"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			maxPar = n
+		}
+	}
+
+	r := backup.NewRunner(maxPar)
+	r.Mirror = repl
+	return r
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"httpBackupGo/config"
+)
+
+// ForSite returns the backend a site's backups are stored in:
+// the site's own Storage if set, otherwise the global one.
+func ForSite(cfg config.Config, site config.Site) (Backend, error) {
+	st := cfg.Storage
+	if site.Storage != nil {
+		st = *site.Storage
+	}
+	return New(cfg, st)
+}
+
+// New builds a backend from a storage config. The local backend writes
+// under st.Path, or cfg.BackupFolder when that is empty.
+func New(cfg config.Config, st config.Storage) (Backend, error) {
+	switch st.Type {
+	case "", config.StorageLocal:
+		if st.Path != "" {
+			return NewLocal(st.Path), nil
+		}
+		return NewLocal(cfg.BackupFolder), nil
+
+	case config.StorageS3:
+		if st.S3 == nil {
+			return nil, errors.New("storage type s3 requires an S3 section")
+		}
+		return NewS3(*st.S3)
+
+	case config.StorageWebDAV:
+		if st.WebDAV == nil {
+			return nil, errors.New("storage type webdav requires a WebDAV section")
+		}
+		return NewWebDAV(*st.WebDAV)
+
+	case config.StorageSFTP:
+		if st.SFTP == nil {
+			return nil, errors.New("storage type sftp requires an SFTP section")
+		}
+		return NewSFTP(*st.SFTP)
+
+	default:
+		return nil, fmt.Errorf("unknown storage type %q", st.Type)
+	}
+}
@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TempSuffix marks in-progress writes of the local backend.
+const TempSuffix = ".tmp"
+
+// Local stores backups in a directory tree on the local filesystem:
+//
+//	<Root>/<site>/backup_<site>_<ts>.zip
+type Local struct {
+	Root string
+}
+
+func NewLocal(root string) *Local {
+	return &Local{Root: filepath.Clean(root)}
+}
+
+func (l *Local) String() string { return "local:" + l.Root }
+
+// Put writes to "<path>.tmp" first, syncs, then renames into place.
+func (l *Local) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	outPath, err := l.resolve(key)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
+		return 0, fmt.Errorf("mkdir %q: %w", filepath.Dir(outPath), err)
+	}
+
+	tmpPath := outPath + TempSuffix
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, fmt.Errorf("create %q: %w", tmpPath, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	written, err := io.Copy(f, ctxReader{ctx: ctx, r: r})
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmpPath)
+		return written, fmt.Errorf("write file: %w", err)
+	}
+
+	// Ensure data flushed
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmpPath)
+		return written, fmt.Errorf("sync file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return written, fmt.Errorf("close file: %w", err)
+	}
+
+	// Replace tmp with final
+	if err := os.Rename(tmpPath, outPath); err != nil {
+		_ = os.Remove(tmpPath)
+		return written, fmt.Errorf("rename to final: %w", err)
+	}
+	return written, nil
+}
+
+// List walks the directory that contains prefix. Temp files and hidden
+// entries (such as the .httpbackupgo state folder) are skipped.
+func (l *Local) List(ctx context.Context, prefix string) ([]Object, error) {
+	dir := l.Root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		p, err := l.resolve(prefix[:i])
+		if err != nil {
+			return nil, err
+		}
+		dir = p
+	}
+
+	var out []Object
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(d.Name(), ".") && path != dir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || strings.HasSuffix(d.Name(), TempSuffix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(l.Root, path)
+		if err != nil {
+			return nil
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil // vanished between readdir and stat
+		}
+		out = append(out, Object{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list %q: %w", dir, err)
+	}
+	return out, nil
+}
+
+func (l *Local) Stat(_ context.Context, key string) (Object, error) {
+	p, err := l.resolve(key)
+	if err != nil {
+		return Object{}, err
+	}
+
+	info, err := os.Stat(p)
+	if err != nil {
+		return Object{}, err
+	}
+	return Object{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// Open returns an *os.File, so callers may use io.ReaderAt / io.Seeker on it.
+func (l *Local) Open(_ context.Context, key string) (io.ReadCloser, error) {
+	p, err := l.resolve(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+// Rename moves a stored object to another key, creating its directory.
+// Used to relocate backups without copying them.
+func (l *Local) Rename(_ context.Context, from, to string) error {
+	src, err := l.resolve(from)
+	if err != nil {
+		return err
+	}
+	dst, err := l.resolve(to)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return fmt.Errorf("mkdir %q: %w", filepath.Dir(dst), err)
+	}
+	return os.Rename(src, dst)
+}
+
+func (l *Local) Delete(_ context.Context, key string) error {
+	p, err := l.resolve(key)
+	if err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
+
+// resolve maps a slash-separated key to a path under Root and refuses keys
+// that would escape it.
+func (l *Local) resolve(key string) (string, error) {
+	p := filepath.Join(l.Root, filepath.FromSlash(key))
+
+	rel, err := filepath.Rel(l.Root, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("key %q escapes storage root", key)
+	}
+	return p, nil
+}
+
+// ctxReader stops a long copy as soon as ctx is cancelled.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
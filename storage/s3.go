@@ -0,0 +1,412 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"httpBackupGo/config"
+)
+
+// S3 stores backups in an S3-compatible bucket using plain HTTP + SigV4,
+// so no SDK is needed. Large streams are sent as multipart uploads; S3 only
+// makes an object visible once the upload completes, which makes Put atomic.
+type S3 struct {
+	cfg      config.S3Config
+	endpoint *url.URL
+	access   string
+	secret   string
+	partSize int64
+	client   *http.Client
+}
+
+func NewS3(cfg config.S3Config) (*S3, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3: bucket is empty")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("s3: invalid endpoint %q", cfg.Endpoint)
+	}
+
+	access, secret := cfg.AccessKey, cfg.SecretKey
+	if access == "" {
+		access = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if secret == "" {
+		secret = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if access == "" || secret == "" {
+		return nil, errors.New("s3: missing credentials (AccessKey/SecretKey or AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY)")
+	}
+
+	return &S3{
+		cfg:      cfg,
+		endpoint: u,
+		access:   access,
+		secret:   secret,
+		partSize: int64(cfg.PartSizeMB) * 1024 * 1024,
+		// No overall timeout: parts can take a while on slow links.
+		// Every request carries the caller's context instead.
+		client: &http.Client{},
+	}, nil
+}
+
+func (s *S3) String() string {
+	return "s3:" + s.cfg.Bucket + "/" + s.cfg.Prefix
+}
+
+// Put uploads r to key. Streams that fit in one part use a single PUT.
+func (s *S3) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	buf := make([]byte, s.partSize)
+
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		if err := s.putObject(ctx, key, buf[:n]); err != nil {
+			return 0, err
+		}
+		return int64(n), nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("read: %w", err)
+	}
+
+	return s.putMultipart(ctx, key, r, buf)
+}
+
+func (s *S3) putObject(ctx context.Context, key string, body []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, key, nil, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// putMultipart uploads first (already read, full) and then the rest of r in
+// partSize chunks. Any failure aborts the upload so no parts linger in the bucket.
+func (s *S3) putMultipart(ctx context.Context, key string, r io.Reader, first []byte) (int64, error) {
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return 0, fmt.Errorf("create multipart upload: %w", err)
+	}
+	var created struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = decodeXML(resp, &created)
+	if err != nil {
+		return 0, fmt.Errorf("create multipart upload: %w", err)
+	}
+
+	uploadID := created.UploadID
+	abort := func() {
+		// Use a fresh context: the caller's may be the reason we are aborting.
+		actx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if resp, err := s.do(actx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil); err == nil {
+			resp.Body.Close()
+		}
+	}
+
+	type part struct {
+		Number int    `xml:"PartNumber"`
+		ETag   string `xml:"ETag"`
+	}
+	var parts []part
+	var written int64
+
+	chunk := first
+	for {
+		num := len(parts) + 1
+		resp, err := s.do(ctx, http.MethodPut, key, url.Values{
+			"partNumber": {strconv.Itoa(num)},
+			"uploadId":   {uploadID},
+		}, chunk)
+		if err != nil {
+			abort()
+			return written, fmt.Errorf("upload part %d: %w", num, err)
+		}
+		resp.Body.Close()
+
+		parts = append(parts, part{Number: num, ETag: resp.Header.Get("ETag")})
+		written += int64(len(chunk))
+
+		n, err := io.ReadFull(r, first[:cap(first)])
+		if n == 0 && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			abort()
+			return written, fmt.Errorf("read: %w", err)
+		}
+		chunk = first[:n]
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		abort()
+		return written, err
+	}
+
+	resp, err = s.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, body)
+	if err != nil {
+		abort()
+		return written, fmt.Errorf("complete multipart upload: %w", err)
+	}
+	// CompleteMultipartUpload can return 200 with an <Error> body.
+	var done struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := decodeXML(resp, &done); err != nil {
+		abort()
+		return written, fmt.Errorf("complete multipart upload: %w", err)
+	}
+	if done.XMLName.Local == "Error" {
+		abort()
+		return written, fmt.Errorf("complete multipart upload: %s: %s", done.Code, done.Message)
+	}
+
+	return written, nil
+}
+
+// List pages through ListObjectsV2.
+func (s *S3) List(ctx context.Context, prefix string) ([]Object, error) {
+	var out []Object
+	token := ""
+
+	for {
+		q := url.Values{
+			"list-type": {"2"},
+			"prefix":    {s.cfg.Prefix + prefix},
+		}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(ctx, http.MethodGet, "", q, nil)
+		if err != nil {
+			return nil, fmt.Errorf("list %q: %w", prefix, err)
+		}
+
+		var page struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if err := decodeXML(resp, &page); err != nil {
+			return nil, fmt.Errorf("list %q: %w", prefix, err)
+		}
+
+		for _, c := range page.Contents {
+			out = append(out, Object{
+				Key:     strings.TrimPrefix(c.Key, s.cfg.Prefix),
+				Size:    c.Size,
+				ModTime: c.LastModified,
+			})
+		}
+
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return out, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (Object, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil)
+	if err != nil {
+		return Object{}, err
+	}
+	resp.Body.Close()
+
+	mod, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return Object{Key: key, Size: resp.ContentLength, ModTime: mod}, nil
+}
+
+func (s *S3) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends a signed request and turns non-2xx responses into errors.
+// A 404 becomes ErrNotExist. An empty key addresses the bucket itself.
+func (s *S3) do(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Response, error) {
+	u := *s.endpoint
+	objPath := ""
+	if key != "" {
+		objPath = "/" + s.cfg.Prefix + key
+	}
+	if s.cfg.PathStyle {
+		u.Path = "/" + s.cfg.Bucket + objPath
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.Path = objPath
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	req.ContentLength = int64(len(body))
+	req.Header.Set("User-Agent", "httpBackupGo/1.0")
+	s.sign(req, body, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3 %s: %w", method, err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %q: %w", method, key, ErrNotExist)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("s3 %s %q: http status %d: %s", method, key, resp.StatusCode, strings.TrimSpace(string(snippet)))
+	}
+	return resp, nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header.
+// See https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html
+func (s *S3) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		strings.Join(signed, ";"),
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.cfg.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.secret), day)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.access, scope, strings.Join(signed, ";"), signature,
+	))
+}
+
+// canonicalQuery encodes query parameters sorted by key, as SigV4 requires.
+func canonicalQuery(q url.Values) string {
+	if len(q) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		vals := append([]string(nil), q[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything except RFC 3986 unreserved characters
+// (and '/' unless encodeSlash is set).
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func decodeXML(resp *http.Response, v any) error {
+	defer resp.Body.Close()
+	if err := xml.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
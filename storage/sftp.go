@@ -0,0 +1,277 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"httpBackupGo/config"
+)
+
+// SFTP stores backups on an SFTP server.
+// Put uploads to "<key>.tmp" and renames it into place when complete.
+//
+// Every operation opens its own SSH connection: backends are short-lived
+// (built per run / per request) and this keeps idle connections from piling up.
+type SFTP struct {
+	addr   string
+	root   string
+	client *ssh.ClientConfig
+}
+
+func NewSFTP(cfg config.SFTPConfig) (*SFTP, error) {
+	if cfg.Host == "" {
+		return nil, errors.New("sftp: host is empty")
+	}
+	if cfg.Username == "" {
+		return nil, errors.New("sftp: username is empty")
+	}
+	if cfg.KeyFile == "" {
+		return nil, errors.New("sftp: key file is empty")
+	}
+
+	addr := cfg.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+
+	keyPEM, err := os.ReadFile(cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("sftp: read key: %w", err)
+	}
+
+	passphrase := cfg.KeyPassphrase
+	if passphrase == "" {
+		passphrase = os.Getenv("HTTPBACKUP_SFTP_PASSPHRASE")
+	}
+
+	var signer ssh.Signer
+	if passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(keyPEM, []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(keyPEM)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sftp: parse key %q: %w", cfg.KeyFile, err)
+	}
+
+	khPath := cfg.KnownHostsFile
+	if khPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("sftp: no KnownHostsFile and no home directory: %w", err)
+		}
+		khPath = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeys, err := knownhosts.New(khPath)
+	if err != nil {
+		return nil, fmt.Errorf("sftp: known_hosts %q: %w", khPath, err)
+	}
+
+	root := cfg.Path
+	if root == "" {
+		root = "."
+	}
+
+	return &SFTP{
+		addr: addr,
+		root: root,
+		client: &ssh.ClientConfig{
+			User:            cfg.Username,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeys,
+			Timeout:         30 * time.Second,
+		},
+	}, nil
+}
+
+func (s *SFTP) String() string {
+	return "sftp:" + s.client.User + "@" + s.addr + ":" + s.root
+}
+
+// connect dials SSH (honouring ctx) and starts the sftp subsystem.
+// The returned func closes both.
+func (s *SFTP) connect(ctx context.Context) (*sftp.Client, func(), error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("sftp dial %s: %w", s.addr, err)
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, s.addr, s.client)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("sftp handshake %s: %w", s.addr, err)
+	}
+	sshClient := ssh.NewClient(c, chans, reqs)
+
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, nil, fmt.Errorf("sftp subsystem: %w", err)
+	}
+
+	// Tear the connection down if ctx is cancelled mid-transfer.
+	stop := context.AfterFunc(ctx, func() { sshClient.Close() })
+
+	return client, func() {
+		stop()
+		client.Close()
+		sshClient.Close()
+	}, nil
+}
+
+func (s *SFTP) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	client, done, err := s.connect(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+
+	outPath := s.path(key)
+	if err := client.MkdirAll(path.Dir(outPath)); err != nil {
+		return 0, fmt.Errorf("mkdir %q: %w", path.Dir(outPath), err)
+	}
+
+	tmpPath := outPath + TempSuffix
+	f, err := client.Create(tmpPath)
+	if err != nil {
+		return 0, fmt.Errorf("create %q: %w", tmpPath, err)
+	}
+
+	written, err := io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		_ = client.Remove(tmpPath)
+		return written, fmt.Errorf("write file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		_ = client.Remove(tmpPath)
+		return written, fmt.Errorf("close file: %w", err)
+	}
+
+	// Prefer the atomic OpenSSH extension; plain SFTP rename fails if the
+	// target exists, which never happens for fresh timestamped names.
+	if err := client.PosixRename(tmpPath, outPath); err != nil {
+		if err := client.Rename(tmpPath, outPath); err != nil {
+			_ = client.Remove(tmpPath)
+			return written, fmt.Errorf("rename to final: %w", err)
+		}
+	}
+	return written, nil
+}
+
+func (s *SFTP) List(ctx context.Context, prefix string) ([]Object, error) {
+	client, done, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	dir := s.root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = s.path(prefix[:i])
+	}
+
+	var out []Object
+	w := client.Walk(dir)
+	for w.Step() {
+		if err := w.Err(); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("list %q: %w", dir, err)
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		info := w.Stat()
+		name := info.Name()
+		if strings.HasPrefix(name, ".") && w.Path() != dir {
+			if info.IsDir() {
+				w.SkipDir()
+			}
+			continue
+		}
+		if info.IsDir() || strings.HasSuffix(name, TempSuffix) {
+			continue
+		}
+
+		key := strings.TrimPrefix(strings.TrimPrefix(w.Path(), s.root), "/")
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		out = append(out, Object{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+	}
+	return out, nil
+}
+
+func (s *SFTP) Stat(ctx context.Context, key string) (Object, error) {
+	client, done, err := s.connect(ctx)
+	if err != nil {
+		return Object{}, err
+	}
+	defer done()
+
+	info, err := client.Stat(s.path(key))
+	if err != nil {
+		return Object{}, fmt.Errorf("stat %q: %w", key, err)
+	}
+	return Object{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// Open keeps the connection alive until the returned reader is closed.
+func (s *SFTP) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	client, done, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := client.Open(s.path(key))
+	if err != nil {
+		done()
+		return nil, fmt.Errorf("open %q: %w", key, err)
+	}
+	return &sftpReader{File: f, done: done}, nil
+}
+
+func (s *SFTP) Delete(ctx context.Context, key string) error {
+	client, done, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	if err := client.Remove(s.path(key)); err != nil {
+		return fmt.Errorf("remove %q: %w", key, err)
+	}
+	return nil
+}
+
+func (s *SFTP) path(key string) string {
+	return path.Join(s.root, key)
+}
+
+type sftpReader struct {
+	*sftp.File
+	done func()
+}
+
+func (r *sftpReader) Close() error {
+	err := r.File.Close()
+	r.done()
+	return err
+}
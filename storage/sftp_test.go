@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"httpBackupGo/config"
+)
+
+func TestSFTPPutListDelete(t *testing.T) {
+	srv := newSSHServer(t)
+	s := srv.backend(t, srv.hostKey.PublicKey())
+	ctx := context.Background()
+
+	data := bytes.Repeat([]byte("backup"), 1000)
+	n, err := s.Put(ctx, "site/backup_site_01-02-2026_03-04-05.zip", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if n != int64(len(data)) {
+		t.Errorf("Put wrote %d bytes, want %d", n, len(data))
+	}
+	onDisk, err := os.ReadFile(filepath.Join(srv.root, "site", "backup_site_01-02-2026_03-04-05.zip"))
+	if err != nil || !bytes.Equal(onDisk, data) {
+		t.Fatalf("stored file differs from the upload (err %v)", err)
+	}
+
+	// Leftover temp files and hidden folders are not backups.
+	os.WriteFile(filepath.Join(srv.root, "site", "partial.zip"+TempSuffix), []byte("x"), 0o644)
+	os.MkdirAll(filepath.Join(srv.root, "site", ".hidden"), 0o755)
+	os.WriteFile(filepath.Join(srv.root, "site", ".hidden", "x.zip"), []byte("x"), 0o644)
+
+	objs, err := s.List(ctx, "site/")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(objs) != 1 || objs[0].Key != "site/backup_site_01-02-2026_03-04-05.zip" || objs[0].Size != int64(len(data)) {
+		t.Errorf("List = %+v", objs)
+	}
+
+	rc, err := s.Open(ctx, objs[0].Key)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+	if !bytes.Equal(got, data) {
+		t.Errorf("Open returned %d bytes, want the upload", len(got))
+	}
+
+	if err := s.Delete(ctx, objs[0].Key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Stat(ctx, objs[0].Key); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Stat after Delete: %v, want ErrNotExist", err)
+	}
+	if objs, err := s.List(ctx, "site/"); err != nil || len(objs) != 0 {
+		t.Errorf("List after Delete = %+v, %v", objs, err)
+	}
+}
+
+func TestSFTPRejectsUnknownHostKey(t *testing.T) {
+	srv := newSSHServer(t)
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	otherKey, err := ssh.NewSignerFromKey(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := srv.backend(t, otherKey.PublicKey())
+
+	_, err = s.Put(context.Background(), "site/backup.zip", strings.NewReader("data"))
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) || len(keyErr.Want) == 0 {
+		t.Fatalf("Put with a mismatching host key: %v, want a knownhosts.KeyError", err)
+	}
+	if entries, _ := os.ReadDir(srv.root); len(entries) != 0 {
+		t.Errorf("files written despite the host key mismatch: %v", entries)
+	}
+	if srv.sessions() != 0 {
+		t.Errorf("%d sftp sessions started, want 0", srv.sessions())
+	}
+}
+
+// sshServer is an in-process SSH server with the sftp subsystem, serving the
+// local filesystem. It accepts a single client key.
+type sshServer struct {
+	addr    string
+	root    string
+	hostKey ssh.Signer
+	keyFile string // the accepted client key, OpenSSH PEM
+
+	mu     sync.Mutex
+	opened int
+}
+
+func newSSHServer(t *testing.T) *sshServer {
+	dir := t.TempDir()
+	srv := &sshServer{root: filepath.Join(dir, "root"), keyFile: filepath.Join(dir, "id_ed25519")}
+	if err := os.Mkdir(srv.root, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	_, hostPriv, _ := ed25519.GenerateKey(rand.Reader)
+	hostKey, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.hostKey = hostKey
+
+	clientPub, clientPriv, _ := ed25519.GenerateKey(rand.Reader)
+	block, err := ssh.MarshalPrivateKey(clientPriv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(srv.keyFile, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	allowed, err := ssh.NewPublicKey(clientPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), allowed.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	cfg.AddHostKey(hostKey)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	srv.addr = ln.Addr().String()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serveConn(conn, cfg)
+		}
+	}()
+	return srv
+}
+
+func (srv *sshServer) serveConn(conn net.Conn, cfg *ssh.ServerConfig) {
+	defer conn.Close()
+	sc, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+	defer sc.Close()
+	go ssh.DiscardRequests(reqs)
+
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "session only")
+			continue
+		}
+		ch, requests, err := nc.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer ch.Close()
+			for req := range requests {
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				srv.mu.Lock()
+				srv.opened++
+				srv.mu.Unlock()
+				s, err := sftp.NewServer(ch)
+				if err != nil {
+					return
+				}
+				s.Serve()
+				s.Close()
+				return
+			}
+		}()
+	}
+}
+
+func (srv *sshServer) sessions() int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.opened
+}
+
+// backend returns an SFTP backend whose known_hosts file lists known as the
+// server's host key.
+func (srv *sshServer) backend(t *testing.T, known ssh.PublicKey) *SFTP {
+	kh := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(srv.addr)}, known)
+	if err := os.WriteFile(kh, []byte(line+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewSFTP(config.SFTPConfig{
+		Host:           srv.addr,
+		Username:       "backup",
+		Path:           srv.root,
+		KeyFile:        srv.keyFile,
+		KnownHostsFile: kh,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// ErrNotExist is returned (wrapped) when a key does not exist.
+// It is fs.ErrNotExist, so errors.Is(err, fs.ErrNotExist) and os.IsNotExist-style
+// checks keep working for every backend.
+var ErrNotExist = fs.ErrNotExist
+
+// Object describes one stored backup.
+// Keys always use forward slashes: <site>/backup_<site>_<ts>.zip
+type Object struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Backend is a place backups can be written to.
+//
+// Implementations must make Put atomic: until Put returns successfully,
+// List/Stat/Open must not see the object under its final key.
+type Backend interface {
+	// Put streams r to key and returns the number of bytes written.
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+
+	// List returns all committed objects whose key starts with prefix,
+	// including those in nested "directories".
+	List(ctx context.Context, prefix string) ([]Object, error)
+
+	Stat(ctx context.Context, key string) (Object, error)
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+
+	// String describes the backend for logs, e.g. "local:/srv/backups".
+	String() string
+}
+
+// CheckKey rejects keys that could resolve outside a backend's root:
+// absolute paths, backslashes and empty, "." or ".." segments.
+func CheckKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, `\`) {
+		return fmt.Errorf("key %q must be a relative path with forward slashes", key)
+	}
+	for _, seg := range strings.Split(key, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return fmt.Errorf("key %q has an empty, . or .. path segment", key)
+		}
+	}
+	return nil
+}
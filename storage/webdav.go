@@ -0,0 +1,338 @@
+package storage
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"httpBackupGo/config"
+)
+
+// WebDAV stores backups in a WebDAV collection.
+// Put uploads to "<key>.tmp" and then MOVEs it into place, so a backup only
+// appears under its final name once it is complete.
+type WebDAV struct {
+	base     *url.URL
+	username string
+	password string
+	client   *http.Client
+}
+
+func NewWebDAV(cfg config.WebDAVConfig) (*WebDAV, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("webdav: invalid URL %q", cfg.URL)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	u.RawPath = ""
+
+	password := cfg.Password
+	if password == "" {
+		password = os.Getenv("HTTPBACKUP_WEBDAV_PASSWORD")
+	}
+
+	return &WebDAV{
+		base:     u,
+		username: cfg.Username,
+		password: password,
+		// No overall timeout: uploads can be long. Requests carry a context.
+		client: &http.Client{},
+	}, nil
+}
+
+func (w *WebDAV) String() string {
+	u := *w.base
+	u.User = nil
+	return "webdav:" + u.String()
+}
+
+func (w *WebDAV) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	if err := w.mkcolAll(ctx, path.Dir(key)); err != nil {
+		return 0, err
+	}
+
+	tmpKey := key + TempSuffix
+	cr := &countingReader{r: r}
+
+	resp, err := w.do(ctx, http.MethodPut, tmpKey, cr, nil)
+	if err != nil {
+		w.cleanup(tmpKey)
+		return cr.n, fmt.Errorf("put %q: %w", tmpKey, err)
+	}
+	resp.Body.Close()
+
+	resp, err = w.do(ctx, "MOVE", tmpKey, nil, http.Header{
+		"Destination": {w.url(key).String()},
+		"Overwrite":   {"T"},
+	})
+	if err != nil {
+		w.cleanup(tmpKey)
+		return cr.n, fmt.Errorf("move to final: %w", err)
+	}
+	resp.Body.Close()
+
+	return cr.n, nil
+}
+
+// cleanup removes a failed temp upload without using the (possibly cancelled)
+// caller context.
+func (w *WebDAV) cleanup(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_ = w.Delete(ctx, key)
+}
+
+// mkcolAll creates the base collection, dir and everything in between,
+// like os.MkdirAll. Collections above the base URL must already exist.
+func (w *WebDAV) mkcolAll(ctx context.Context, dir string) error {
+	cols := []string{""}
+	if dir != "." && dir != "" {
+		cur := ""
+		for _, seg := range strings.Split(dir, "/") {
+			cur = path.Join(cur, seg)
+			cols = append(cols, cur+"/")
+		}
+	}
+
+	for _, cur := range cols {
+		resp, err := w.do(ctx, "MKCOL", cur, nil, nil)
+		if err != nil {
+			// 405 Method Not Allowed: the collection already exists.
+			var se *statusError
+			if errors.As(err, &se) && se.code == http.StatusMethodNotAllowed {
+				continue
+			}
+			return fmt.Errorf("mkcol %q: %w", cur, err)
+		}
+		resp.Body.Close()
+	}
+	return nil
+}
+
+// List walks collections with PROPFIND Depth: 1 (many servers refuse Depth: infinity).
+func (w *WebDAV) List(ctx context.Context, prefix string) ([]Object, error) {
+	dir := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = prefix[:i+1]
+	}
+
+	var out []Object
+	pending := []string{dir}
+	for len(pending) > 0 {
+		cur := pending[0]
+		pending = pending[1:]
+
+		entries, err := w.propfind(ctx, cur, "1")
+		if err != nil {
+			if errors.Is(err, ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("list %q: %w", cur, err)
+		}
+
+		for _, e := range entries {
+			if e.Key == strings.TrimSuffix(cur, "/") {
+				continue // the collection itself
+			}
+
+			name := path.Base(e.Key)
+			if strings.HasPrefix(name, ".") {
+				continue
+			}
+			if e.dir {
+				if strings.HasPrefix(e.Key+"/", prefix) || strings.HasPrefix(prefix, e.Key+"/") {
+					pending = append(pending, e.Key+"/")
+				}
+				continue
+			}
+			if strings.HasSuffix(name, TempSuffix) || !strings.HasPrefix(e.Key, prefix) {
+				continue
+			}
+			out = append(out, e.Object)
+		}
+	}
+	return out, nil
+}
+
+func (w *WebDAV) Stat(ctx context.Context, key string) (Object, error) {
+	entries, err := w.propfind(ctx, key, "0")
+	if err != nil {
+		return Object{}, err
+	}
+	for _, e := range entries {
+		if !e.dir {
+			e.Key = key
+			return e.Object, nil
+		}
+	}
+	return Object{}, fmt.Errorf("stat %q: %w", key, ErrNotExist)
+}
+
+func (w *WebDAV) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := w.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (w *WebDAV) Delete(ctx context.Context, key string) error {
+	resp, err := w.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+type davEntry struct {
+	Object
+	dir bool
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:">
+  <d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/></d:prop>
+</d:propfind>`
+
+func (w *WebDAV) propfind(ctx context.Context, key, depth string) ([]davEntry, error) {
+	resp, err := w.do(ctx, "PROPFIND", key, strings.NewReader(propfindBody), http.Header{
+		"Depth":        {depth},
+		"Content-Type": {"application/xml; charset=utf-8"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var ms struct {
+		Responses []struct {
+			Href     string `xml:"DAV: href"`
+			Propstat []struct {
+				Status string `xml:"DAV: status"`
+				Prop   struct {
+					ResourceType struct {
+						Collection *struct{} `xml:"DAV: collection"`
+					} `xml:"DAV: resourcetype"`
+					ContentLength string `xml:"DAV: getcontentlength"`
+					LastModified  string `xml:"DAV: getlastmodified"`
+				} `xml:"DAV: prop"`
+			} `xml:"DAV: propstat"`
+		} `xml:"DAV: response"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("decode multistatus: %w", err)
+	}
+
+	var out []davEntry
+	for _, r := range ms.Responses {
+		key, ok := w.keyFromHref(r.Href)
+		if !ok {
+			continue
+		}
+
+		e := davEntry{Object: Object{Key: key}}
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200") {
+				continue
+			}
+			if ps.Prop.ResourceType.Collection != nil {
+				e.dir = true
+			}
+			if n, err := strconv.ParseInt(strings.TrimSpace(ps.Prop.ContentLength), 10, 64); err == nil {
+				e.Size = n
+			}
+			if t, err := http.ParseTime(strings.TrimSpace(ps.Prop.LastModified)); err == nil {
+				e.ModTime = t
+			}
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+// keyFromHref turns a multistatus href (absolute path or URL) into a key
+// relative to the base collection.
+func (w *WebDAV) keyFromHref(href string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return "", false
+	}
+
+	p := u.Path
+	if !strings.HasPrefix(p, w.base.Path) {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(p, w.base.Path), "/"), true
+}
+
+func (w *WebDAV) url(key string) *url.URL {
+	u := *w.base
+	u.Path = w.base.Path + key
+	return &u
+}
+
+type statusError struct {
+	method string
+	key    string
+	code   int
+	body   string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("webdav %s %q: http status %d: %s", e.method, e.key, e.code, e.body)
+}
+
+// do sends an authenticated request and turns non-2xx responses into errors.
+// A 404 becomes ErrNotExist.
+func (w *WebDAV) do(ctx context.Context, method, key string, body io.Reader, h http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, w.url(key).String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	for k, v := range h {
+		req.Header[k] = v
+	}
+	req.Header.Set("User-Agent", "httpBackupGo/1.0")
+	if w.username != "" || w.password != "" {
+		req.SetBasicAuth(w.username, w.password)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("webdav %s: %w", method, err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, fmt.Errorf("webdav %s %q: %w", method, key, ErrNotExist)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, &statusError{method: method, key: key, code: resp.StatusCode, body: strings.TrimSpace(string(snippet))}
+	}
+	return resp, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
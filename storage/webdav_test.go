@@ -0,0 +1,332 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"httpBackupGo/config"
+)
+
+func TestWebDAVPutListStatOpenDelete(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeDAV(t)
+	w := fake.client(t, "dav-pass")
+
+	objects := map[string][]byte{
+		"my shop/2026/backup_1.zip": bytes.Repeat([]byte("a"), 3000),
+		"my shop/backup_0.zip":      []byte("zero"),
+		"blog/backup.zip":           []byte("blog"),
+	}
+	for key, data := range objects {
+		n, err := w.Put(ctx, key, bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Put %q: %v", key, err)
+		}
+		if n != int64(len(data)) {
+			t.Errorf("Put %q wrote %d bytes, want %d", key, n, len(data))
+		}
+	}
+	if tmp := fake.keysWithSuffix(TempSuffix); len(tmp) != 0 {
+		t.Errorf("temp uploads left behind: %q", tmp)
+	}
+
+	// Leftovers that List must not report.
+	fake.mu.Lock()
+	fake.files["my shop/backup_2.zip"+TempSuffix] = []byte("partial")
+	fake.files["my shop/.DS_Store"] = []byte("x")
+	fake.mu.Unlock()
+
+	for _, tc := range []struct {
+		prefix string
+		want   []string
+	}{
+		{"my shop/", []string{"my shop/2026/backup_1.zip", "my shop/backup_0.zip"}},
+		{"my shop/backup_", []string{"my shop/backup_0.zip"}},
+		{"", []string{"blog/backup.zip", "my shop/2026/backup_1.zip", "my shop/backup_0.zip"}},
+		{"gone/", nil},
+	} {
+		objs, err := w.List(ctx, tc.prefix)
+		if err != nil {
+			t.Fatalf("List %q: %v", tc.prefix, err)
+		}
+		var got []string
+		for _, o := range objs {
+			got = append(got, o.Key)
+			if o.Size != int64(len(objects[o.Key])) {
+				t.Errorf("List %q: %s has size %d, want %d", tc.prefix, o.Key, o.Size, len(objects[o.Key]))
+			}
+			if o.ModTime.IsZero() {
+				t.Errorf("List %q: %s has no ModTime", tc.prefix, o.Key)
+			}
+		}
+		sort.Strings(got)
+		if strings.Join(got, "|") != strings.Join(tc.want, "|") {
+			t.Errorf("List %q = %q, want %q", tc.prefix, got, tc.want)
+		}
+	}
+
+	key := "my shop/2026/backup_1.zip"
+	o, err := w.Stat(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Key != key || o.Size != int64(len(objects[key])) {
+		t.Errorf("Stat = %+v", o)
+	}
+	if _, err := w.Stat(ctx, "my shop/none.zip"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Stat of a missing key: err = %v, want ErrNotExist", err)
+	}
+	if _, err := w.Stat(ctx, "my shop/2026"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Stat of a collection: err = %v, want ErrNotExist", err)
+	}
+
+	rc, err := w.Open(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil || !bytes.Equal(got, objects[key]) {
+		t.Errorf("Open read %d bytes (%v), want %d", len(got), err, len(objects[key]))
+	}
+	if _, err := w.Open(ctx, "my shop/none.zip"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Open of a missing key: err = %v, want ErrNotExist", err)
+	}
+
+	if err := w.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Stat(ctx, key); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Stat after Delete: err = %v", err)
+	}
+	if err := w.Delete(ctx, key); !errors.Is(err, ErrNotExist) {
+		t.Errorf("second Delete: err = %v, want ErrNotExist", err)
+	}
+}
+
+func TestWebDAVFailedMoveLeavesNothing(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeDAV(t)
+	fake.failMove = true
+	w := fake.client(t, "dav-pass")
+
+	key := "shop/backup.zip"
+	if _, err := w.Put(ctx, key, strings.NewReader("backup")); err == nil {
+		t.Fatal("Put succeeded although MOVE failed")
+	}
+	if keys := fake.keysWithSuffix(""); len(keys) != 0 {
+		t.Fatalf("objects after a failed Put: %q", keys)
+	}
+	if _, err := w.Stat(ctx, key); !errors.Is(err, ErrNotExist) {
+		t.Fatalf("Stat after a failed Put: err = %v, want ErrNotExist", err)
+	}
+}
+
+func TestWebDAVWrongPassword(t *testing.T) {
+	fake := newFakeDAV(t)
+	w := fake.client(t, "wrong")
+
+	_, err := w.Put(context.Background(), "shop/backup.zip", strings.NewReader("backup"))
+	var se *statusError
+	if !errors.As(err, &se) || se.code != http.StatusUnauthorized {
+		t.Fatalf("err = %v, want http status 401", err)
+	}
+	if strings.Contains(w.String(), "wrong") {
+		t.Errorf("String() shows the password: %s", w)
+	}
+}
+
+// fakeDAV is a WebDAV stand-in with the methods the backend uses: MKCOL,
+// PUT, MOVE, GET, DELETE and PROPFIND with Depth 0 and 1. Like a real
+// server it refuses MKCOL and PUT below a missing collection.
+type fakeDAV struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	failMove bool
+
+	mu    sync.Mutex
+	files map[string][]byte // key relative to fakeDAVBase
+	dirs  map[string]bool   // "" is the base collection
+}
+
+const fakeDAVBase = "/remote.php/dav/files/backup/httpBackupGo/"
+
+func newFakeDAV(t *testing.T) *fakeDAV {
+	f := &fakeDAV{t: t, files: map[string][]byte{}, dirs: map[string]bool{"": true}}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeDAV) client(t *testing.T, password string) *WebDAV {
+	w, err := NewWebDAV(config.WebDAVConfig{
+		URL:      f.srv.URL + strings.TrimSuffix(fakeDAVBase, "/"),
+		Username: "backup",
+		Password: password,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// keysWithSuffix lists the stored files ending in suffix.
+func (f *fakeDAV) keysWithSuffix(suffix string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []string
+	for k := range f.files {
+		if strings.HasSuffix(k, suffix) {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+func parentDir(key string) string {
+	if d := path.Dir(key); d != "." {
+		return d
+	}
+	return ""
+}
+
+func (f *fakeDAV) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if user, pass, ok := r.BasicAuth(); !ok || user != "backup" || pass != "dav-pass" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path+"/", fakeDAVBase)
+	if !ok {
+		http.Error(w, "outside the base collection", http.StatusForbidden)
+		return
+	}
+	key = strings.Trim(key, "/")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case "MKCOL":
+		if f.dirs[key] || f.files[key] != nil {
+			http.Error(w, "exists", http.StatusMethodNotAllowed)
+			return
+		}
+		if !f.dirs[parentDir(key)] {
+			http.Error(w, "no parent", http.StatusConflict)
+			return
+		}
+		f.dirs[key] = true
+		w.WriteHeader(http.StatusCreated)
+
+	case http.MethodPut:
+		if !f.dirs[parentDir(key)] {
+			http.Error(w, "no parent", http.StatusConflict)
+			return
+		}
+		f.files[key] = body
+		w.WriteHeader(http.StatusCreated)
+
+	case "MOVE":
+		if f.failMove {
+			http.Error(w, "storage full", http.StatusInsufficientStorage)
+			return
+		}
+		data, ok := f.files[key]
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		dst, err := url.Parse(r.Header.Get("Destination"))
+		if err != nil || !strings.HasPrefix(dst.Path, fakeDAVBase) || r.Header.Get("Overwrite") != "T" {
+			http.Error(w, "bad destination", http.StatusBadRequest)
+			return
+		}
+		f.files[strings.TrimPrefix(dst.Path, fakeDAVBase)] = data
+		delete(f.files, key)
+		w.WriteHeader(http.StatusCreated)
+
+	case http.MethodGet:
+		data, ok := f.files[key]
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+
+	case http.MethodDelete:
+		if _, ok := f.files[key]; !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		delete(f.files, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	case "PROPFIND":
+		if !bytes.Contains(body, []byte("getcontentlength")) {
+			http.Error(w, "bad propfind", http.StatusBadRequest)
+			return
+		}
+		f.propfind(w, key, r.Header.Get("Depth"))
+
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		f.t.Errorf("fake webdav: unexpected %s %s", r.Method, r.URL)
+	}
+}
+
+func (f *fakeDAV) propfind(w http.ResponseWriter, key, depth string) {
+	var b strings.Builder
+	entry := func(k string, dir bool) {
+		p := fakeDAVBase + k
+		prop := fmt.Sprintf("<d:getcontentlength>%d</d:getcontentlength>", len(f.files[k]))
+		if dir {
+			if k != "" {
+				p += "/"
+			}
+			prop = "<d:resourcetype><d:collection/></d:resourcetype>"
+		}
+		fmt.Fprintf(&b, "<d:response><d:href>%s</d:href><d:propstat><d:prop>%s"+
+			"<d:getlastmodified>%s</d:getlastmodified></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>",
+			(&url.URL{Path: p}).EscapedPath(), prop, time.Now().UTC().Format(http.TimeFormat))
+	}
+
+	switch {
+	case f.files[key] != nil:
+		entry(key, false)
+	case f.dirs[key]:
+		entry(key, true)
+		if depth == "1" {
+			for d := range f.dirs {
+				if d != "" && parentDir(d) == key {
+					entry(d, true)
+				}
+			}
+			for k := range f.files {
+				if parentDir(k) == key {
+					entry(k, false)
+				}
+			}
+		}
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><d:multistatus xmlns:d="DAV:">%s</d:multistatus>`, b.String())
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"httpBackupGo/runlog"
+	"httpBackupGo/storage"
+)
+
+// Exit codes of `run`.
+const (
+	exitFailed  = 1 // every site failed (or the run could not start)
+	exitPartial = 3 // some sites failed
+)
+
+// runExitCode maps the run status to the exit code of `run`.
+func runExitCode(r runlog.Run) int {
+	switch r.Status {
+	case runlog.StatusPartial:
+		return exitPartial
+	case runlog.StatusFailed:
+		return exitFailed
+	}
+	return 0
+}
+
+// writeSummary writes the run as JSON to path, or to stdout for "-".
+// Files are replaced atomically so a monitoring job never reads half a summary.
+func writeSummary(path string, r runlog.Run) error {
+	// Error texts often quote HTML error pages; keep them readable.
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(r); err != nil {
+		return fmt.Errorf("marshal summary: %w", err)
+	}
+	b := buf.Bytes()
+
+	if path == "-" {
+		_, err := os.Stdout.Write(b)
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create summary directory: %w", err)
+	}
+	tmp := path + storage.TempSuffix
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("write summary: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("replace summary: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+// Package sweep cleans up after interrupted writes: temp files left behind
+// when the process died mid-download are deleted once they are older than
+// Config.StaleTempMinutes. Files in local storage that no site's layout
+// accounts for are only reported (see LoadReport), never deleted.
+package sweep
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"httpBackupGo/catalog"
+	"httpBackupGo/config"
+	"httpBackupGo/storage"
+)
+
+// ReportName is the report file in the state folder (<BackupFolder>/.httpbackupgo/).
+const ReportName = "leftovers.json"
+
+// maxListed caps the leftovers stored in the report; Count has the total.
+const maxListed = 200
+
+// Leftover is a file in local storage that belongs to no site.
+type Leftover struct {
+	Path    string    `json:"Path"`
+	Size    int64     `json:"Size"`
+	ModTime time.Time `json:"ModTime"`
+}
+
+// Report is the outcome of one sweep.
+type Report struct {
+	Time      time.Time  `json:"Time"`
+	Removed   int        `json:"Removed"` // stale temp files deleted
+	Count     int        `json:"Count"`   // leftovers found
+	Leftovers []Leftover `json:"Leftovers"`
+}
+
+// Run sweeps BackupFolder (including the state folder's spool and catalog
+// temp files) and every other local storage path used by a site or mirror,
+// then saves the report. Resuming a partial download is not possible (the
+// checksum, compression and encryption streams start at byte 0), so stale
+// temp files are deleted.
+//
+// Remote backends are not swept: S3 multipart uploads should be expired with
+// a bucket lifecycle rule, and WebDAV/SFTP temp objects are removed on failure.
+func Run(ctx context.Context, cfg config.Config) (Report, error) {
+	maxAge := time.Duration(cfg.StaleTempMinutes) * time.Minute
+	rep := Report{Time: time.Now(), Leftovers: []Leftover{}}
+
+	roots := localRoots(cfg)
+	paths := make([]string, 0, len(roots))
+	for p := range roots {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, root := range paths {
+		layouts := roots[root]
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil // storage folder not created yet
+				}
+				return err
+			}
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			if p == root {
+				return nil
+			}
+
+			isState := d.IsDir() && d.Name() == catalog.StateDirName
+			if d.IsDir() {
+				// Another storage root nested in this one is swept on its own.
+				if _, nested := roots[p]; nested {
+					return filepath.SkipDir
+				}
+				if strings.HasPrefix(d.Name(), ".") && !isState {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return nil // removed meanwhile
+			}
+			rel, _ := filepath.Rel(root, p)
+			key := filepath.ToSlash(rel)
+			inState := strings.HasPrefix(key, catalog.StateDirName+"/")
+
+			if strings.HasSuffix(d.Name(), storage.TempSuffix) {
+				if time.Since(info.ModTime()) < maxAge {
+					return nil // may still be written to
+				}
+				if err := os.Remove(p); err != nil {
+					slog.Warn("sweep: remove failed", "path", p, "err", err)
+					return nil
+				}
+				slog.Info("sweep: removed stale temp file", "path", p, "size", info.Size(), "modified", info.ModTime())
+				rep.Removed++
+				return nil
+			}
+			if inState || strings.HasPrefix(d.Name(), ".") {
+				return nil
+			}
+
+			for _, l := range layouts {
+				if _, _, ok := l.Parse(key); ok {
+					return nil
+				}
+			}
+			rep.Count++
+			if len(rep.Leftovers) < maxListed {
+				rep.Leftovers = append(rep.Leftovers, Leftover{Path: p, Size: info.Size(), ModTime: info.ModTime()})
+			}
+			return nil
+		})
+		if err != nil {
+			return rep, fmt.Errorf("sweep %s: %w", root, err)
+		}
+	}
+
+	slog.Info("sweep: done", "roots", len(paths), "removed", rep.Removed, "leftovers", rep.Count)
+	if err := saveReport(cfg.BackupFolder, rep); err != nil {
+		return rep, err
+	}
+	return rep, nil
+}
+
+// localRoots maps every local storage root to the layouts of the sites whose
+// backups live there. BackupFolder is always included (it holds the state folder).
+func localRoots(cfg config.Config) map[string][]catalog.Layout {
+	roots := map[string][]catalog.Layout{filepath.Clean(cfg.BackupFolder): nil}
+
+	var all []catalog.Layout
+	for _, site := range cfg.Sites {
+		l, err := catalog.LayoutFor(cfg, site)
+		if err != nil {
+			continue
+		}
+		all = append(all, l)
+
+		b, err := storage.ForSite(cfg, site)
+		if err != nil {
+			continue
+		}
+		if lb, ok := b.(*storage.Local); ok {
+			roots[lb.Root] = append(roots[lb.Root], l)
+		}
+	}
+
+	// Mirrors hold every site's backups.
+	for _, m := range cfg.Mirrors {
+		if !m.Enabled {
+			continue
+		}
+		b, err := storage.New(cfg, m.Storage)
+		if err != nil {
+			continue
+		}
+		if lb, ok := b.(*storage.Local); ok {
+			roots[lb.Root] = append(roots[lb.Root], all...)
+		}
+	}
+	return roots
+}
+
+func reportPath(backupFolder string) string {
+	return filepath.Join(filepath.Clean(backupFolder), catalog.StateDirName, ReportName)
+}
+
+func saveReport(backupFolder string, rep Report) error {
+	p := reportPath(backupFolder)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return fmt.Errorf("create state directory: %w", err)
+	}
+	b, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal report: %w", err)
+	}
+	b = append(b, '\n')
+
+	tmp := p + storage.TempSuffix
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("write report: %w", err)
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("replace report: %w", err)
+	}
+	return nil
+}
+
+// LoadReport returns the last saved report. ok is false when no sweep ran yet.
+func LoadReport(backupFolder string) (rep Report, ok bool, err error) {
+	b, err := os.ReadFile(reportPath(backupFolder))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Report{}, false, nil
+		}
+		return Report{}, false, err
+	}
+	if err := json.Unmarshal(b, &rep); err != nil {
+		return Report{}, false, fmt.Errorf("parse report: %w", err)
+	}
+	return rep, true, nil
+}
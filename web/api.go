@@ -0,0 +1,694 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"httpBackupGo/auth"
+	"httpBackupGo/catalog"
+	"httpBackupGo/config"
+	"httpBackupGo/runlog"
+)
+
+// The JSON API mirrors the HTML UI for scripts: /api/v1/... Every response
+// is JSON; errors are {"Error": "...", "Details": [...]} with a matching
+// status code. Config changes go through config.ValidateAndNormalize, exactly
+// like the admin form, and are returned normalized.
+
+// maxBodyBytes limits request bodies (a config is a few KiB).
+const maxBodyBytes = 1 << 20
+
+// secretPlaceholder replaces stored secrets (S3 secret keys, WebDAV
+// passwords, SFTP key passphrases) in responses. Sending it back keeps the
+// stored value (422 when there is none to keep); any other value, including
+// "", replaces it.
+const secretPlaceholder = "********"
+
+type apiError struct {
+	Error   string   `json:"Error"`
+	Details []string `json:"Details,omitempty"`
+}
+
+type apiSiteBackups struct {
+	ID         string          `json:"ID"`
+	Site       string          `json:"Site"`
+	Slug       string          `json:"Slug"`
+	Count      int             `json:"Count"`
+	TotalBytes int64           `json:"TotalBytes"`
+	Backups    []catalog.Entry `json:"Backups"` // oldest first
+	Error      string          `json:"Error,omitempty"`
+}
+
+type apiRunRequest struct {
+	Sites []string `json:"Sites"` // names or IDs; empty runs all enabled sites
+}
+
+type apiStatus struct {
+	Running         bool        `json:"Running"`
+	Current         *runlog.Run `json:"Current,omitempty"`
+	Last            *runlog.Run `json:"Last,omitempty"` // newest finished run
+	IntervalMinutes int         `json:"IntervalMinutes"`
+	Sites           int         `json:"Sites"`
+	EnabledSites    int         `json:"EnabledSites"`
+}
+
+func (s *Server) registerAPI(mux *http.ServeMux) {
+	viewer := func(h http.HandlerFunc) http.HandlerFunc { return s.need(auth.RoleViewer, h) }
+	operator := func(h http.HandlerFunc) http.HandlerFunc { return s.need(auth.RoleOperator, h) }
+	admin := func(h http.HandlerFunc) http.HandlerFunc { return s.need(auth.RoleAdmin, h) }
+
+	mux.HandleFunc("GET /api/v1/status", viewer(s.apiStatus))
+
+	mux.HandleFunc("GET /api/v1/config", admin(s.apiGetConfig))
+	mux.HandleFunc("PUT /api/v1/config", admin(s.apiPutConfig))
+	mux.HandleFunc("POST /api/v1/config/validate", admin(s.apiValidateConfig))
+
+	mux.HandleFunc("GET /api/v1/sites", admin(s.apiListSites))
+	mux.HandleFunc("POST /api/v1/sites", admin(s.apiCreateSite))
+	mux.HandleFunc("GET /api/v1/sites/{site}", admin(s.apiGetSite))
+	mux.HandleFunc("PUT /api/v1/sites/{site}", admin(s.apiPutSite))
+	mux.HandleFunc("DELETE /api/v1/sites/{site}", admin(s.apiDeleteSite))
+	mux.HandleFunc("GET /api/v1/sites/{site}/backups", viewer(s.apiSiteBackups))
+	mux.HandleFunc("POST /api/v1/sites/{site}/runs", operator(s.apiRunSite))
+
+	mux.HandleFunc("GET /api/v1/backups", viewer(s.apiBackups))
+
+	mux.HandleFunc("GET /api/v1/runs", viewer(s.apiListRuns))
+	mux.HandleFunc("POST /api/v1/runs", operator(s.apiStartRun))
+	mux.HandleFunc("GET /api/v1/runs/{id}", viewer(s.apiGetRun))
+
+	// Anything else under /api/ gets a JSON 404/405 instead of the HTML UI.
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		var allow []string
+		for _, m := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete} {
+			probe := r.Clone(r.Context())
+			probe.Method = m
+			if _, pattern := mux.Handler(probe); pattern != "/api/" && pattern != "" {
+				allow = append(allow, m)
+			}
+		}
+		if len(allow) > 0 {
+			w.Header().Set("Allow", strings.Join(allow, ", "))
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeError(w, http.StatusNotFound, "no such endpoint")
+	})
+}
+
+// ---- Status ----
+
+func (s *Server) apiStatus(w http.ResponseWriter, r *http.Request) {
+	cfg, ok := s.apiConfig(w)
+	if !ok {
+		return
+	}
+	runs, err := runlog.Load(cfg.BackupFolder)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "run history: "+err.Error())
+		return
+	}
+	u := currentUser(r)
+	runs = visibleRuns(u, cfg, runs)
+	sites := visibleSites(u, cfg)
+
+	st := apiStatus{IntervalMinutes: cfg.IntervalMinutes, Sites: len(sites)}
+	for _, site := range sites {
+		if site.Enabled {
+			st.EnabledSites++
+		}
+	}
+	for i := range runs {
+		switch runs[i].Status {
+		case runlog.StatusRunning, runlog.StatusQueued:
+			if st.Current == nil {
+				st.Current = &runs[i]
+			}
+		default:
+			if st.Last == nil {
+				st.Last = &runs[i]
+			}
+		}
+	}
+	st.Running = st.Current != nil
+	writeJSON(w, http.StatusOK, st)
+}
+
+// ---- Config ----
+
+func (s *Server) apiGetConfig(w http.ResponseWriter, r *http.Request) {
+	cfg, ok := s.apiConfig(w)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, redactConfig(cfg))
+}
+
+func (s *Server) apiPutConfig(w http.ResponseWriter, r *http.Request) {
+	s.cfgMu.Lock()
+	defer s.cfgMu.Unlock()
+
+	cur, ok := s.apiConfig(w)
+	if !ok {
+		return
+	}
+	var cfg config.Config
+	if !decodeJSON(w, r, &cfg) {
+		return
+	}
+	if !apiKeepSecrets(w, &cfg, cur) {
+		return
+	}
+	s.apiSave(w, cfg, http.StatusOK, func(cfg config.Config) any { return redactConfig(cfg) })
+}
+
+// apiValidateConfig normalizes a config without saving it.
+func (s *Server) apiValidateConfig(w http.ResponseWriter, r *http.Request) {
+	cur, ok := s.apiConfig(w)
+	if !ok {
+		return
+	}
+	var cfg config.Config
+	if !decodeJSON(w, r, &cfg) {
+		return
+	}
+	if !apiKeepSecrets(w, &cfg, cur) {
+		return
+	}
+	if err := cfg.ValidateAndNormalize(); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, redactConfig(cfg))
+}
+
+// ---- Sites ----
+
+func (s *Server) apiListSites(w http.ResponseWriter, r *http.Request) {
+	cfg, ok := s.apiConfig(w)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, redactConfig(cfg).Sites)
+}
+
+func (s *Server) apiGetSite(w http.ResponseWriter, r *http.Request) {
+	cfg, ok := s.apiConfig(w)
+	if !ok {
+		return
+	}
+	i, ok := apiFindSite(w, cfg, r.PathValue("site"))
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, redactConfig(cfg).Sites[i])
+}
+
+func (s *Server) apiCreateSite(w http.ResponseWriter, r *http.Request) {
+	s.cfgMu.Lock()
+	defer s.cfgMu.Unlock()
+
+	cfg, ok := s.apiConfig(w)
+	if !ok {
+		return
+	}
+	var site config.Site
+	if !decodeJSON(w, r, &site) {
+		return
+	}
+	site.Name = strings.TrimSpace(site.Name)
+	if site.Name == "" {
+		writeError(w, http.StatusUnprocessableEntity, "invalid config", "site name is empty")
+		return
+	}
+	if findSite(cfg, site.Name) >= 0 || (site.ID != "" && findSite(cfg, site.ID) >= 0) {
+		writeError(w, http.StatusConflict, fmt.Sprintf("site %q already exists", site.Name))
+		return
+	}
+
+	cfg.Sites = append(cfg.Sites, site)
+	s.apiSave(w, cfg, http.StatusCreated, func(cfg config.Config) any {
+		out := redactConfig(cfg)
+		for _, st := range out.Sites {
+			if st.Name == site.Name {
+				w.Header().Set("Location", "/api/v1/sites/"+st.ID)
+				return st
+			}
+		}
+		return nil
+	})
+}
+
+// apiPutSite replaces a site. The ID cannot change; an omitted Slug keeps the
+// current one. A new slug moves the backups on the next run (see
+// catalog.FollowRename).
+func (s *Server) apiPutSite(w http.ResponseWriter, r *http.Request) {
+	s.cfgMu.Lock()
+	defer s.cfgMu.Unlock()
+
+	cfg, ok := s.apiConfig(w)
+	if !ok {
+		return
+	}
+	i, ok := apiFindSite(w, cfg, r.PathValue("site"))
+	if !ok {
+		return
+	}
+	var site config.Site
+	if !decodeJSON(w, r, &site) {
+		return
+	}
+
+	id := cfg.Sites[i].ID
+	if site.ID != "" && site.ID != id {
+		writeError(w, http.StatusBadRequest, "the site ID cannot be changed")
+		return
+	}
+	site.ID = id
+	site.Name = strings.TrimSpace(site.Name)
+	if site.Name == "" {
+		writeError(w, http.StatusUnprocessableEntity, "invalid config", "site name is empty")
+		return
+	}
+	// Like the admin form: a rename keeps the folder unless a slug is given.
+	if strings.TrimSpace(site.Slug) == "" {
+		site.Slug = cfg.Sites[i].Slug
+	}
+	if j := findSite(cfg, site.Name); j >= 0 && j != i {
+		writeError(w, http.StatusConflict, fmt.Sprintf("site %q already exists", site.Name))
+		return
+	}
+
+	old := cfg
+	cfg.Sites = append([]config.Site(nil), cfg.Sites...)
+	cfg.Sites[i] = site
+	if !apiKeepSecrets(w, &cfg, old) {
+		return
+	}
+	s.apiSave(w, cfg, http.StatusOK, func(cfg config.Config) any {
+		out := redactConfig(cfg)
+		if j := findSite(out, id); j >= 0 {
+			return out.Sites[j]
+		}
+		return nil
+	})
+}
+
+// apiDeleteSite removes a site from the config. Its backups stay in storage.
+func (s *Server) apiDeleteSite(w http.ResponseWriter, r *http.Request) {
+	s.cfgMu.Lock()
+	defer s.cfgMu.Unlock()
+
+	cfg, ok := s.apiConfig(w)
+	if !ok {
+		return
+	}
+	i, ok := apiFindSite(w, cfg, r.PathValue("site"))
+	if !ok {
+		return
+	}
+	cfg.Sites = append(cfg.Sites[:i:i], cfg.Sites[i+1:]...)
+	s.apiSave(w, cfg, http.StatusNoContent, nil)
+}
+
+// ---- Backups ----
+
+func (s *Server) apiSiteBackups(w http.ResponseWriter, r *http.Request) {
+	cfg, ok := s.apiConfig(w)
+	if !ok {
+		return
+	}
+	i, ok := apiFindVisibleSite(w, r, cfg)
+	if !ok {
+		return
+	}
+	sb := siteBackupsJSON(r, cfg, cfg.Sites[i])
+	if sb.Error != "" {
+		writeError(w, http.StatusInternalServerError, "catalog: "+sb.Error)
+		return
+	}
+	writeJSON(w, http.StatusOK, sb)
+}
+
+func (s *Server) apiBackups(w http.ResponseWriter, r *http.Request) {
+	cfg, ok := s.apiConfig(w)
+	if !ok {
+		return
+	}
+	sites := visibleSites(currentUser(r), cfg)
+	out := make([]apiSiteBackups, 0, len(sites))
+	for _, site := range sites {
+		out = append(out, siteBackupsJSON(r, cfg, site))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func siteBackupsJSON(r *http.Request, cfg config.Config, site config.Site) apiSiteBackups {
+	sb := apiSiteBackups{ID: site.ID, Site: site.Name, Slug: site.Slug, Backups: []catalog.Entry{}}
+	c, err := loadCatalog(r.Context(), cfg, site)
+	if err != nil {
+		sb.Error = err.Error()
+		return sb
+	}
+	sb.Count = len(c.Entries)
+	sb.TotalBytes = c.TotalSize()
+	if c.Entries != nil {
+		sb.Backups = c.Entries
+	}
+	return sb
+}
+
+// ---- Runs ----
+
+func (s *Server) apiListRuns(w http.ResponseWriter, r *http.Request) {
+	cfg, ok := s.apiConfig(w)
+	if !ok {
+		return
+	}
+	runs, err := runlog.Load(cfg.BackupFolder)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "run history: "+err.Error())
+		return
+	}
+	runs = visibleRuns(currentUser(r), cfg, runs)
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a non-negative number")
+			return
+		}
+		runs = runs[:min(n, len(runs))]
+	}
+	writeJSON(w, http.StatusOK, runs)
+}
+
+func (s *Server) apiGetRun(w http.ResponseWriter, r *http.Request) {
+	cfg, ok := s.apiConfig(w)
+	if !ok {
+		return
+	}
+	run, found, err := runlog.Get(cfg.BackupFolder, r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "run history: "+err.Error())
+		return
+	}
+	visible := visibleRuns(currentUser(r), cfg, []runlog.Run{run})
+	if !found || len(visible) == 0 {
+		writeError(w, http.StatusNotFound, "unknown run")
+		return
+	}
+	writeJSON(w, http.StatusOK, visible[0])
+}
+
+// apiStartRun queues a run of the given sites (all enabled when none are
+// given). The response carries the run ID to poll at /api/v1/runs/{id}.
+func (s *Server) apiStartRun(w http.ResponseWriter, r *http.Request) {
+	var req apiRunRequest
+	if r.ContentLength != 0 && !decodeJSON(w, r, &req) {
+		return
+	}
+	s.apiQueueRun(w, r, req.Sites)
+}
+
+func (s *Server) apiRunSite(w http.ResponseWriter, r *http.Request) {
+	s.apiQueueRun(w, r, []string{r.PathValue("site")})
+}
+
+func (s *Server) apiQueueRun(w http.ResponseWriter, r *http.Request, sites []string) {
+	cfg, ok := s.apiConfig(w)
+	if !ok {
+		return
+	}
+	u := currentUser(r)
+	names := make([]string, 0, len(sites))
+	for _, key := range sites {
+		i := findSite(cfg, key)
+		if i < 0 || !u.SeesSite(cfg.Sites[i].ID, cfg.Sites[i].Name) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("unknown site %q", key))
+			return
+		}
+		names = append(names, cfg.Sites[i].Name)
+	}
+	// "All enabled sites" of a user limited to some sites are their enabled sites.
+	if len(names) == 0 && u.Scoped() {
+		for _, site := range visibleSites(u, cfg) {
+			if site.Enabled {
+				names = append(names, site.Name)
+			}
+		}
+		if len(names) == 0 {
+			writeError(w, http.StatusUnprocessableEntity, "none of your sites is enabled")
+			return
+		}
+	}
+
+	run := runlog.Begin("", "api", names)
+	run.Status = runlog.StatusQueued
+	if cur, busy, err := runlog.Enqueue(cfg.BackupFolder, run); err != nil {
+		writeError(w, http.StatusInternalServerError, "run history: "+err.Error())
+		return
+	} else if busy {
+		writeJSON(w, http.StatusConflict, struct {
+			apiError
+			Run runlog.Run `json:"Run"`
+		}{apiError{Error: "a run is already in progress"}, cur})
+		return
+	}
+	if !nonBlockingSend(s.events, Event{Type: EventRunNow, Sites: names, RunID: run.ID}) {
+		run.Status, run.Finished, run.Error = runlog.StatusSkipped, run.Started, "scheduler busy"
+		_ = runlog.Record(cfg.BackupFolder, run)
+		writeError(w, http.StatusServiceUnavailable, "scheduler busy, try again")
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/runs/"+run.ID)
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+// ---- Helpers ----
+
+// apiConfig loads the current config, writing a 500 when that fails.
+func (s *Server) apiConfig(w http.ResponseWriter) (config.Config, bool) {
+	cfg, err := config.LoadOrCreate(s.cfgPath)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load config: "+err.Error())
+		return config.Config{}, false
+	}
+	return cfg, true
+}
+
+// apiSave validates and saves cfg, notifies the scheduler and writes
+// body(normalized cfg) with status (no body when body is nil).
+func (s *Server) apiSave(w http.ResponseWriter, cfg config.Config, status int, body func(config.Config) any) {
+	if err := cfg.ValidateAndNormalize(); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if err := config.Save(s.cfgPath, cfg); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to save config: "+err.Error())
+		return
+	}
+	nonBlockingSend(s.events, Event{Type: EventConfigChanged})
+
+	if body == nil {
+		w.WriteHeader(status)
+		return
+	}
+	writeJSON(w, status, body(cfg))
+}
+
+// findSite returns the index of the site with the given ID or name
+// (case-insensitive, like duplicate detection), or -1.
+func findSite(cfg config.Config, key string) int {
+	for i, site := range cfg.Sites {
+		if site.ID == key {
+			return i
+		}
+	}
+	for i, site := range cfg.Sites {
+		if key != "" && strings.EqualFold(site.Name, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+func apiFindSite(w http.ResponseWriter, cfg config.Config, key string) (int, bool) {
+	i := findSite(cfg, key)
+	if i < 0 {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown site %q", key))
+		return 0, false
+	}
+	return i, true
+}
+
+// apiFindVisibleSite is apiFindSite for the {site} of the path, treating
+// sites the user may not see as unknown.
+func apiFindVisibleSite(w http.ResponseWriter, r *http.Request, cfg config.Config) (int, bool) {
+	key := r.PathValue("site")
+	i := findSite(cfg, key)
+	if i < 0 || !currentUser(r).SeesSite(cfg.Sites[i].ID, cfg.Sites[i].Name) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown site %q", key))
+		return 0, false
+	}
+	return i, true
+}
+
+// decodeJSON reads one JSON value into v. Unknown fields are rejected so
+// typos don't silently reset a setting.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return false
+	}
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		writeError(w, http.StatusBadRequest, "invalid JSON: trailing data after the value")
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Printf("api: write response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string, details ...string) {
+	writeJSON(w, status, apiError{Error: msg, Details: details})
+}
+
+// writeValidationError reports a ValidateAndNormalize error as 422, one
+// detail per problem.
+func writeValidationError(w http.ResponseWriter, err error) {
+	var details []string
+	var joined interface{ Unwrap() []error }
+	if errors.As(err, &joined) {
+		for _, e := range joined.Unwrap() {
+			details = append(details, e.Error())
+		}
+	} else {
+		details = []string{err.Error()}
+	}
+	writeError(w, http.StatusUnprocessableEntity, "invalid config", details...)
+}
+
+// ---- Secrets ----
+
+// storageSecrets calls fn for every secret field of st, copying the nested
+// structs first so the caller's config is never modified through a shared pointer.
+func storageSecrets(st *config.Storage, fn func(name string, field *string)) {
+	if st.S3 != nil {
+		c := *st.S3
+		fn("S3.SecretKey", &c.SecretKey)
+		st.S3 = &c
+	}
+	if st.WebDAV != nil {
+		c := *st.WebDAV
+		fn("WebDAV.Password", &c.Password)
+		st.WebDAV = &c
+	}
+	if st.SFTP != nil {
+		c := *st.SFTP
+		fn("SFTP.KeyPassphrase", &c.KeyPassphrase)
+		st.SFTP = &c
+	}
+}
+
+// storages returns every storage block of cfg by a stable key (sites by ID,
+// or by position when they have none yet, mirrors by name), copying site overrides so they can be changed safely.
+func storages(cfg *config.Config) map[string]*config.Storage {
+	m := map[string]*config.Storage{"": &cfg.Storage}
+	for i := range cfg.Sites {
+		if cfg.Sites[i].Storage != nil {
+			st := *cfg.Sites[i].Storage
+			cfg.Sites[i].Storage = &st
+			if id := cfg.Sites[i].ID; id != "" {
+				m["site:"+id] = &st
+			} else {
+				m["site#"+strconv.Itoa(i+1)] = &st // new: matches nothing stored
+			}
+		}
+	}
+	for i := range cfg.Mirrors {
+		m["mirror:"+cfg.Mirrors[i].Name] = &cfg.Mirrors[i].Storage
+	}
+	return m
+}
+
+func redactConfig(cfg config.Config) config.Config {
+	cfg.Sites = append([]config.Site(nil), cfg.Sites...)
+	cfg.Mirrors = append([]config.Mirror(nil), cfg.Mirrors...)
+	for _, st := range storages(&cfg) {
+		storageSecrets(st, func(_ string, f *string) {
+			if *f != "" {
+				*f = secretPlaceholder
+			}
+		})
+	}
+	return cfg
+}
+
+// keepSecrets puts the stored secrets back where cfg still has the
+// placeholder. A placeholder with no stored secret to match (e.g. a site sent
+// without its ID) is an error rather than an empty secret.
+func keepSecrets(cfg *config.Config, cur config.Config) error {
+	cur.Sites = append([]config.Site(nil), cur.Sites...)
+	cur.Mirrors = append([]config.Mirror(nil), cur.Mirrors...)
+	old := storages(&cur)
+
+	var errs []error
+	for key, st := range storages(cfg) {
+		stored := map[string]string{}
+		if o, ok := old[key]; ok {
+			storageSecrets(o, func(name string, f *string) { stored[name] = *f })
+		}
+		storageSecrets(st, func(name string, f *string) {
+			if *f != secretPlaceholder {
+				return
+			}
+			if stored[name] == "" {
+				errs = append(errs, fmt.Errorf("%s.%s is %q but there is no stored secret to keep; send the site ID or the secret itself", describeStorage(key), name, secretPlaceholder))
+				return
+			}
+			*f = stored[name]
+		})
+	}
+	return errors.Join(errs...)
+}
+
+// apiKeepSecrets is keepSecrets, writing a 422 when it fails.
+func apiKeepSecrets(w http.ResponseWriter, cfg *config.Config, cur config.Config) bool {
+	if err := keepSecrets(cfg, cur); err != nil {
+		writeValidationError(w, err)
+		return false
+	}
+	return true
+}
+
+// describeStorage names a storages key for error messages.
+func describeStorage(key string) string {
+	switch {
+	case key == "":
+		return "Storage"
+	case strings.HasPrefix(key, "site:"):
+		return "site " + strings.TrimPrefix(key, "site:") + ": Storage"
+	case strings.HasPrefix(key, "site#"):
+		return "site #" + strings.TrimPrefix(key, "site#") + " (no ID): Storage"
+	default:
+		return "mirror " + strings.TrimPrefix(key, "mirror:") + ": Storage"
+	}
+}
@@ -0,0 +1,169 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"httpBackupGo/auth"
+	"httpBackupGo/config"
+)
+
+func TestConcurrentRunsQueueOnce(t *testing.T) {
+	ts := newTestServer(t, nil)
+	token, _, err := ts.users.CreateToken("admin", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 32
+	codes := make(chan int, n)
+	var wg sync.WaitGroup
+	for range n {
+		wg.Go(func() {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/runs", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			codes <- ts.do(req).Code
+		})
+	}
+	wg.Wait()
+	close(codes)
+
+	count := map[int]int{}
+	for c := range codes {
+		count[c]++
+	}
+	if count[http.StatusAccepted] != 1 || count[http.StatusConflict] != n-1 {
+		t.Fatalf("status counts = %v, want one 202 and %d 409", count, n-1)
+	}
+	if len(ts.events) != 1 {
+		t.Errorf("%d runs reached the scheduler, want 1", len(ts.events))
+	}
+}
+
+// secretsConfig has a secret in a site override and in a mirror.
+func secretsConfig(cfg *config.Config) {
+	cfg.Sites[0].Storage = &config.Storage{
+		Type:   "webdav",
+		WebDAV: &config.WebDAVConfig{URL: "https://dav.example.com/backups/", Username: "u", Password: "dav-secret"},
+	}
+	cfg.Mirrors = []config.Mirror{{
+		Enabled: true,
+		Name:    "offsite",
+		Storage: config.Storage{
+			Type: "s3",
+			S3:   &config.S3Config{Endpoint: "https://s3.example.com", Region: "eu-west-1", Bucket: "b", AccessKey: "AK", SecretKey: "s3-secret"},
+		},
+	}}
+}
+
+func TestConfigSecrets(t *testing.T) {
+	ts := newTestServer(t, secretsConfig)
+	admin := ts.login("admin", "admin password")
+
+	get := admin.do(http.MethodGet, "/api/v1/config", "")
+	if get.Code != http.StatusOK {
+		t.Fatalf("GET config: status %d", get.Code)
+	}
+	body := get.Body.String()
+	for _, secret := range []string{"dav-secret", "s3-secret"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("GET config returns %q", secret)
+		}
+	}
+	for _, path := range []string{"/api/v1/sites", "/api/v1/sites/shop"} {
+		if strings.Contains(admin.do(http.MethodGet, path, "").Body.String(), "dav-secret") {
+			t.Errorf("GET %s returns the site secret", path)
+		}
+	}
+	var redacted config.Config
+	decode(t, body, &redacted)
+	if redacted.Sites[0].Storage.WebDAV.Password != secretPlaceholder || redacted.Mirrors[0].Storage.S3.SecretKey != secretPlaceholder {
+		t.Fatalf("secrets not replaced by the placeholder: %s", body)
+	}
+
+	// Sending the redacted config back keeps the stored secrets.
+	redacted.Retention = 7
+	if rec := admin.do(http.MethodPut, "/api/v1/config", jsonBody(t, redacted)); rec.Code != http.StatusOK {
+		t.Fatalf("PUT config: status %d: %s", rec.Code, rec.Body)
+	}
+	// So does a site PUT.
+	site := redacted.Sites[0]
+	site.Url = "https://shop.example.com/new.zip"
+	if rec := admin.do(http.MethodPut, "/api/v1/sites/"+site.ID, jsonBody(t, site)); rec.Code != http.StatusOK {
+		t.Fatalf("PUT site: status %d: %s", rec.Code, rec.Body)
+	}
+	stored := loadConfig(t, ts)
+	if stored.Retention != 7 || stored.Sites[0].Url != site.Url {
+		t.Fatalf("changes not saved: %+v", stored)
+	}
+	if stored.Sites[0].Storage.WebDAV.Password != "dav-secret" || stored.Mirrors[0].Storage.S3.SecretKey != "s3-secret" {
+		t.Fatalf("secrets lost: %+v %+v", stored.Sites[0].Storage.WebDAV, stored.Mirrors[0].Storage.S3)
+	}
+
+	// A site without its ID cannot be matched to its secret: 422, nothing saved.
+	noID := redacted
+	noID.Sites = append([]config.Site(nil), redacted.Sites...)
+	noID.Sites[0].ID = ""
+	noID.Retention = 9
+	if rec := admin.do(http.MethodPut, "/api/v1/config", jsonBody(t, noID)); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("placeholder without a match: status %d, want 422", rec.Code)
+	}
+	if got := loadConfig(t, ts); got.Retention != 7 || got.Sites[0].Storage.WebDAV.Password != "dav-secret" {
+		t.Fatalf("rejected PUT changed the config")
+	}
+
+	// Any other value replaces the secret.
+	redacted.Mirrors[0].Storage.S3.SecretKey = "rotated"
+	if rec := admin.do(http.MethodPut, "/api/v1/config", jsonBody(t, redacted)); rec.Code != http.StatusOK {
+		t.Fatalf("PUT config: status %d: %s", rec.Code, rec.Body)
+	}
+	if got := loadConfig(t, ts).Mirrors[0].Storage.S3.SecretKey; got != "rotated" {
+		t.Errorf("secret = %q, want the new one", got)
+	}
+}
+
+func TestConcurrentSiteCreates(t *testing.T) {
+	ts := newTestServer(t, nil)
+	admin := ts.login("admin", "admin password")
+
+	const n = 6
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Go(func() {
+			name := "site" + string(rune('a'+i))
+			body := `{"Name": "` + name + `", "Url": "https://` + name + `.example.com/b.zip", "Enabled": true}`
+			if rec := admin.do(http.MethodPost, "/api/v1/sites", body); rec.Code != http.StatusCreated {
+				t.Errorf("create %s: status %d: %s", name, rec.Code, rec.Body)
+			}
+		})
+	}
+	wg.Wait()
+	if got := len(loadConfig(t, ts).Sites); got != 2+n {
+		t.Errorf("%d sites saved, want %d", got, 2+n)
+	}
+}
+
+func TestAPIRoutes(t *testing.T) {
+	ts := newTestServer(t, nil)
+	op := ts.addUser("otto", auth.RoleOperator)
+
+	if rec := op.do(http.MethodGet, "/api/v1/nope", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown endpoint: status %d, want 404", rec.Code)
+	}
+	rec := op.do(http.MethodDelete, "/api/v1/runs", "")
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET, POST" {
+		t.Errorf("DELETE /api/v1/runs: status %d, Allow %q", rec.Code, rec.Header().Get("Allow"))
+	}
+}
+
+func loadConfig(t *testing.T, ts *testServer) config.Config {
+	t.Helper()
+	cfg, err := config.Load(ts.cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
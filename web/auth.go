@@ -0,0 +1,482 @@
+package web
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"httpBackupGo/auth"
+)
+
+// Every page, action and API call needs a signed-in user, except the login
+// and setup pages and the static files. The UI uses a session cookie;
+// scripts send an API token as "Authorization: Bearer <token>". Until the
+// first user exists, every page leads to /setup, which needs the one-time
+// setup token from the service log (or create a user with `httpbackupgo user add`).
+
+const sessionCookie = "hbg_session"
+
+// ipFailureFactor: a client address is locked after this many times
+// Auth.MaxFailures failed attempts.
+const ipFailureFactor = 4
+
+type ctxKey int
+
+const userKey ctxKey = 0
+
+type authView struct {
+	User    string
+	Role    string
+	CSRF    string
+	Message string
+	Error   string
+	Now     string
+
+	Next       string // login: where to go afterwards
+	SetupToken string // setup: the token from the link
+
+	Tokens   []auth.Token // account: the user's API tokens
+	NewToken string       // account: a token just created, shown once
+}
+
+func (s *Server) registerAuth(mux *http.ServeMux) {
+	mux.HandleFunc("GET /login", s.handleLoginPage)
+	mux.HandleFunc("POST /login", s.handleLogin)
+	mux.HandleFunc("POST /logout", s.handleLogout)
+	mux.HandleFunc("GET /setup", s.handleSetupPage)
+	mux.HandleFunc("POST /setup", s.handleSetup)
+	mux.HandleFunc("GET /account", s.handleAccount)
+	mux.HandleFunc("POST /account/password", s.handlePassword)
+	mux.HandleFunc("POST /account/tokens", s.handleCreateToken)
+	mux.HandleFunc("POST /account/tokens/revoke", s.handleRevokeToken)
+}
+
+// currentUser returns the signed-in user of a request that passed requireAuth.
+func currentUser(r *http.Request) auth.User {
+	u, _ := r.Context().Value(userKey).(auth.User)
+	return u
+}
+
+// need lets only users with at least role through to h.
+func (s *Server) need(role string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := currentUser(r)
+		if u.Can(role) {
+			h(w, r)
+			return
+		}
+		slog.Warn("auth: permission denied", "user", u.Name, "role", u.Role, "need", role, "path", r.URL.Path)
+		msg := "permission denied: needs the " + role + " role"
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			writeError(w, http.StatusForbidden, msg)
+			return
+		}
+		http.Error(w, msg, http.StatusForbidden)
+	}
+}
+
+// requireAuth lets only signed-in users through to next.
+func (s *Server) requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isPublic(r.URL.Path) {
+			if err := s.checkOrigin(r); err != nil {
+				csrfDenied(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		api := strings.HasPrefix(r.URL.Path, "/api/")
+
+		hasUsers, err := s.users.HasUsers()
+		if err != nil {
+			slog.Error("auth: user store", "err", err)
+			http.Error(w, "user store unavailable", http.StatusInternalServerError)
+			return
+		}
+		if !hasUsers {
+			if api {
+				writeError(w, http.StatusServiceUnavailable, "no users yet: finish the setup in the web UI first")
+				return
+			}
+			http.Redirect(w, r, "/setup", http.StatusSeeOther)
+			return
+		}
+
+		if h := r.Header.Get("Authorization"); api && h != "" {
+			u, status, msg := s.tokenUser(r, h)
+			if status != 0 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="httpbackupgo"`)
+				writeError(w, status, msg)
+				return
+			}
+			next.ServeHTTP(w, withUser(r, u, ""))
+			return
+		}
+
+		if u, csrf, ok := s.sessionUser(r); ok {
+			if err := s.checkCSRF(r, csrf); err != nil {
+				csrfDenied(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, withUser(r, u, csrf))
+			return
+		}
+
+		if api {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="httpbackupgo"`)
+			writeError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+		// Only GET pages are worth coming back to after the login.
+		dest := "/"
+		if r.Method == http.MethodGet {
+			dest = r.URL.RequestURI()
+		}
+		http.Redirect(w, r, "/login?next="+url.QueryEscape(dest), http.StatusSeeOther)
+	})
+}
+
+func isPublic(path string) bool {
+	return path == "/login" || path == "/setup" || strings.HasPrefix(path, "/static/")
+}
+
+// withUser stores the user and, for sessions, the CSRF token in the request.
+func withUser(r *http.Request, u auth.User, csrf string) *http.Request {
+	ctx := context.WithValue(r.Context(), userKey, u)
+	return r.WithContext(context.WithValue(ctx, csrfKey, csrf))
+}
+
+// tokenUser checks an Authorization header. A non-zero status means it was rejected.
+// Failed tokens count towards the lockout of the client address.
+func (s *Server) tokenUser(r *http.Request, header string) (auth.User, int, string) {
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return auth.User{}, http.StatusUnauthorized, "unsupported Authorization scheme (use Bearer)"
+	}
+	ip := clientIP(r)
+	if d, locked := s.locked("", ip); locked {
+		return auth.User{}, http.StatusTooManyRequests, "too many failed attempts; retry in " + roundUp(d)
+	}
+	u, err := s.users.VerifyToken(strings.TrimSpace(token))
+	if err != nil {
+		if errors.Is(err, auth.ErrBadToken) {
+			s.failed("", ip)
+			slog.Warn("auth: invalid API token", "ip", ip)
+			return auth.User{}, http.StatusUnauthorized, "invalid API token"
+		}
+		return auth.User{}, http.StatusInternalServerError, "user store unavailable"
+	}
+	return u, 0, ""
+}
+
+// sessionUser returns the user of the request's session cookie. Sessions of
+// users deleted in the meantime end here.
+func (s *Server) sessionUser(r *http.Request) (auth.User, string, bool) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return auth.User{}, "", false
+	}
+	name, csrf, ok := s.sessions.Get(c.Value)
+	if !ok {
+		return auth.User{}, "", false
+	}
+	u, ok, err := s.users.Lookup(name)
+	if err != nil || !ok {
+		s.sessions.Delete(c.Value)
+		return auth.User{}, "", false
+	}
+	return u, csrf, true
+}
+
+// locked reports whether attempts for name (unless empty) or from ip are locked.
+// Locking the name stops password guessing against one account; locking the
+// address stops trying many accounts.
+func (s *Server) locked(name, ip string) (time.Duration, bool) {
+	if name != "" {
+		if d, ok := s.userLockout.Locked(strings.ToLower(name)); ok {
+			return d, true
+		}
+	}
+	return s.ipLockout.Locked(ip)
+}
+
+// failed records a failed attempt and reports whether it caused a lockout.
+func (s *Server) failed(name, ip string) bool {
+	locked := false
+	if name != "" {
+		locked = s.userLockout.Fail(strings.ToLower(name))
+	}
+	return s.ipLockout.Fail(ip) || locked
+}
+
+func (s *Server) startSession(w http.ResponseWriter, r *http.Request, user string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    s.sessions.Create(user),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// ---- Login ----
+
+func (s *Server) handleLoginPage(w http.ResponseWriter, r *http.Request) {
+	if hasUsers, err := s.users.HasUsers(); err == nil && !hasUsers {
+		http.Redirect(w, r, "/setup", http.StatusSeeOther)
+		return
+	}
+	next := safeNext(r.URL.Query().Get("next"))
+	if _, _, ok := s.sessionUser(r); ok {
+		http.Redirect(w, r, next, http.StatusSeeOther)
+		return
+	}
+	s.renderAuth(w, http.StatusOK, "login.html", authView{
+		Next:    next,
+		Message: r.URL.Query().Get("msg"),
+	})
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.PostFormValue("username"))
+	password := r.PostFormValue("password")
+	next := safeNext(r.PostFormValue("next"))
+
+	ip := clientIP(r)
+	if d, locked := s.locked(name, ip); locked {
+		s.renderAuth(w, http.StatusTooManyRequests, "login.html", authView{
+			Next:  next,
+			Error: "Too many failed logins. Try again in " + roundUp(d) + ".",
+		})
+		return
+	}
+
+	u, err := s.users.Authenticate(name, password)
+	if err != nil {
+		msg := "Wrong user name or password."
+		status := http.StatusUnauthorized
+		if !errors.Is(err, auth.ErrInvalid) {
+			msg, status = "Login failed: "+err.Error(), http.StatusInternalServerError
+		} else if s.failed(name, ip) {
+			slog.Warn("auth: login locked after repeated failures", "user", name, "ip", ip)
+		} else {
+			slog.Warn("auth: failed login", "user", name, "ip", ip)
+		}
+		s.renderAuth(w, status, "login.html", authView{Next: next, Error: msg})
+		return
+	}
+
+	s.userLockout.Reset(strings.ToLower(name))
+	s.startSession(w, r, u.Name)
+	slog.Info("auth: signed in", "user", u.Name, "ip", ip)
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		s.sessions.Delete(c.Value)
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+	http.Redirect(w, r, "/login?msg="+q("Signed out"), http.StatusSeeOther)
+}
+
+// ---- First-run setup ----
+
+func (s *Server) handleSetupPage(w http.ResponseWriter, r *http.Request) {
+	if hasUsers, err := s.users.HasUsers(); err != nil || hasUsers {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	// All users may have been deleted since startup.
+	s.ensureSetupToken(r.Host)
+
+	token := r.URL.Query().Get("token")
+	v := authView{SetupToken: token}
+	if !s.validSetupToken(token) {
+		v.SetupToken = ""
+		v.Error = "Open the setup link from the service log, or create a user with: httpbackupgo user add NAME"
+	}
+	s.renderAuth(w, http.StatusOK, "setup.html", v)
+}
+
+func (s *Server) handleSetup(w http.ResponseWriter, r *http.Request) {
+	if hasUsers, err := s.users.HasUsers(); err != nil || hasUsers {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	token := r.PostFormValue("token")
+	if !s.validSetupToken(token) {
+		slog.Warn("auth: setup with a wrong token", "ip", clientIP(r))
+		s.renderAuth(w, http.StatusForbidden, "setup.html", authView{Error: "Invalid setup token. Use the link from the service log."})
+		return
+	}
+
+	name := strings.TrimSpace(r.PostFormValue("username"))
+	password := r.PostFormValue("password")
+	if password != r.PostFormValue("confirm") {
+		s.renderAuth(w, http.StatusBadRequest, "setup.html", authView{SetupToken: token, Error: "The passwords do not match."})
+		return
+	}
+	// The first user administers the others.
+	if err := s.users.AddUser(name, password, auth.RoleAdmin, nil); err != nil {
+		s.renderAuth(w, http.StatusBadRequest, "setup.html", authView{SetupToken: token, Error: err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	s.setupToken = ""
+	s.mu.Unlock()
+
+	slog.Info("auth: setup done", "user", name, "ip", clientIP(r))
+	s.startSession(w, r, name)
+	http.Redirect(w, r, "/?msg="+q("Welcome, "+name), http.StatusSeeOther)
+}
+
+// ensureSetupToken creates the setup token and logs the setup link, once.
+func (s *Server) ensureSetupToken(addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.setupToken != "" {
+		return
+	}
+	s.setupToken = auth.NewSetupToken()
+	host := addr
+	if h, port, err := net.SplitHostPort(addr); err == nil && (h == "" || h == "0.0.0.0" || h == "::") {
+		host = net.JoinHostPort("localhost", port)
+	}
+	slog.Warn("auth: no users yet; open the setup link or run `httpbackupgo user add NAME`",
+		"setup_url", s.scheme+"://"+host+"/setup?token="+s.setupToken)
+}
+
+func (s *Server) validSetupToken(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.setupToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.setupToken)) == 1
+}
+
+// ---- Account ----
+
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	s.renderAccount(w, r, http.StatusOK, authView{
+		Message: r.URL.Query().Get("msg"),
+		Error:   r.URL.Query().Get("err"),
+	})
+}
+
+func (s *Server) handlePassword(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r).Name
+	current := r.PostFormValue("current")
+	password := r.PostFormValue("password")
+
+	// The current password is checked like a login, lockout included.
+	ip := clientIP(r)
+	if d, locked := s.locked(user, ip); locked {
+		http.Redirect(w, r, "/account?err="+q("Too many failed attempts. Try again in "+roundUp(d)+"."), http.StatusSeeOther)
+		return
+	}
+	if _, err := s.users.Authenticate(user, current); err != nil {
+		s.failed(user, ip)
+		http.Redirect(w, r, "/account?err="+q("The current password is wrong."), http.StatusSeeOther)
+		return
+	}
+	if password != r.PostFormValue("confirm") {
+		http.Redirect(w, r, "/account?err="+q("The new passwords do not match."), http.StatusSeeOther)
+		return
+	}
+	if err := s.users.SetPassword(user, password); err != nil {
+		http.Redirect(w, r, "/account?err="+q(err.Error()), http.StatusSeeOther)
+		return
+	}
+
+	// Sign out everywhere else.
+	keep := ""
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		keep = c.Value
+	}
+	s.sessions.DeleteUser(user, keep)
+	slog.Info("auth: password changed", "user", user)
+	http.Redirect(w, r, "/account?msg="+q("Password changed; other sessions were signed out"), http.StatusSeeOther)
+}
+
+func (s *Server) handleCreateToken(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r).Name
+	token, t, err := s.users.CreateToken(user, r.PostFormValue("label"))
+	if err != nil {
+		http.Redirect(w, r, "/account?err="+q("Cannot create token: "+err.Error()), http.StatusSeeOther)
+		return
+	}
+	slog.Info("auth: API token created", "user", user, "token_id", t.ID)
+	// Rendered directly, not redirected: the token must not end up in a URL.
+	s.renderAccount(w, r, http.StatusOK, authView{
+		NewToken: token,
+		Message:  "Token created. Copy it now; it is not shown again.",
+	})
+}
+
+func (s *Server) handleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r).Name
+	id := r.PostFormValue("id")
+	if err := s.users.RevokeToken(user, id); err != nil {
+		http.Redirect(w, r, "/account?err="+q("Cannot revoke token: "+err.Error()), http.StatusSeeOther)
+		return
+	}
+	slog.Info("auth: API token revoked", "user", user, "token_id", id)
+	http.Redirect(w, r, "/account?msg="+q("Token revoked"), http.StatusSeeOther)
+}
+
+func (s *Server) renderAccount(w http.ResponseWriter, r *http.Request, status int, v authView) {
+	u := currentUser(r)
+	v.User, v.Role, v.CSRF = u.Name, u.Role, csrfToken(r)
+	tokens, err := s.users.Tokens(u.Name)
+	if err != nil {
+		v.Error = "Cannot load tokens: " + err.Error()
+	}
+	v.Tokens = tokens
+	s.renderAuth(w, status, "account.html", v)
+}
+
+func (s *Server) renderAuth(w http.ResponseWriter, status int, name string, v authView) {
+	v.Now = time.Now().Format(time.RFC3339)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := s.tpl.ExecuteTemplate(w, name, v); err != nil {
+		log.Printf("template execute error (%s): %v", name, err)
+	}
+}
+
+// safeNext keeps redirects after login on this site.
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// clientIP is the address of the TCP peer. X-Forwarded-For is ignored: it
+// is trivially forged, and behind a reverse proxy the proxy is the peer.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func roundUp(d time.Duration) string {
+	m := int((d + time.Minute - 1) / time.Minute)
+	if m <= 1 {
+		return "1 minute"
+	}
+	return strconv.Itoa(m) + " minutes"
+}
@@ -0,0 +1,138 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"httpBackupGo/auth"
+	"httpBackupGo/config"
+	"httpBackupGo/runlog"
+)
+
+func TestViewerCannotChangeAnything(t *testing.T) {
+	ts := newTestServer(t, nil)
+	viewer := ts.addUser("vera", auth.RoleViewer)
+
+	for _, tc := range []struct{ method, path, body string }{
+		{http.MethodPost, "/api/v1/runs", ""},
+		{http.MethodPost, "/api/v1/sites/shop/runs", ""},
+		{http.MethodGet, "/api/v1/config", ""},
+		{http.MethodPut, "/api/v1/config", "{}"},
+		{http.MethodPost, "/api/v1/sites", `{"Name": "new"}`},
+		{http.MethodDelete, "/api/v1/sites/shop", ""},
+		{http.MethodGet, "/admin", ""},
+		{http.MethodPost, "/save", "Retention=1"},
+		{http.MethodPost, "/reload", ""},
+		{http.MethodPost, "/run", ""},
+		{http.MethodGet, "/download?site=shop&key=x", ""},
+	} {
+		if rec := viewer.do(tc.method, tc.path, tc.body); rec.Code != http.StatusForbidden {
+			t.Errorf("%s %s: status %d, want 403", tc.method, tc.path, rec.Code)
+		}
+	}
+	if len(ts.events) != 0 {
+		t.Errorf("a denied request reached the scheduler")
+	}
+
+	for _, path := range []string{"/", "/backups?site=shop", "/history", "/api/v1/status", "/api/v1/runs", "/api/v1/backups"} {
+		if rec := viewer.do(http.MethodGet, path, ""); rec.Code != http.StatusOK {
+			t.Errorf("GET %s: status %d, want 200", path, rec.Code)
+		}
+	}
+}
+
+func TestOperatorNeedsAdminForConfig(t *testing.T) {
+	ts := newTestServer(t, nil)
+	op := ts.addUser("otto", auth.RoleOperator)
+
+	for _, path := range []string{"/api/v1/config", "/api/v1/sites", "/admin"} {
+		if rec := op.do(http.MethodGet, path, ""); rec.Code != http.StatusForbidden {
+			t.Errorf("GET %s: status %d, want 403", path, rec.Code)
+		}
+	}
+	if rec := op.do(http.MethodPost, "/api/v1/runs", ""); rec.Code != http.StatusAccepted {
+		t.Errorf("POST /api/v1/runs: status %d, want 202", rec.Code)
+	}
+}
+
+func TestScopedOperator(t *testing.T) {
+	ts := newTestServer(t, nil)
+	op := ts.addUser("otto", auth.RoleOperator, "shop")
+
+	cfg, err := config.Load(ts.cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blogOnly := finishedRun(t, cfg, "blog")
+	both := finishedRun(t, cfg, "shop", "blog")
+
+	for _, tc := range []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/api/v1/sites/shop/backups", http.StatusOK},
+		{http.MethodGet, "/api/v1/sites/blog/backups", http.StatusNotFound},
+		{http.MethodGet, "/api/v1/sites/" + cfg.Sites[1].ID + "/backups", http.StatusNotFound},
+		{http.MethodGet, "/api/v1/runs/" + blogOnly.ID, http.StatusNotFound},
+		{http.MethodGet, "/api/v1/runs/" + both.ID, http.StatusOK},
+		{http.MethodPost, "/api/v1/sites/blog/runs", http.StatusNotFound},
+	} {
+		if rec := op.do(tc.method, tc.path, ""); rec.Code != tc.want {
+			t.Errorf("%s %s: status %d, want %d", tc.method, tc.path, rec.Code, tc.want)
+		}
+	}
+
+	var backups []apiSiteBackups
+	decode(t, op.do(http.MethodGet, "/api/v1/backups", "").Body.String(), &backups)
+	if len(backups) != 1 || backups[0].Site != "shop" {
+		t.Errorf("backups of %d sites, want only shop: %+v", len(backups), backups)
+	}
+
+	var runs []runlog.Run
+	decode(t, op.do(http.MethodGet, "/api/v1/runs", "").Body.String(), &runs)
+	if len(runs) != 1 || runs[0].ID != both.ID {
+		t.Fatalf("runs = %+v, want only the run with shop", runs)
+	}
+	if len(runs[0].Sites) != 1 || runs[0].Sites[0].Site != "shop" || runs[0].Failed != 0 {
+		t.Errorf("run not trimmed to shop: %+v", runs[0])
+	}
+
+	// "All sites" of a scoped operator are their sites.
+	if rec := op.do(http.MethodPost, "/api/v1/runs", ""); rec.Code != http.StatusAccepted {
+		t.Fatalf("POST /api/v1/runs: status %d", rec.Code)
+	}
+	if ev := <-ts.events; len(ev.Sites) != 1 || ev.Sites[0] != "shop" {
+		t.Errorf("queued sites = %v, want [shop]", ev.Sites)
+	}
+}
+
+// finishedRun records a run of the given sites in which blog failed.
+func finishedRun(t *testing.T, cfg config.Config, sites ...string) runlog.Run {
+	t.Helper()
+	run := runlog.Begin("", "ticker", nil)
+	run.Finished = time.Now()
+	run.Status = runlog.StatusOK
+	for _, s := range sites {
+		sr := runlog.SiteResult{Site: s, Status: runlog.StatusOK}
+		if s == "blog" {
+			sr.Status, sr.Error = runlog.StatusFailed, "blog is down"
+			run.Failed++
+		} else {
+			run.Succeeded++
+		}
+		run.Sites = append(run.Sites, sr)
+	}
+	if err := runlog.Record(cfg.BackupFolder, run); err != nil {
+		t.Fatal(err)
+	}
+	return run
+}
+
+func decode(t *testing.T, body string, v any) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(body), v); err != nil {
+		t.Fatalf("decode %q: %v", body, err)
+	}
+}
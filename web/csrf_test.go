@@ -0,0 +1,84 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"httpBackupGo/auth"
+)
+
+func TestCSRF(t *testing.T) {
+	ts := newTestServer(t, nil)
+	op := ts.addUser("otto", auth.RoleOperator)
+
+	for _, tc := range []struct {
+		name   string
+		path   string
+		header map[string]string
+	}{
+		{"no token", "/api/v1/runs", nil},
+		{"wrong token", "/api/v1/runs", map[string]string{csrfHeader: "nope"}},
+		{"form without token", "/run", nil},
+		{"foreign Origin", "/api/v1/runs", map[string]string{csrfHeader: op.csrf, "Origin": "https://evil.example"}},
+		{"cross-site fetch", "/api/v1/runs", map[string]string{csrfHeader: op.csrf, "Sec-Fetch-Site": "cross-site"}},
+		{"foreign Referer", "/run", map[string]string{csrfHeader: op.csrf, "Referer": "https://evil.example/page"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := op.request(http.MethodPost, tc.path, "")
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			if rec := ts.do(req); rec.Code != http.StatusForbidden {
+				t.Errorf("status %d, want 403", rec.Code)
+			}
+		})
+	}
+	if len(ts.events) != 0 {
+		t.Fatal("a rejected request reached the scheduler")
+	}
+
+	// The token as a form field, from this site.
+	form := url.Values{csrfField: {op.csrf}}.Encode()
+	req := op.request(http.MethodPost, "/run", form)
+	req.Header.Set("Origin", "http://example.com")
+	if rec := ts.do(req); rec.Code != http.StatusSeeOther {
+		t.Errorf("form with token: status %d, want 303", rec.Code)
+	}
+
+	// Login has no session yet, but still gets the origin check.
+	login := url.Values{"username": {"otto"}, "password": {"otto password"}}.Encode()
+	req = httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(login))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://evil.example")
+	if rec := ts.do(req); rec.Code != http.StatusForbidden {
+		t.Errorf("cross-origin login: status %d, want 403", rec.Code)
+	}
+}
+
+func TestBearerSkipsCSRF(t *testing.T) {
+	ts := newTestServer(t, nil)
+	if err := ts.users.AddUser("ci", "ci password", auth.RoleOperator, nil); err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := ts.users.CreateToken("ci", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/runs", strings.NewReader(`{"Sites": ["shop"]}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Origin", "https://elsewhere.example")
+	if rec := ts.do(req); rec.Code != http.StatusAccepted {
+		t.Fatalf("status %d, want 202: %s", rec.Code, rec.Body)
+	}
+
+	// A token does not open the UI pages, which take cookies only.
+	req = httptest.NewRequest(http.MethodPost, "/run", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if rec := ts.do(req); rec.Code != http.StatusSeeOther || !strings.HasPrefix(rec.Header().Get("Location"), "/login") {
+		t.Errorf("POST /run with a token: status %d, Location %q, want the login redirect", rec.Code, rec.Header().Get("Location"))
+	}
+}
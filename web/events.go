@@ -9,4 +9,9 @@ const (
 
 type Event struct {
 	Type EventType
+
+	// EventRunNow only: the sites to run (all enabled when empty) and the
+	// run ID already returned to an API client (empty for the UI button).
+	Sites []string
+	RunID string
 }
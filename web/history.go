@@ -0,0 +1,243 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"httpBackupGo/auth"
+	"httpBackupGo/config"
+	"httpBackupGo/runlog"
+)
+
+// maxLogLines is how many lines the log page shows at most.
+const maxLogLines = 500
+
+type historyView struct {
+	User    string
+	Role    string
+	CSRF    string
+	Message string
+	Error   string
+	Now     string
+
+	Runs []runlog.Run // newest first
+}
+
+type logsView struct {
+	User  string
+	Role  string
+	CSRF  string
+	Error string
+	Now   string
+
+	Level  string // minimum level shown
+	Query  string // substring filter
+	Lines  []logLine
+	Scoped bool // only lines about the user's sites are shown
+}
+
+type logLine struct {
+	Time  string
+	Level string
+	Msg   string
+	Attrs string // the other fields, key=value
+}
+
+// handleHistory lists the recorded runs (see runlog).
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg, err := config.LoadOrCreate(s.cfgPath)
+	if err != nil {
+		http.Error(w, "failed to load config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	u := currentUser(r)
+
+	v := historyView{
+		User:    u.Name,
+		Role:    u.Role,
+		CSRF:    csrfToken(r),
+		Message: r.URL.Query().Get("msg"),
+		Error:   r.URL.Query().Get("err"),
+		Now:     time.Now().Format(time.RFC3339),
+	}
+	runs, err := runlog.Load(cfg.BackupFolder)
+	if err != nil {
+		v.Error = "run history: " + err.Error()
+	}
+	v.Runs = visibleRuns(u, cfg, runs)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := s.tpl.ExecuteTemplate(w, "history.html", v); err != nil {
+		log.Printf("template execute error (history): %v", err)
+	}
+}
+
+// handleLogs shows the newest log lines kept in memory by the service.
+// Users limited to some sites only see lines about those sites.
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg, err := config.LoadOrCreate(s.cfgPath)
+	if err != nil {
+		http.Error(w, "failed to load config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	u := currentUser(r)
+
+	v := logsView{
+		User:   u.Name,
+		Role:   u.Role,
+		CSRF:   csrfToken(r),
+		Now:    time.Now().Format(time.RFC3339),
+		Level:  r.URL.Query().Get("level"),
+		Query:  strings.TrimSpace(r.URL.Query().Get("q")),
+		Scoped: u.Scoped(),
+	}
+	var minLevel slog.Level // info when empty or invalid
+	if err := minLevel.UnmarshalText([]byte(v.Level)); err != nil {
+		minLevel, v.Level = slog.LevelInfo, "info"
+	}
+
+	names := map[string]bool{}
+	for _, site := range visibleSites(u, cfg) {
+		names[site.Name] = true
+	}
+
+	var lines [][]byte
+	if s.logs != nil {
+		lines = s.logs.Lines()
+	}
+	for i := len(lines) - 1; i >= 0 && len(v.Lines) < maxLogLines; i-- {
+		var rec map[string]any
+		if json.Unmarshal(lines[i], &rec) != nil {
+			continue
+		}
+		var level slog.Level
+		if lv, _ := rec[slog.LevelKey].(string); level.UnmarshalText([]byte(lv)) != nil || level < minLevel {
+			continue
+		}
+		if v.Scoped {
+			if site, _ := rec["site"].(string); !names[site] {
+				continue
+			}
+		}
+		if v.Query != "" && !strings.Contains(strings.ToLower(string(lines[i])), strings.ToLower(v.Query)) {
+			continue
+		}
+		v.Lines = append(v.Lines, toLogLine(rec))
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := s.tpl.ExecuteTemplate(w, "logs.html", v); err != nil {
+		log.Printf("template execute error (logs): %v", err)
+	}
+}
+
+func toLogLine(rec map[string]any) logLine {
+	l := logLine{}
+	l.Time, _ = rec[slog.TimeKey].(string)
+	l.Level, _ = rec[slog.LevelKey].(string)
+	l.Msg, _ = rec[slog.MessageKey].(string)
+
+	keys := make([]string, 0, len(rec))
+	for k := range rec {
+		if k != slog.TimeKey && k != slog.LevelKey && k != slog.MessageKey {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	attrs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		val := rec[k]
+		if _, ok := val.(string); !ok {
+			b, _ := json.Marshal(val)
+			val = string(b)
+		}
+		attrs = append(attrs, fmt.Sprintf("%s=%v", k, val))
+	}
+	l.Attrs = strings.Join(attrs, " ")
+	return l
+}
+
+// visibleSites returns the sites u may see, in config order.
+func visibleSites(u auth.User, cfg config.Config) []config.Site {
+	if !u.Scoped() {
+		return cfg.Sites
+	}
+	var out []config.Site
+	for _, site := range cfg.Sites {
+		if u.SeesSite(site.ID, site.Name) {
+			out = append(out, site)
+		}
+	}
+	return out
+}
+
+// visibleRuns trims every run to the sites u may see and drops the runs
+// that did not involve any of them.
+func visibleRuns(u auth.User, cfg config.Config, runs []runlog.Run) []runlog.Run {
+	if !u.Scoped() {
+		return runs
+	}
+	names := map[string]bool{}
+	for _, site := range visibleSites(u, cfg) {
+		names[site.Name] = true
+	}
+
+	out := []runlog.Run{}
+	for _, run := range runs {
+		started := len(run.Sites) > 0
+		trimmed := run
+		trimmed.Requested = nil
+		for _, n := range run.Requested {
+			if names[n] {
+				trimmed.Requested = append(trimmed.Requested, n)
+			}
+		}
+		trimmed.Sites = []runlog.SiteResult{}
+		trimmed.Succeeded, trimmed.Failed = 0, 0
+		for _, sr := range run.Sites {
+			if !names[sr.Site] {
+				continue
+			}
+			trimmed.Sites = append(trimmed.Sites, sr)
+			if sr.Status == runlog.StatusOK {
+				trimmed.Succeeded++
+			} else {
+				trimmed.Failed++
+			}
+		}
+
+		switch {
+		case started && len(trimmed.Sites) == 0:
+			continue // ran, but none of the user's sites
+		case !started && len(run.Requested) > 0 && len(trimmed.Requested) == 0:
+			continue // not started yet (or skipped), for other sites
+		}
+		// The overall status is about the visible sites only.
+		if started && (run.Status == runlog.StatusOK || run.Status == runlog.StatusPartial || run.Status == runlog.StatusFailed) {
+			switch {
+			case trimmed.Failed == 0:
+				trimmed.Status = runlog.StatusOK
+			case trimmed.Succeeded == 0:
+				trimmed.Status = runlog.StatusFailed
+			default:
+				trimmed.Status = runlog.StatusPartial
+			}
+		}
+		out = append(out, trimmed)
+	}
+	return out
+}
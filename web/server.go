@@ -1,18 +1,28 @@
 package web
 
 import (
+	"context"
+	"crypto/tls"
 	"embed"
 	"fmt"
 	"html/template"
+	"io"
 	"io/fs"
 	"log"
+	"mime"
 	"net/http"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"httpBackupGo/auth"
+	"httpBackupGo/catalog"
 	"httpBackupGo/config"
+	"httpBackupGo/encrypt"
+	"httpBackupGo/logging"
+	"httpBackupGo/sweep"
 )
 
 //go:embed templates/*.html
@@ -25,24 +35,95 @@ type Server struct {
 	cfgPath string
 	tpl     *template.Template
 	events  chan<- Event
+	logs    *logging.Recent // for /logs; nil shows no lines
+	scheme  string          // "https" when TLS is enabled, for logged links
+
+	users    *auth.Store
+	sessions *auth.Sessions
+	origins  *http.CrossOriginProtection
+
+	// Failed logins lock the user name, and (at a higher count, since
+	// several users may share an address) the client address.
+	userLockout *auth.Lockout
+	ipLockout   *auth.Lockout
+
+	mu         sync.Mutex
+	setupToken string // first-run setup link; empty once a user exists
+
+	// cfgMu serializes config read-modify-write (the admin form and the
+	// API), so concurrent requests cannot drop each other's changes.
+	cfgMu sync.Mutex
 }
 
 type viewModel struct {
 	ConfigPath string
 	Config     config.Config
 
+	// Backups is filled from the per-site catalogs (never a directory scan).
+	Backups []siteBackups
+	Site    *siteBackups
+
+	// Sweep is the last stale-file sweep, nil before the first one.
+	Sweep *sweep.Report
+
+	User    string // signed-in user
+	Role    string
+	CSRF    string // token for the POST forms (see csrf.go)
 	Message string
 	Error   string
 	Now     string
 }
 
-func StartServer(cfgPath string, addr string, events chan<- Event) error {
-	s := &Server{cfgPath: cfgPath, events: events}
+type siteBackups struct {
+	ID      string
+	Name    string
+	Enabled bool
+	Catalog catalog.Catalog
+	Latest  *catalog.Entry
+	Err     string
+}
+
+// NewServer builds the web UI server for addr. The caller binds and runs it
+// (Serve or ListenAndServe) and stops it with Shutdown.
+func NewServer(cfgPath string, addr string, events chan<- Event, logs *logging.Recent) (*http.Server, error) {
+	cfg, err := config.LoadOrCreate(cfgPath)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		cfgPath:  cfgPath,
+		events:   events,
+		logs:     logs,
+		scheme:   "http",
+		users:    auth.NewStore(auth.PathFor(cfgPath)),
+		origins:  http.NewCrossOriginProtection(),
+		sessions: auth.NewSessions(time.Duration(cfg.Auth.SessionHours) * time.Hour),
+	}
+	lockFor := time.Duration(cfg.Auth.LockoutMinutes) * time.Minute
+	s.userLockout = auth.NewLockout(cfg.Auth.MaxFailures, lockFor, lockFor)
+	s.ipLockout = auth.NewLockout(cfg.Auth.MaxFailures*ipFailureFactor, lockFor, lockFor)
+
+	// TLS settings are read once here; changing them needs a restart.
+	var tlsCfg *tls.Config
+	if cfg.TLS.Enabled {
+		if tlsCfg, err = tlsConfig(cfgPath, cfg.TLS, addr); err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+		s.scheme = "https"
+	}
+
+	hasUsers, err := s.users.HasUsers()
+	if err != nil {
+		return nil, fmt.Errorf("users: %w", err)
+	}
+	if !hasUsers {
+		s.ensureSetupToken(addr)
+	}
 
 	// Parse ALL templates (index.html + admin.html, etc.)
-	tpl, err := template.ParseFS(templatesFS, "templates/*.html")
+	tpl, err := template.New("").Funcs(templateFuncs).ParseFS(templatesFS, "templates/*.html")
 	if err != nil {
-		return fmt.Errorf("parse templates: %w", err)
+		return nil, fmt.Errorf("parse templates: %w", err)
 	}
 	s.tpl = tpl
 
@@ -50,7 +131,7 @@ func StartServer(cfgPath string, addr string, events chan<- Event) error {
 
 	staticSub, err := fs.Sub(staticFS, "static")
 	if err != nil {
-		return fmt.Errorf("static fs sub: %w", err)
+		return nil, fmt.Errorf("static fs sub: %w", err)
 	}
 
 	mux.Handle("/static/",
@@ -59,24 +140,31 @@ func StartServer(cfgPath string, addr string, events chan<- Event) error {
 		),
 	)
 
-	// Pages
-	mux.HandleFunc("/", s.handleHome)       // NEW simple page
-	mux.HandleFunc("/admin", s.handleAdmin) // OLD index moved here
+	// Pages. Each route names the least role it needs (see need).
+	mux.HandleFunc("/", s.need(auth.RoleViewer, s.handleHome))      // NEW simple page
+	mux.HandleFunc("/admin", s.need(auth.RoleAdmin, s.handleAdmin)) // OLD index moved here
+	mux.HandleFunc("/backups", s.need(auth.RoleViewer, s.handleBackups))
+	mux.HandleFunc("/history", s.need(auth.RoleViewer, s.handleHistory))
+	mux.HandleFunc("/logs", s.need(auth.RoleViewer, s.handleLogs))
+	mux.HandleFunc("/download", s.need(auth.RoleOperator, s.handleDownload))
 
 	// Actions (keep as-is)
-	mux.HandleFunc("/save", s.handleSave)
-	mux.HandleFunc("/run", s.handleRun)
-	mux.HandleFunc("/reload", s.handleReload)
+	mux.HandleFunc("/save", s.need(auth.RoleAdmin, s.handleSave))
+	mux.HandleFunc("/run", s.need(auth.RoleOperator, s.handleRun))
+	mux.HandleFunc("/reload", s.need(auth.RoleAdmin, s.handleReload))
+
+	// Login, setup and account pages (see auth.go)
+	s.registerAuth(mux)
 
-	log.Printf("web ui listening on http://%s", addr)
+	// JSON API (see api.go)
+	s.registerAPI(mux)
 
-	srv := &http.Server{
+	return &http.Server{
 		Addr:              addr,
-		Handler:           mux,
+		Handler:           s.requireAuth(mux),
+		TLSConfig:         tlsCfg,
 		ReadHeaderTimeout: 5 * time.Second,
-	}
-
-	return srv.ListenAndServe()
+	}, nil
 }
 
 // NEW: simple landing page with Run button + link to /admin
@@ -95,14 +183,27 @@ func (s *Server) handleHome(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "failed to load config: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	u := currentUser(r)
 
 	vm := viewModel{
 		ConfigPath: s.cfgPath,
 		Config:     cfg,
+		Backups:    loadSiteBackups(r.Context(), cfg, visibleSites(u, cfg)),
+		User:       u.Name,
+		Role:       u.Role,
+		CSRF:       csrfToken(r),
 		Now:        time.Now().Format(time.RFC3339),
 		Message:    r.URL.Query().Get("msg"),
 		Error:      r.URL.Query().Get("err"),
 	}
+	// Leftover files are for the admins to clean up.
+	if u.Can(auth.RoleAdmin) {
+		if rep, ok, err := sweep.LoadReport(cfg.BackupFolder); err != nil {
+			log.Printf("sweep report: %v", err)
+		} else if ok {
+			vm.Sweep = &rep
+		}
+	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	if err := s.tpl.ExecuteTemplate(w, "index.html", vm); err != nil {
@@ -122,10 +223,14 @@ func (s *Server) handleAdmin(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "failed to load config: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	u := currentUser(r)
 
 	vm := viewModel{
 		ConfigPath: s.cfgPath,
 		Config:     cfg,
+		User:       u.Name,
+		Role:       u.Role,
+		CSRF:       csrfToken(r),
 		Now:        time.Now().Format(time.RFC3339),
 		Message:    r.URL.Query().Get("msg"),
 		Error:      r.URL.Query().Get("err"),
@@ -137,6 +242,148 @@ func (s *Server) handleAdmin(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// backups page: catalog listing for one site
+func (s *Server) handleBackups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cfg, err := config.LoadOrCreate(s.cfgPath)
+	if err != nil {
+		http.Error(w, "failed to load config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Sites are linked by ID; names still work for old bookmarks. Sites the
+	// user may not see are unknown.
+	u := currentUser(r)
+	name := r.URL.Query().Get("site")
+	var site *siteBackups
+	for _, cs := range visibleSites(u, cfg) {
+		if cs.ID == name || cs.Name == name {
+			sb := loadSiteBackups(r.Context(), cfg, []config.Site{cs})[0]
+			site = &sb
+			break
+		}
+	}
+	if site == nil {
+		http.Redirect(w, r, "/?err="+q("unknown site: "+name), http.StatusSeeOther)
+		return
+	}
+
+	vm := viewModel{
+		ConfigPath: s.cfgPath,
+		Config:     cfg,
+		Site:       site,
+		User:       u.Name,
+		Role:       u.Role,
+		CSRF:       csrfToken(r),
+		Now:        time.Now().Format(time.RFC3339),
+		Message:    r.URL.Query().Get("msg"),
+		Error:      r.URL.Query().Get("err"),
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := s.tpl.ExecuteTemplate(w, "backups.html", vm); err != nil {
+		log.Printf("template execute error (backups): %v", err)
+	}
+}
+
+// download: streams one catalogued backup. Encrypted backups are decrypted on
+// the fly when the key is available, unless raw=1 asks for the stored bytes.
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cfg, err := config.LoadOrCreate(s.cfgPath)
+	if err != nil {
+		http.Error(w, "failed to load config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	siteName := r.URL.Query().Get("site")
+	key := r.URL.Query().Get("key")
+
+	var site *config.Site
+	for i := range cfg.Sites {
+		if cfg.Sites[i].ID == siteName || cfg.Sites[i].Name == siteName {
+			site = &cfg.Sites[i]
+			break
+		}
+	}
+	if site == nil || !currentUser(r).SeesSite(site.ID, site.Name) {
+		http.NotFound(w, r)
+		return
+	}
+
+	ix, err := catalog.ForSite(cfg, *site)
+	if err != nil {
+		http.Error(w, "storage: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c, err := ix.Load(r.Context())
+	if err != nil {
+		http.Error(w, "catalog: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Only catalogued keys: never let the query pick arbitrary storage keys.
+	var entry *catalog.Entry
+	for i := range c.Entries {
+		if c.Entries[i].Key == key {
+			entry = &c.Entries[i]
+			break
+		}
+	}
+	if entry == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var encKey []byte
+	if strings.HasSuffix(entry.Name, encrypt.Suffix) && r.URL.Query().Get("raw") != "1" {
+		encKey, err = encrypt.LoadKey(encrypt.ForSite(cfg, *site))
+		if err != nil {
+			http.Redirect(w, r, "/backups?site="+q(siteName)+"&err="+q("cannot decrypt: "+err.Error()), http.StatusSeeOther)
+			return
+		}
+	}
+
+	rc, err := ix.Backend.Open(r.Context(), entry.Key)
+	if err != nil {
+		http.Error(w, "open: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer rc.Close()
+
+	var body io.Reader = rc
+	filename := entry.Name
+	if encKey != nil {
+		body, err = encrypt.NewReader(rc, encKey)
+		if err != nil {
+			http.Redirect(w, r, "/backups?site="+q(siteName)+"&err="+q("cannot decrypt: "+err.Error()), http.StatusSeeOther)
+			return
+		}
+		filename = strings.TrimSuffix(entry.Name, encrypt.Suffix)
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	if encKey == nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(entry.Size, 10))
+	}
+
+	// A decryption failure mid-stream can only abort the connection; the
+	// browser then reports an incomplete download.
+	if _, err := io.Copy(w, body); err != nil {
+		log.Printf("download %s: %v", entry.Key, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
 func (s *Server) handleSave(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -147,6 +394,9 @@ func (s *Server) handleSave(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.cfgMu.Lock()
+	defer s.cfgMu.Unlock()
+
 	cfg, err := config.LoadOrCreate(s.cfgPath)
 	if err != nil {
 		http.Redirect(w, r, "/admin?err="+q("failed to load config: "+err.Error()), http.StatusSeeOther)
@@ -158,6 +408,11 @@ func (s *Server) handleSave(w http.ResponseWriter, r *http.Request) {
 	}
 	cfg.IntervalMinutes = parseInt(r.FormValue("IntervalMinutes"), cfg.IntervalMinutes)
 	cfg.Retention = parseInt(r.FormValue("Retention"), cfg.Retention)
+	cfg.ScrubIntervalMinutes = parseInt(r.FormValue("ScrubIntervalMinutes"), cfg.ScrubIntervalMinutes)
+	cfg.ScrubMaxMBps = parseInt(r.FormValue("ScrubMaxMBps"), cfg.ScrubMaxMBps)
+	if _, ok := r.Form["AlertWebhookURL"]; ok {
+		cfg.AlertWebhookURL = strings.TrimSpace(r.FormValue("AlertWebhookURL"))
+	}
 
 	backupFolder := strings.TrimSpace(r.FormValue("BackupFolder"))
 	if backupFolder != "" {
@@ -166,6 +421,7 @@ func (s *Server) handleSave(w http.ResponseWriter, r *http.Request) {
 
 	presentTokens := r.Form["SiteEnabledPresent"]
 	enabledTokens := r.Form["SiteEnabled"]
+	ids := r.Form["SiteID"]
 	names := r.Form["SiteName"]
 	urls := r.Form["SiteUrl"]
 
@@ -176,6 +432,16 @@ func (s *Server) handleSave(w http.ResponseWriter, r *http.Request) {
 		enabledSet[t] = struct{}{}
 	}
 
+	// The form only carries Enabled/Name/Url; keep everything else
+	// (e.g. a per-site Storage override) from the same site. Rows are matched
+	// by ID, so a renamed site keeps its ID and slug (and thus its backups).
+	byID := map[string]config.Site{}
+	existing := map[string]config.Site{}
+	for _, s := range cfg.Sites {
+		byID[s.ID] = s
+		existing[strings.ToLower(s.Name)] = s
+	}
+
 	sites := make([]config.Site, 0, n)
 	for i := 0; i < n; i++ {
 		token := ""
@@ -197,17 +463,29 @@ func (s *Server) handleSave(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
+		id := ""
+		if i < len(ids) {
+			id = strings.TrimSpace(ids[i])
+		}
+
 		_, enabled := enabledSet[token]
 
-		sites = append(sites, config.Site{
-			Enabled: enabled,
-			Name:    name,
-			Url:     url,
-		})
+		site, ok := byID[id]
+		if !ok || id == "" {
+			site = existing[strings.ToLower(name)]
+		}
+		site.Enabled = enabled
+		site.Name = name
+		site.Url = url
+
+		sites = append(sites, site)
 	}
 
 	cfg.Sites = sites
-	cfg.ValidateAndNormalize()
+	if err := cfg.ValidateAndNormalize(); err != nil {
+		http.Redirect(w, r, "/admin?err="+q("invalid config: "+err.Error()), http.StatusSeeOther)
+		return
+	}
 
 	if err := config.Save(s.cfgPath, cfg); err != nil {
 		http.Redirect(w, r, "/admin?err="+q("failed to save config: "+err.Error()), http.StatusSeeOther)
@@ -226,8 +504,27 @@ func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A user limited to some sites runs only their enabled sites.
+	ev := Event{Type: EventRunNow}
+	if u := currentUser(r); u.Scoped() {
+		cfg, err := config.LoadOrCreate(s.cfgPath)
+		if err != nil {
+			http.Redirect(w, r, "/?err="+q("failed to load config: "+err.Error()), http.StatusSeeOther)
+			return
+		}
+		for _, site := range visibleSites(u, cfg) {
+			if site.Enabled {
+				ev.Sites = append(ev.Sites, site.Name)
+			}
+		}
+		if len(ev.Sites) == 0 {
+			http.Redirect(w, r, "/?err="+q("none of your sites is enabled"), http.StatusSeeOther)
+			return
+		}
+	}
+
 	// 🔔 Notify main to run immediately
-	nonBlockingSend(s.events, Event{Type: EventRunNow})
+	nonBlockingSend(s.events, ev)
 
 	// If request came from homepage, send back to "/"
 	if r.URL.Query().Get("from") == "home" {
@@ -251,15 +548,80 @@ func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
 }
 
 // nonBlockingSend prevents the web request from hanging if main is busy.
-// If the channel buffer is full, we just drop the event (safe).
-func nonBlockingSend(ch chan<- Event, ev Event) {
+// If the channel buffer is full, we just drop the event (safe) and report false.
+func nonBlockingSend(ch chan<- Event, ev Event) bool {
 	if ch == nil {
-		return
+		return false
 	}
 	select {
 	case ch <- ev:
+		return true
 	default:
+		return false
+	}
+}
+
+// loadSiteBackups reads the catalog of each of the given sites.
+// Errors are kept per site so one broken catalog doesn't hide the others.
+func loadSiteBackups(ctx context.Context, cfg config.Config, sites []config.Site) []siteBackups {
+	out := make([]siteBackups, 0, len(sites))
+	for _, site := range sites {
+		sb := siteBackups{ID: site.ID, Name: site.Name, Enabled: site.Enabled}
+
+		c, err := loadCatalog(ctx, cfg, site)
+		if err != nil {
+			sb.Err = err.Error()
+		} else {
+			sb.Catalog = c
+			if e, ok := c.Latest(); ok {
+				sb.Latest = &e
+			}
+		}
+		out = append(out, sb)
+	}
+	return out
+}
+
+func loadCatalog(ctx context.Context, cfg config.Config, site config.Site) (catalog.Catalog, error) {
+	ix, err := catalog.ForSite(cfg, site)
+	if err != nil {
+		return catalog.Catalog{}, err
+	}
+	return ix.Load(ctx)
+}
+
+var templateFuncs = template.FuncMap{
+	"bytes": humanBytes,
+	"can":   auth.Allows, // {{if can .Role "operator"}}
+	"ts": func(t time.Time) string {
+		return t.Local().Format("2006-01-02 15:04:05")
+	},
+	"ms": func(ms int64) string {
+		return (time.Duration(ms) * time.Millisecond).Round(100 * time.Millisecond).String()
+	},
+	"encrypted": func(name string) bool {
+		return strings.HasSuffix(name, encrypt.Suffix)
+	},
+	"reverse": func(in []catalog.Entry) []catalog.Entry {
+		out := make([]catalog.Entry, len(in))
+		for i, e := range in {
+			out[len(in)-1-i] = e
+		}
+		return out
+	},
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
 	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
 }
 
 func parseInt(s string, fallback int) int {
@@ -0,0 +1,201 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"httpBackupGo/auth"
+	"httpBackupGo/config"
+)
+
+// testServer is the web UI on a temporary config, served in-process.
+type testServer struct {
+	t       *testing.T
+	h       http.Handler
+	cfgPath string
+	users   *auth.Store
+	events  chan Event
+}
+
+// newTestServer saves cfg (its BackupFolder is set to a temporary directory)
+// and builds the server. edit may change the config first.
+func newTestServer(t *testing.T, edit func(cfg *config.Config)) *testServer {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := config.DefaultConfig()
+	cfg.BackupFolder = filepath.Join(dir, "backups")
+	cfg.Sites = []config.Site{
+		{Enabled: true, Name: "shop", Url: "https://shop.example.com/backup.zip"},
+		{Enabled: true, Name: "blog", Url: "https://blog.example.com/backup.zip"},
+	}
+	if edit != nil {
+		edit(&cfg)
+	}
+	if err := cfg.ValidateAndNormalize(); err != nil {
+		t.Fatal(err)
+	}
+	cfgPath := filepath.Join(dir, "config.json")
+	if err := config.Save(cfgPath, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	users := auth.NewStore(auth.PathFor(cfgPath))
+	if err := users.AddUser("admin", "admin password", auth.RoleAdmin, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan Event, 16)
+	srv, err := NewServer(cfgPath, cfg.WebListenAddr, events, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testServer{t: t, h: srv.Handler, cfgPath: cfgPath, users: users, events: events}
+}
+
+// addUser creates a user and returns a session for them.
+func (ts *testServer) addUser(name, role string, sites ...string) *session {
+	ts.t.Helper()
+	if err := ts.users.AddUser(name, name+" password", role, sites); err != nil {
+		ts.t.Fatal(err)
+	}
+	return ts.login(name, name+" password")
+}
+
+// do serves req and returns the response.
+func (ts *testServer) do(req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	ts.h.ServeHTTP(rec, req)
+	return rec
+}
+
+func (ts *testServer) postLogin(name, password string) *httptest.ResponseRecorder {
+	form := url.Values{"username": {name}, "password": {password}, "next": {"/"}}
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return ts.do(req)
+}
+
+// session is a signed-in browser: its cookie and CSRF token.
+type session struct {
+	ts     *testServer
+	cookie *http.Cookie
+	csrf   string
+}
+
+var csrfInput = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+func (ts *testServer) login(name, password string) *session {
+	ts.t.Helper()
+	rec := ts.postLogin(name, password)
+	if rec.Code != http.StatusSeeOther {
+		ts.t.Fatalf("login %s: status %d", name, rec.Code)
+	}
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sessionCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil {
+		ts.t.Fatalf("login %s: no session cookie", name)
+	}
+
+	s := &session{ts: ts, cookie: cookie}
+	page := s.do(http.MethodGet, "/account", "")
+	m := csrfInput.FindStringSubmatch(page.Body.String())
+	if m == nil {
+		ts.t.Fatalf("no CSRF token on /account (status %d)", page.Code)
+	}
+	s.csrf = m[1]
+	return s
+}
+
+// request builds a same-origin request with the session cookie but no CSRF token.
+func (s *session) request(method, path, body string) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.AddCookie(s.cookie)
+	if strings.HasPrefix(body, "{") {
+		req.Header.Set("Content-Type", "application/json")
+	} else if body != "" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	return req
+}
+
+// do sends a request the way the UI does, with the CSRF token for unsafe methods.
+func (s *session) do(method, path, body string) *httptest.ResponseRecorder {
+	req := s.request(method, path, body)
+	if !safeMethod(method) {
+		req.Header.Set(csrfHeader, s.csrf)
+	}
+	return s.ts.do(req)
+}
+
+func TestLoginLockout(t *testing.T) {
+	ts := newTestServer(t, func(cfg *config.Config) {
+		cfg.Auth.MaxFailures = 3
+	})
+	if err := ts.users.AddUser("alice", "alice password", auth.RoleViewer, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A success resets the count of the user name.
+	for range 2 {
+		if rec := ts.postLogin("alice", "wrong"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong password: status %d", rec.Code)
+		}
+	}
+	ts.login("alice", "alice password")
+	for range 2 {
+		ts.postLogin("ALICE", "wrong")
+	}
+	ts.login("alice", "alice password")
+
+	// Unknown users fail the same way.
+	if rec := ts.postLogin("nobody", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unknown user: status %d", rec.Code)
+	}
+
+	for range 3 {
+		ts.postLogin("alice", "wrong")
+	}
+	if rec := ts.postLogin("alice", "alice password"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("locked user with the right password: status %d, want 429", rec.Code)
+	}
+	// Other users from the same address are not locked yet.
+	ts.login("admin", "admin password")
+}
+
+func TestAddressLockout(t *testing.T) {
+	ts := newTestServer(t, func(cfg *config.Config) {
+		cfg.Auth.MaxFailures = 1
+	})
+	// One failure per name, but ipFailureFactor of them from one address.
+	for i := range ipFailureFactor {
+		ts.postLogin("user"+string(rune('a'+i)), "wrong")
+	}
+	if rec := ts.postLogin("admin", "admin password"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("locked address: status %d, want 429", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/status", nil)
+	req.Header.Set("Authorization", "Bearer hbg_0_0")
+	if rec := ts.do(req); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("token from a locked address: status %d, want 429", rec.Code)
+	}
+}
+
+func jsonBody(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,275 @@
+package web
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"httpBackupGo/config"
+	"httpBackupGo/storage"
+)
+
+// Files of the self-signed certificate, next to the config file.
+const (
+	SelfSignedCertFile = "tls-cert.pem"
+	SelfSignedKeyFile  = "tls-key.pem"
+)
+
+const (
+	// selfSignedValidity stays under the 825 days some clients accept.
+	selfSignedValidity = 800 * 24 * time.Hour
+
+	// renewBefore regenerates a self-signed certificate this long before it expires.
+	renewBefore = 30 * 24 * time.Hour
+
+	// certCheckInterval is how often configured certificate files are
+	// checked for changes (e.g. renewed by certbot).
+	certCheckInterval = time.Minute
+)
+
+// tlsConfig returns the TLS config for the web UI and logs the certificate's
+// SHA-256 fingerprint, which users compare when their browser warns about a
+// self-signed certificate.
+func tlsConfig(cfgPath string, t config.TLS, listenAddr string) (*tls.Config, error) {
+	certFile, keyFile := t.CertFile, t.KeyFile
+	selfSigned := certFile == ""
+	if selfSigned {
+		dir := filepath.Dir(cfgPath)
+		certFile, keyFile = filepath.Join(dir, SelfSignedCertFile), filepath.Join(dir, SelfSignedKeyFile)
+		if err := ensureSelfSigned(certFile, keyFile, listenAddr); err != nil {
+			return nil, fmt.Errorf("self-signed certificate: %w", err)
+		}
+	}
+
+	src := &certSource{certFile: certFile, keyFile: keyFile, selfSigned: selfSigned, listenAddr: listenAddr}
+	if err := src.load(); err != nil {
+		return nil, err
+	}
+	leaf := src.cert.Leaf
+	slog.Info("web ui certificate",
+		"sha256", fingerprint(leaf),
+		"subject", leaf.Subject.String(),
+		"not_after", leaf.NotAfter.Format(time.RFC3339),
+		"self_signed", selfSigned,
+		"cert_file", certFile)
+
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: src.getCertificate,
+	}, nil
+}
+
+// certSource serves a certificate from files and reloads it when they change.
+// A self-signed certificate is also renewed shortly before it expires, since
+// the service may run for longer than it is valid.
+type certSource struct {
+	certFile, keyFile string
+	selfSigned        bool
+	listenAddr        string // hosts of a renewed self-signed certificate
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func (c *certSource) load() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("load certificate: %w", err)
+	}
+	if fi, err := os.Stat(c.certFile); err == nil {
+		c.modTime = fi.ModTime()
+	}
+	c.cert = &cert
+	return nil
+}
+
+func (c *certSource) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.checked) < certCheckInterval {
+		return c.cert, nil
+	}
+	c.checked = time.Now()
+	if c.selfSigned && time.Until(c.cert.Leaf.NotAfter) < renewBefore {
+		if err := ensureSelfSigned(c.certFile, c.keyFile, c.listenAddr); err != nil {
+			slog.Warn("web ui certificate: renewing the self-signed certificate failed", "err", err)
+		}
+	}
+	fi, err := os.Stat(c.certFile)
+	if err != nil || fi.ModTime().Equal(c.modTime) {
+		return c.cert, nil
+	}
+	// Keep serving the old certificate if the new files are half written.
+	old := c.cert
+	if err := c.load(); err != nil {
+		slog.Warn("web ui certificate: reload failed, keeping the old one", "err", err)
+		c.cert = old
+		return c.cert, nil
+	}
+	slog.Info("web ui certificate reloaded", "sha256", fingerprint(c.cert.Leaf), "not_after", c.cert.Leaf.NotAfter.Format(time.RFC3339))
+	return c.cert, nil
+}
+
+// ensureSelfSigned keeps the existing self-signed certificate unless it is
+// missing, unreadable, about to expire or not valid for the listen address.
+func ensureSelfSigned(certFile, keyFile, listenAddr string) error {
+	hosts := certHosts(listenAddr)
+	if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+		leaf := cert.Leaf
+		fresh := time.Until(leaf.NotAfter) > renewBefore
+		covered := true
+		for _, h := range hosts {
+			if leaf.VerifyHostname(h) != nil {
+				covered = false
+			}
+		}
+		if fresh && covered {
+			return nil
+		}
+	} else if !os.IsNotExist(err) {
+		slog.Warn("self-signed certificate unreadable, generating a new one", "err", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hosts[0], Organization: []string{"httpBackupGo self-signed"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	// Key first: a certificate without its key would be useless.
+	if err := writeFileAtomic(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return err
+	}
+	slog.Info("generated a self-signed certificate for the web ui", "hosts", hosts, "cert_file", certFile)
+	return nil
+}
+
+// certHosts are the names and addresses a self-signed certificate is issued
+// for: the listen host, localhost and loopback, and this machine's name.
+func certHosts(listenAddr string) []string {
+	var hosts []string
+	add := func(h string) {
+		h = strings.Trim(h, "[]")
+		if h == "" || h == "0.0.0.0" || h == "::" {
+			return
+		}
+		for _, x := range hosts {
+			if strings.EqualFold(x, h) {
+				return
+			}
+		}
+		hosts = append(hosts, h)
+	}
+	if h, _, err := net.SplitHostPort(listenAddr); err == nil {
+		add(h)
+	}
+	add("localhost")
+	add("127.0.0.1")
+	add("::1")
+	if name, err := os.Hostname(); err == nil {
+		add(name)
+	}
+	return hosts
+}
+
+// fingerprint is the certificate's SHA-256 as colon-separated hex, the way
+// browsers show it.
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	var b bytes.Buffer
+	for i, c := range sum {
+		if i > 0 {
+			b.WriteByte(':')
+		}
+		fmt.Fprintf(&b, "%02X", c)
+	}
+	return b.String()
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + storage.TempSuffix
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// NewRedirectServer answers plain HTTP on addr with a redirect to the same
+// URL over HTTPS on the port of httpsAddr.
+func NewRedirectServer(addr, httpsAddr string) *http.Server {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return &http.Server{
+		Addr:              addr,
+		ReadHeaderTimeout: 5 * time.Second,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host := r.Host
+			if h, _, err := net.SplitHostPort(r.Host); err == nil {
+				host = h
+			}
+			if port != "" && port != "443" {
+				host = net.JoinHostPort(strings.Trim(host, "[]"), port)
+			} else if strings.Contains(host, ":") && !strings.HasPrefix(host, "[") {
+				host = "[" + host + "]" // bare IPv6 address
+			}
+			// 308 keeps the method and body, unlike 301.
+			http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+		}),
+	}
+}